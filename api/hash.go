@@ -0,0 +1,57 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// secretKeyHashPrefix marks the hashes created by SecretKeyHasher, so they can
+// be told apart from the bcrypt hashes written by earlier versions.
+const secretKeyHashPrefix = "$hmac-sha256$"
+
+// legacySecretKeyHashPrefix is the prefix of the bcrypt hashes written by
+// earlier versions of the operator.
+const legacySecretKeyHashPrefix = "$2"
+
+// SecretKeyHasher computes the keyed hashes stored in the SecretKeyStatuses to
+// detect drift of the synced Secrets.
+type SecretKeyHasher struct {
+	key []byte
+}
+
+func NewSecretKeyHasher(key []byte) *SecretKeyHasher {
+	return &SecretKeyHasher{key: key}
+}
+
+// Sum returns the base64 encoded HMAC-SHA256 of value prefixed with
+// secretKeyHashPrefix.
+func (h *SecretKeyHasher) Sum(value []byte) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write(value)
+
+	return secretKeyHashPrefix + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether hash is the keyed hash of value.
+func (h *SecretKeyHasher) Verify(hash string, value []byte) bool {
+	return hmac.Equal([]byte(hash), []byte(h.Sum(value)))
+}
+
+func isLegacySecretKeyHash(hash string) bool {
+	return strings.HasPrefix(hash, legacySecretKeyHashPrefix)
+}
+
+// legacySecretKeyDigest returns the digest of value that was hashed with
+// bcrypt by earlier versions of the operator.
+func legacySecretKeyDigest(value []byte) []byte {
+	encValue := make([]byte, base64.StdEncoding.EncodedLen(len(value)))
+	base64.StdEncoding.Encode(encValue, value)
+	shaValue := sha256.Sum256(encValue)
+
+	return shaValue[:]
+}
@@ -4,25 +4,45 @@
 package api
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
-
 	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
-	"github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"golang.org/x/crypto/bcrypt"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// SetCondition sets the condition of conditionType in conditions, the
+// transition time is only updated when the status changes.
+func SetCondition(conditions *[]metav1.Condition, generation int64, conditionType v1beta1.KeyHubSecretConditionType, status metav1.ConditionStatus, reason v1beta1.KeyHubSecretConditionReason, message string) {
+	meta.SetStatusCondition(conditions, metav1.Condition{
+		Type:               string(conditionType),
+		Status:             status,
+		ObservedGeneration: generation,
+		Reason:             string(reason),
+		Message:            message,
+	})
+}
+
+// AddSecretKeyError records why the key of ref could not be synced.
+func AddSecretKeyError(keyErrors *[]v1beta1.SecretKeyError, ref v1beta1.SecretKeyReference, reason v1beta1.KeyHubSecretConditionReason, message string) {
+	*keyErrors = append(*keyErrors, v1beta1.SecretKeyError{
+		Key:     ref.Name,
+		Record:  ref.Record,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
 // SetVaultRecordStatus sets the corresponding status in statuses to the
 // new status based on record.
 // statuses must be non-nil.
-func SetVaultRecordStatus(statuses *[]v1alpha1.VaultRecordStatus, record *keyhubmodel.VaultRecord) {
+func SetVaultRecordStatus(statuses *[]v1beta1.VaultRecordStatus, record *keyhubmodel.VaultRecord) {
 	if statuses == nil {
 		return
 	}
 
-	newStatus := v1alpha1.VaultRecordStatus{
+	newStatus := v1beta1.VaultRecordStatus{
 		RecordID:       record.UUID,
 		Name:           record.Name,
 		LastModifiedAt: metav1.NewTime(record.LastModifiedAt()),
@@ -39,7 +59,7 @@ func SetVaultRecordStatus(statuses *[]v1alpha1.VaultRecordStatus, record *keyhub
 }
 
 // FindVaultRecordStatus finds the recordID in statuses.
-func FindVaultRecordStatus(statuses []v1alpha1.VaultRecordStatus, recordID string) *v1alpha1.VaultRecordStatus {
+func FindVaultRecordStatus(statuses []v1beta1.VaultRecordStatus, recordID string) *v1beta1.VaultRecordStatus {
 	for i := range statuses {
 		if statuses[i].RecordID == recordID {
 			return &statuses[i]
@@ -49,7 +69,7 @@ func FindVaultRecordStatus(statuses []v1alpha1.VaultRecordStatus, recordID strin
 	return nil
 }
 
-func DeleteVaultRecordStatus(statuses []v1alpha1.VaultRecordStatus, recordID string) []v1alpha1.VaultRecordStatus {
+func DeleteVaultRecordStatus(statuses []v1beta1.VaultRecordStatus, recordID string) []v1beta1.VaultRecordStatus {
 	var d int
 	for i := range statuses {
 		if statuses[i].RecordID == recordID {
@@ -61,44 +81,34 @@ func DeleteVaultRecordStatus(statuses []v1alpha1.VaultRecordStatus, recordID str
 	return append(statuses[:d], statuses[d+1:]...)
 }
 
-func IsVaulRecordChanged(statuses []v1alpha1.VaultRecordStatus, record *keyhubmodel.VaultRecord) bool {
+func IsVaulRecordChanged(statuses []v1beta1.VaultRecordStatus, record *keyhubmodel.VaultRecord) bool {
 	status := FindVaultRecordStatus(statuses, record.UUID)
 	return status == nil || metav1.NewTime(record.LastModifiedAt()).Rfc3339Copy().After(status.LastModifiedAt.Time)
 }
 
-// SetSecretKeyStatus sets the corresponding status in statuses to newStatus.
+// SetSecretKeyStatus sets the corresponding status in statuses to the keyed
+// hash of value.
 // statuses must be non-nil.
-func SetSecretKeyStatus(statuses *[]v1alpha1.SecretKeyStatus, key string, value []byte) error {
+func SetSecretKeyStatus(hasher *SecretKeyHasher, statuses *[]v1beta1.SecretKeyStatus, key string, value []byte) {
 	if statuses == nil {
-		statuses = &[]v1alpha1.SecretKeyStatus{}
-		// return nil
-	}
-
-	encValue := make([]byte, base64.StdEncoding.EncodedLen(len(value)))
-	base64.StdEncoding.Encode(encValue, value)
-	shaValue := sha256.Sum256(encValue)
-	hash, err := bcrypt.GenerateFromPassword(shaValue[:], bcrypt.DefaultCost)
-	if err != nil {
-		return err
+		return
 	}
 
-	newStatus := v1alpha1.SecretKeyStatus{
+	newStatus := v1beta1.SecretKeyStatus{
 		Key:  key,
-		Hash: hash,
+		Hash: hasher.Sum(value),
 	}
 	existingStatus := FindSecretKeyStatus(*statuses, newStatus.Key)
 	if existingStatus == nil {
 		*statuses = append(*statuses, newStatus)
-		return nil
+		return
 	}
 
 	existingStatus.Hash = newStatus.Hash
-
-	return nil
 }
 
 // FindSecretKeyStatus finds the key in statuses.
-func FindSecretKeyStatus(statuses []v1alpha1.SecretKeyStatus, key string) *v1alpha1.SecretKeyStatus {
+func FindSecretKeyStatus(statuses []v1beta1.SecretKeyStatus, key string) *v1beta1.SecretKeyStatus {
 	for i := range statuses {
 		if statuses[i].Key == key {
 			return &statuses[i]
@@ -108,8 +118,8 @@ func FindSecretKeyStatus(statuses []v1alpha1.SecretKeyStatus, key string) *v1alp
 	return nil
 }
 
-func DeleteSecretKeyStatus(statuses []v1alpha1.SecretKeyStatus, keysToRemove map[string]struct{}) []v1alpha1.SecretKeyStatus {
-	ret := make([]v1alpha1.SecretKeyStatus, 0)
+func DeleteSecretKeyStatus(statuses []v1beta1.SecretKeyStatus, keysToRemove map[string]struct{}) []v1beta1.SecretKeyStatus {
+	ret := make([]v1beta1.SecretKeyStatus, 0)
 	for _, status := range statuses {
 		if _, found := keysToRemove[status.Key]; !found {
 			ret = append(ret, status)
@@ -118,12 +128,29 @@ func DeleteSecretKeyStatus(statuses []v1alpha1.SecretKeyStatus, keysToRemove map
 	return ret
 }
 
-// Compares current value of key against expected SecretKeyStatus
-func IsSecretKeyChanged(statuses []v1alpha1.SecretKeyStatus, data map[string][]byte, key string) bool {
-	encValue := make([]byte, base64.StdEncoding.EncodedLen(len(data[key])))
-	base64.StdEncoding.Encode(encValue, data[key])
-	shaValue := sha256.Sum256(encValue)
+// IsSecretKeyChanged compares the current value of key against the expected
+// SecretKeyStatus. Hashes not created by hasher, e.g. legacy bcrypt hashes or
+// hashes created with a rotated key, are reported as changed.
+func IsSecretKeyChanged(hasher *SecretKeyHasher, statuses []v1beta1.SecretKeyStatus, data map[string][]byte, key string) bool {
 	status := FindSecretKeyStatus(statuses, key)
 
-	return status == nil || bcrypt.CompareHashAndPassword(status.Hash, shaValue[:]) != nil
+	return status == nil || !hasher.Verify(status.Hash, data[key])
+}
+
+// MigrateSecretKeyStatuses replaces the legacy bcrypt hashes in statuses
+// whose value still matches data with a keyed hash. Legacy hashes that no
+// longer match are left as is, so the key is synced again.
+func MigrateSecretKeyStatuses(hasher *SecretKeyHasher, statuses []v1beta1.SecretKeyStatus, data map[string][]byte) {
+	for i := range statuses {
+		if !isLegacySecretKeyHash(statuses[i].Hash) {
+			continue
+		}
+
+		value, found := data[statuses[i].Key]
+		if !found || bcrypt.CompareHashAndPassword([]byte(statuses[i].Hash), legacySecretKeyDigest(value)) != nil {
+			continue
+		}
+
+		statuses[i].Hash = hasher.Sum(value)
+	}
 }
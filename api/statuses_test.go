@@ -0,0 +1,130 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testHasher = NewSecretKeyHasher([]byte("VERY_SECRET_HASH_KEY"))
+
+func legacySecretKeyHash(t testing.TB, value []byte) string {
+	hash, err := bcrypt.GenerateFromPassword(legacySecretKeyDigest(value), bcrypt.DefaultCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestIsSecretKeyChanged(t *testing.T) {
+	statuses := []v1beta1.SecretKeyStatus{}
+	SetSecretKeyStatus(testHasher, &statuses, "password", []byte("test1234"))
+
+	if IsSecretKeyChanged(testHasher, statuses, map[string][]byte{"password": []byte("test1234")}, "password") {
+		t.Error("Expected unchanged value to be reported as unchanged")
+	}
+	if !IsSecretKeyChanged(testHasher, statuses, map[string][]byte{"password": []byte("test5678")}, "password") {
+		t.Error("Expected changed value to be reported as changed")
+	}
+	if !IsSecretKeyChanged(testHasher, statuses, map[string][]byte{"password": []byte("test1234")}, "username") {
+		t.Error("Expected key without status to be reported as changed")
+	}
+
+	rotatedHasher := NewSecretKeyHasher([]byte("ROTATED_HASH_KEY"))
+	if !IsSecretKeyChanged(rotatedHasher, statuses, map[string][]byte{"password": []byte("test1234")}, "password") {
+		t.Error("Expected value hashed with another key to be reported as changed")
+	}
+}
+
+func TestSetSecretKeyStatus(t *testing.T) {
+	statuses := []v1beta1.SecretKeyStatus{}
+	SetSecretKeyStatus(testHasher, &statuses, "password", []byte("test1234"))
+	SetSecretKeyStatus(testHasher, &statuses, "password", []byte("test5678"))
+
+	if len(statuses) != 1 {
+		t.Fatalf("Expected 1 status, found %d", len(statuses))
+	}
+	if !strings.HasPrefix(statuses[0].Hash, secretKeyHashPrefix) {
+		t.Errorf("Expected hash to start with %s", secretKeyHashPrefix)
+	}
+	if !testHasher.Verify(statuses[0].Hash, []byte("test5678")) {
+		t.Error("Expected hash of the latest value")
+	}
+}
+
+func TestMigrateSecretKeyStatuses(t *testing.T) {
+	statuses := []v1beta1.SecretKeyStatus{
+		{Key: "username", Hash: legacySecretKeyHash(t, []byte("admin"))},
+		{Key: "password", Hash: legacySecretKeyHash(t, []byte("test1234"))},
+		{Key: "link", Hash: testHasher.Sum([]byte("https://example.io"))},
+	}
+	data := map[string][]byte{
+		"username": []byte("admin"),
+		"password": []byte("changed outside of the operator"),
+		"link":     []byte("https://example.io"),
+	}
+
+	if !IsSecretKeyChanged(testHasher, statuses, data, "username") {
+		t.Error("Expected legacy hash to be reported as changed before migration")
+	}
+
+	MigrateSecretKeyStatuses(testHasher, statuses, data)
+
+	if !testHasher.Verify(statuses[0].Hash, data["username"]) {
+		t.Error("Expected matching legacy hash to be migrated")
+	}
+	if !isLegacySecretKeyHash(statuses[1].Hash) {
+		t.Error("Expected legacy hash of a drifted value to be kept")
+	}
+	if !IsSecretKeyChanged(testHasher, statuses, data, "password") {
+		t.Error("Expected drifted value to be reported as changed after migration")
+	}
+	if !testHasher.Verify(statuses[2].Hash, data["link"]) {
+		t.Error("Expected keyed hash to be kept")
+	}
+}
+
+func BenchmarkIsSecretKeyChanged(b *testing.B) {
+	data := map[string][]byte{"password": []byte("test1234")}
+
+	b.Run("hmac-sha256", func(b *testing.B) {
+		statuses := []v1beta1.SecretKeyStatus{}
+		SetSecretKeyStatus(testHasher, &statuses, "password", data["password"])
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			IsSecretKeyChanged(testHasher, statuses, data, "password")
+		}
+	})
+
+	b.Run("bcrypt", func(b *testing.B) {
+		hash := legacySecretKeyHash(b, data["password"])
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bcrypt.CompareHashAndPassword([]byte(hash), legacySecretKeyDigest(data["password"]))
+		}
+	})
+}
+
+func BenchmarkSetSecretKeyStatus(b *testing.B) {
+	value := []byte("test1234")
+
+	b.Run("hmac-sha256", func(b *testing.B) {
+		statuses := []v1beta1.SecretKeyStatus{}
+		for i := 0; i < b.N; i++ {
+			SetSecretKeyStatus(testHasher, &statuses, "password", value)
+		}
+	})
+
+	b.Run("bcrypt", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			legacySecretKeyHash(b, value)
+		}
+	})
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2020 Topicus Security BV
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+
+	"github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// hmacHashPrefix is the prefix of the keyed hashes of the SecretKeyStatuses.
+// v1alpha1 stores the raw HMAC after the prefix, v1beta1 the base64 encoded
+// HMAC. Other hashes, e.g. legacy bcrypt hashes, are text in both versions.
+const hmacHashPrefix = "$hmac-sha256$"
+
+var _ conversion.Convertible = &KeyHubSecret{}
+
+// ConvertTo converts this KeyHubSecret to the Hub version (v1beta1)
+func (src *KeyHubSecret) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1beta1.KeyHubSecret)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = v1beta1.KeyHubSecretSpec{
+		Target: v1beta1.SecretTarget{
+			Name:           src.Spec.Target.Name,
+			CreationPolicy: v1beta1.CreationPolicy(src.Spec.Target.CreationPolicy),
+		},
+		Template: v1beta1.SecretTemplate{
+			Type:      src.Spec.Template.Type,
+			Immutable: src.Spec.Template.Immutable,
+			Metadata:  v1beta1.SecretTemplateMetadata(src.Spec.Template.Metadata),
+			Data:      src.Spec.Template.Data,
+		},
+		Suspend:              src.Spec.Suspend,
+		RefreshInterval:      src.Spec.RefreshInterval,
+		RevisionHistoryLimit: src.Spec.RevisionHistoryLimit,
+		ServiceAccountName:   src.Spec.ServiceAccountName,
+		DeletionPolicy:       v1beta1.DeletionPolicy(src.Spec.DeletionPolicy),
+	}
+	for _, ref := range src.Spec.Data {
+		dstRef := v1beta1.SecretKeyReference{
+			Name:     ref.Name,
+			Record:   ref.Record,
+			Property: ref.Property,
+			Format:   ref.Format,
+		}
+		if ref.RecordRef != nil {
+			recordRef := v1beta1.VaultRecordReference(*ref.RecordRef)
+			dstRef.RecordRef = &recordRef
+		}
+		dst.Spec.Data = append(dst.Spec.Data, dstRef)
+	}
+	for _, from := range src.Spec.DataFrom {
+		dstFrom := v1beta1.VaultImport{
+			Group:       from.Group,
+			NamePattern: from.NamePattern,
+			Color:       from.Color,
+			Property:    from.Property,
+		}
+		if from.Rewrite != nil {
+			rewrite := v1beta1.KeyRewrite(*from.Rewrite)
+			dstFrom.Rewrite = &rewrite
+		}
+		dst.Spec.DataFrom = append(dst.Spec.DataFrom, dstFrom)
+	}
+
+	dst.Status = v1beta1.KeyHubSecretStatus{
+		Conditions:               src.Status.Conditions,
+		ObservedSecretGeneration: src.Status.ObservedSecretGeneration,
+		SecretName:               src.Status.SecretName,
+	}
+	for _, status := range src.Status.VaultRecordStatuses {
+		dst.Status.VaultRecordStatuses = append(dst.Status.VaultRecordStatuses, v1beta1.VaultRecordStatus(status))
+	}
+	for _, status := range src.Status.SecretKeyStatuses {
+		dst.Status.SecretKeyStatuses = append(dst.Status.SecretKeyStatuses, v1beta1.SecretKeyStatus{
+			Key:          status.Key,
+			Hash:         encodeHash(status.Hash),
+			TemplateHash: encodeHash(status.TemplateHash),
+		})
+	}
+	for _, keyError := range src.Status.SecretKeyErrors {
+		dst.Status.SecretKeyErrors = append(dst.Status.SecretKeyErrors, v1beta1.SecretKeyError{
+			Key:     keyError.Key,
+			Record:  keyError.Record,
+			Reason:  v1beta1.KeyHubSecretConditionReason(keyError.Reason),
+			Message: keyError.Message,
+		})
+	}
+	if src.Status.Certificate != nil {
+		certificate := v1beta1.CertificateStatus(*src.Status.Certificate)
+		dst.Status.Certificate = &certificate
+	}
+	if src.Status.ForcedSync != nil {
+		forcedSync := v1beta1.ForcedSyncStatus(*src.Status.ForcedSync)
+		dst.Status.ForcedSync = &forcedSync
+	}
+	if src.Status.MatchedPolicy != nil {
+		matchedPolicy := v1beta1.PolicyStatus(*src.Status.MatchedPolicy)
+		dst.Status.MatchedPolicy = &matchedPolicy
+	}
+	for _, status := range src.Status.ShadowedPolicies {
+		dst.Status.ShadowedPolicies = append(dst.Status.ShadowedPolicies, v1beta1.PolicyStatus(status))
+	}
+
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1beta1) to this version
+func (dst *KeyHubSecret) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1beta1.KeyHubSecret)
+	dst.ObjectMeta = src.ObjectMeta
+
+	dst.Spec = KeyHubSecretSpec{
+		Target: SecretTarget{
+			Name:           src.Spec.Target.Name,
+			CreationPolicy: CreationPolicy(src.Spec.Target.CreationPolicy),
+		},
+		Template: SecretTemplate{
+			Type:      src.Spec.Template.Type,
+			Immutable: src.Spec.Template.Immutable,
+			Metadata:  SecretTemplateMetadata(src.Spec.Template.Metadata),
+			Data:      src.Spec.Template.Data,
+		},
+		Suspend:              src.Spec.Suspend,
+		RefreshInterval:      src.Spec.RefreshInterval,
+		RevisionHistoryLimit: src.Spec.RevisionHistoryLimit,
+		ServiceAccountName:   src.Spec.ServiceAccountName,
+		DeletionPolicy:       DeletionPolicy(src.Spec.DeletionPolicy),
+	}
+	for _, ref := range src.Spec.Data {
+		dstRef := SecretKeyReference{
+			Name:     ref.Name,
+			Record:   ref.Record,
+			Property: ref.Property,
+			Format:   ref.Format,
+		}
+		if ref.RecordRef != nil {
+			recordRef := VaultRecordReference(*ref.RecordRef)
+			dstRef.RecordRef = &recordRef
+		}
+		dst.Spec.Data = append(dst.Spec.Data, dstRef)
+	}
+	for _, from := range src.Spec.DataFrom {
+		dstFrom := VaultImport{
+			Group:       from.Group,
+			NamePattern: from.NamePattern,
+			Color:       from.Color,
+			Property:    from.Property,
+		}
+		if from.Rewrite != nil {
+			rewrite := KeyRewrite(*from.Rewrite)
+			dstFrom.Rewrite = &rewrite
+		}
+		dst.Spec.DataFrom = append(dst.Spec.DataFrom, dstFrom)
+	}
+
+	dst.Status = KeyHubSecretStatus{
+		Conditions:               src.Status.Conditions,
+		ObservedSecretGeneration: src.Status.ObservedSecretGeneration,
+		SecretName:               src.Status.SecretName,
+		Sync:                     SyncStatus{Status: syncStatusCode(src.Status.Conditions)},
+	}
+	for _, status := range src.Status.VaultRecordStatuses {
+		dst.Status.VaultRecordStatuses = append(dst.Status.VaultRecordStatuses, VaultRecordStatus(status))
+	}
+	for _, status := range src.Status.SecretKeyStatuses {
+		dst.Status.SecretKeyStatuses = append(dst.Status.SecretKeyStatuses, SecretKeyStatus{
+			Key:          status.Key,
+			Hash:         decodeHash(status.Hash),
+			TemplateHash: decodeHash(status.TemplateHash),
+		})
+	}
+	for _, keyError := range src.Status.SecretKeyErrors {
+		dst.Status.SecretKeyErrors = append(dst.Status.SecretKeyErrors, SecretKeyError{
+			Key:     keyError.Key,
+			Record:  keyError.Record,
+			Reason:  KeyHubSecretConditionReason(keyError.Reason),
+			Message: keyError.Message,
+		})
+	}
+	if src.Status.Certificate != nil {
+		certificate := CertificateStatus(*src.Status.Certificate)
+		dst.Status.Certificate = &certificate
+	}
+	if src.Status.ForcedSync != nil {
+		forcedSync := ForcedSyncStatus(*src.Status.ForcedSync)
+		dst.Status.ForcedSync = &forcedSync
+	}
+	if src.Status.MatchedPolicy != nil {
+		matchedPolicy := PolicyStatus(*src.Status.MatchedPolicy)
+		dst.Status.MatchedPolicy = &matchedPolicy
+	}
+	for _, status := range src.Status.ShadowedPolicies {
+		dst.Status.ShadowedPolicies = append(dst.Status.ShadowedPolicies, PolicyStatus(status))
+	}
+
+	return nil
+}
+
+// syncStatusCode derives the sync status of v1alpha1 from the Synced condition
+func syncStatusCode(conditions []metav1.Condition) SyncStatusCode {
+	synced := meta.FindStatusCondition(conditions, string(TypeSynced))
+	if synced == nil {
+		return ""
+	}
+
+	switch synced.Status {
+	case metav1.ConditionTrue:
+		return SyncStatusCodeSynced
+	case metav1.ConditionFalse:
+		return SyncStatusCodeOutOfSync
+	default:
+		return SyncStatusCodeUnknown
+	}
+}
+
+func encodeHash(hash []byte) string {
+	if bytes.HasPrefix(hash, []byte(hmacHashPrefix)) {
+		return hmacHashPrefix + base64.StdEncoding.EncodeToString(hash[len(hmacHashPrefix):])
+	}
+	return string(hash)
+}
+
+func decodeHash(hash string) []byte {
+	if strings.HasPrefix(hash, hmacHashPrefix) {
+		if mac, err := base64.StdEncoding.DecodeString(hash[len(hmacHashPrefix):]); err == nil {
+			return append([]byte(hmacHashPrefix), mac...)
+		}
+	}
+	if hash == "" {
+		return nil
+	}
+	return []byte(hash)
+}
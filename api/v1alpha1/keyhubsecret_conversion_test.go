@@ -0,0 +1,123 @@
+/*
+Copyright 2020 Topicus Security BV
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConvertRoundTrip(t *testing.T) {
+	revisionHistoryLimit := int32(3)
+	now := metav1.NewTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	hmacHash := append([]byte(hmacHashPrefix), 0x00, 0xff, 0x10, 0x80)
+	bcryptHash := []byte("$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy")
+
+	src := &KeyHubSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "sample-ks", Namespace: "default"},
+		Spec: KeyHubSecretSpec{
+			Target: SecretTarget{Name: "target", CreationPolicy: CreationPolicyOwner},
+			Template: SecretTemplate{
+				Type:      corev1.SecretTypeOpaque,
+				Immutable: true,
+				Metadata:  SecretTemplateMetadata{Labels: map[string]string{"app": "foo"}},
+				Data:      map[string]string{"url": "{{ .db.link }}"},
+			},
+			Data: []SecretKeyReference{
+				{Name: "db", Record: "00000000-0000-0000-1001-000000000002", Property: "password", Format: "bcrypt"},
+				{Name: "user", RecordRef: &VaultRecordReference{Group: "Group", Name: "Record.*", Regex: true}, Property: "username"},
+			},
+			DataFrom:             []VaultImport{{Group: "Group", NamePattern: ".*", Color: "GREEN", Property: "password", Rewrite: &KeyRewrite{Regex: " ", Replacement: "-"}}},
+			Suspend:              true,
+			RefreshInterval:      &metav1.Duration{Duration: time.Hour},
+			RevisionHistoryLimit: &revisionHistoryLimit,
+			ServiceAccountName:   "deployer",
+			DeletionPolicy:       DeletionPolicyRetain,
+		},
+		Status: KeyHubSecretStatus{
+			Conditions: []metav1.Condition{
+				{Type: string(TypeSynced), Status: metav1.ConditionTrue, Reason: string(SecretSynced), LastTransitionTime: now},
+			},
+			ObservedSecretGeneration: 2,
+			SecretName:               "target-0123456789",
+			Sync:                     SyncStatus{Status: SyncStatusCodeSynced},
+			VaultRecordStatuses:      []VaultRecordStatus{{RecordID: "00000000-0000-0000-1001-000000000002", Name: "Record", LastModifiedAt: now}},
+			SecretKeyStatuses: []SecretKeyStatus{
+				{Key: "db", Hash: hmacHash},
+				{Key: "url", Hash: bcryptHash, TemplateHash: hmacHash},
+			},
+			SecretKeyErrors: []SecretKeyError{{Key: "user", Reason: RecordAmbiguous, Message: "ambiguous"}},
+			Certificate:     &CertificateStatus{RecordID: "00000000-0000-0000-1001-000000000003", CommonName: "example.com", NotAfter: now},
+			ForcedSync:      &ForcedSyncStatus{Request: "1", SyncedAt: now},
+			MatchedPolicy:   &PolicyStatus{Policy: "type=namespace name='default'", ClientID: "CLIENT-0001", Source: "Group/Client (uuid) #0"},
+			ShadowedPolicies: []PolicyStatus{
+				{Policy: "type=namespace nameRegex='def.*'", ClientID: "CLIENT-0002"},
+			},
+		},
+	}
+
+	hub := &v1beta1.KeyHubSecret{}
+	if err := src.ConvertTo(hub); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hub.Status.SecretKeyStatuses[0].Hash, hmacHashPrefix) || hub.Status.SecretKeyStatuses[0].Hash == string(hmacHash) {
+		t.Errorf("Expected base64 encoded keyed hash, got %q", hub.Status.SecretKeyStatuses[0].Hash)
+	}
+	if hub.Status.SecretKeyStatuses[1].Hash != string(bcryptHash) {
+		t.Errorf("Expected legacy hash to be kept, got %q", hub.Status.SecretKeyStatuses[1].Hash)
+	}
+
+	dst := &KeyHubSecret{}
+	if err := dst.ConvertFrom(hub); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(src, dst) {
+		t.Errorf("Expected round trip to be lossless\nsource:    %+v\nconverted: %+v", src, dst)
+	}
+}
+
+func TestConvertFromSyncStatus(t *testing.T) {
+	tests := []struct {
+		status metav1.ConditionStatus
+		code   SyncStatusCode
+	}{
+		{status: metav1.ConditionTrue, code: SyncStatusCodeSynced},
+		{status: metav1.ConditionFalse, code: SyncStatusCodeOutOfSync},
+		{status: metav1.ConditionUnknown, code: SyncStatusCodeUnknown},
+	}
+
+	for _, tt := range tests {
+		hub := &v1beta1.KeyHubSecret{
+			Status: v1beta1.KeyHubSecretStatus{
+				Conditions: []metav1.Condition{{Type: string(v1beta1.TypeSynced), Status: tt.status}},
+			},
+		}
+		dst := &KeyHubSecret{}
+		if err := dst.ConvertFrom(hub); err != nil {
+			t.Fatal(err)
+		}
+		if dst.Status.Sync.Status != tt.code {
+			t.Errorf("Expected sync status %s for condition %s, got %s", tt.code, tt.status, dst.Status.Sync.Status)
+		}
+	}
+}
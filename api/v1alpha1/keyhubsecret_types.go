@@ -26,24 +26,117 @@ import (
 
 const SecretTypeApachePasswordFile corev1.SecretType = "kubernetes.io/htpasswd"
 
+// ForceSyncAnnotation requests an immediate sync of the Secret, bypassing the
+// caches and change detection. Every new value of the annotation, e.g. a
+// timestamp, triggers one forced sync.
+const ForceSyncAnnotation = "keyhub.topicus.nl/force-sync"
+
 // KeyHubSecretSpec defines the desired state of KeyHubSecret
 // +kubebuilder:validation:XPreserveUnknownFields
 type KeyHubSecretSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// +optional
+	Target SecretTarget `json:"target,omitempty"`
+
 	// +optional
 	Template SecretTemplate `json:"template,omitempty"`
 
-	Data []SecretKeyReference `json:"data"`
+	// +optional
+	Data []SecretKeyReference `json:"data,omitempty"`
+
+	// DataFrom imports the records of KeyHub group vaults, a key is added
+	// for every matching record
+	// +optional
+	DataFrom []VaultImport `json:"dataFrom,omitempty"`
+
+	// Suspend stops the operator from syncing the Secret
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+
+	// RefreshInterval is the interval in which the Secret is synced with
+	// KeyHub, defaults to the refresh interval of the operator
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// RevisionHistoryLimit is the number of previous immutable Secrets to
+	// keep, next to the current one
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default:=2
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// ServiceAccountName references a ServiceAccount in the namespace of the
+	// KeyHubSecret, used to select the policy of type serviceaccount
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+
+	// DeletionPolicy defines what happens to the Secret when the KeyHubSecret
+	// is deleted
+	// +kubebuilder:validation:Enum=Delete;Retain;Orphan
+	// +kubebuilder:default:="Delete"
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
 }
 
+// DeletionPolicy defines what happens to the Secret when the KeyHubSecret is deleted
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes the Secret together with the KeyHubSecret
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyRetain keeps the Secret, its owner reference to the
+	// KeyHubSecret is removed
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+	// DeletionPolicyOrphan is an alias of DeletionPolicyRetain
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+// SecretTarget defines the Secret the KeyHub vault records are synced to
+type SecretTarget struct {
+	// Name of the Secret, defaults to the name of the KeyHubSecret
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// CreationPolicy defines how the operator manages the Secret
+	// +kubebuilder:validation:Enum=Owner;Merge;None
+	// +kubebuilder:default:="Owner"
+	// +optional
+	CreationPolicy CreationPolicy `json:"creationPolicy,omitempty"`
+}
+
+// CreationPolicy defines how the operator manages the Secret
+type CreationPolicy string
+
+const (
+	// CreationPolicyOwner creates the Secret and makes the KeyHubSecret its
+	// controller
+	CreationPolicyOwner CreationPolicy = "Owner"
+	// CreationPolicyMerge syncs the keys of spec.data into an existing Secret
+	// without owning it, other keys are left untouched
+	CreationPolicyMerge CreationPolicy = "Merge"
+	// CreationPolicyNone only validates the policy and the KeyHub vault
+	// records, the Secret is not created or updated
+	CreationPolicyNone CreationPolicy = "None"
+)
+
 type SecretTemplate struct {
 	// +optional
 	Type corev1.SecretType `json:"type,omitempty"`
 
+	// Immutable creates immutable Secrets, named after the target with a
+	// hash of the content as suffix. Every change creates a new Secret.
+	// +optional
+	Immutable bool `json:"immutable,omitempty"`
+
 	// +optional
 	Metadata SecretTemplateMetadata `json:"metadata,omitempty"`
+
+	// Data defines additional keys rendered from Go templates over the
+	// KeyHub vault records of spec.data, only for Opaque Secrets
+	// +optional
+	Data map[string]string `json:"data,omitempty"`
 }
 
 type SecretTemplateMetadata struct {
@@ -58,7 +151,14 @@ type SecretTemplateMetadata struct {
 type SecretKeyReference struct {
 	Name string `json:"name"`
 
-	Record string `json:"record"`
+	// Record is the UUID of the KeyHub vault record, either record or
+	// recordRef must be set
+	// +optional
+	Record string `json:"record,omitempty"`
+
+	// RecordRef references the KeyHub vault record by group and name
+	// +optional
+	RecordRef *VaultRecordReference `json:"recordRef,omitempty"`
 
 	// +kubebuilder:default:="password"
 	Property string `json:"property,omitempty"`
@@ -67,16 +167,97 @@ type SecretKeyReference struct {
 	Format string `json:"format,omitempty"`
 }
 
+// VaultImport selects the records of a KeyHub group vault to import, the
+// key names are derived from the record names
+type VaultImport struct {
+	// Group is the name or UUID of the KeyHub group
+	Group string `json:"group"`
+
+	// NamePattern is a regular expression the whole record name must match
+	// +optional
+	NamePattern string `json:"namePattern,omitempty"`
+
+	// Color only imports records with this color, e.g. GREEN
+	// +optional
+	Color string `json:"color,omitempty"`
+
+	// +kubebuilder:default:="password"
+	Property string `json:"property,omitempty"`
+
+	// Rewrite derives the key names from the record names, invalid key
+	// characters are replaced by an underscore afterwards
+	// +optional
+	Rewrite *KeyRewrite `json:"rewrite,omitempty"`
+}
+
+// KeyRewrite replaces the matches of a regular expression in a record name
+type KeyRewrite struct {
+	Regex string `json:"regex"`
+
+	// Replacement may reference capture groups of the regular expression,
+	// e.g. ${1}
+	// +optional
+	Replacement string `json:"replacement,omitempty"`
+}
+
+// VaultRecordReference references a KeyHub vault record by the group owning
+// the vault and the name of the record, it must match exactly one record
+type VaultRecordReference struct {
+	// Group is the name or UUID of the KeyHub group
+	Group string `json:"group"`
+
+	// Name is the name of the record, or a regular expression matching the
+	// whole name when regex is set
+	Name string `json:"name"`
+
+	// +optional
+	Regex bool `json:"regex,omitempty"`
+}
+
 type KeyHubSecretConditionType string
 
 var (
-	TypeSynced KeyHubSecretConditionType = "Synced"
+	TypeReady               KeyHubSecretConditionType = "Ready"
+	TypeSynced              KeyHubSecretConditionType = "Synced"
+	TypeRecordsResolved     KeyHubSecretConditionType = "RecordsResolved"
+	TypePolicyMatched       KeyHubSecretConditionType = "PolicyMatched"
+	TypeCertificateValid    KeyHubSecretConditionType = "CertificateValid"
+	TypeCertificateExpiring KeyHubSecretConditionType = "CertificateExpiring"
 )
 
 type KeyHubSecretConditionReason string
 
 var (
 	AwaitingSync KeyHubSecretConditionReason = "AwaitingSync"
+	Suspended    KeyHubSecretConditionReason = "Suspended"
+	NotManaged   KeyHubSecretConditionReason = "NotManaged"
+
+	Ready          KeyHubSecretConditionReason = "Ready"
+	SecretSynced   KeyHubSecretConditionReason = "SecretSynced"
+	SyncFailed     KeyHubSecretConditionReason = "SyncFailed"
+	KeysFailed     KeyHubSecretConditionReason = "KeysFailed"
+	PolicyMatched  KeyHubSecretConditionReason = "PolicyMatched"
+	NoPolicyMatch  KeyHubSecretConditionReason = "NoPolicyMatch"
+	PolicyConflict KeyHubSecretConditionReason = "PolicyConflict"
+
+	RecordsResolved       KeyHubSecretConditionReason = "RecordsResolved"
+	RecordNotFound        KeyHubSecretConditionReason = "RecordNotFound"
+	VaultIndexUnavailable KeyHubSecretConditionReason = "VaultIndexUnavailable"
+	RecordAmbiguous       KeyHubSecretConditionReason = "RecordAmbiguous"
+	ImportFailed          KeyHubSecretConditionReason = "ImportFailed"
+	RecordRetrievalFailed KeyHubSecretConditionReason = "RecordRetrievalFailed"
+	UnsupportedProperty   KeyHubSecretConditionReason = "UnsupportedProperty"
+	FormatFailed          KeyHubSecretConditionReason = "FormatFailed"
+	TemplateFailed        KeyHubSecretConditionReason = "TemplateFailed"
+
+	CertificateValid        KeyHubSecretConditionReason = "CertificateValid"
+	CertificateKeyMismatch  KeyHubSecretConditionReason = "CertificateKeyMismatch"
+	CertificateChainInvalid KeyHubSecretConditionReason = "CertificateChainInvalid"
+	CertificateExpired      KeyHubSecretConditionReason = "CertificateExpired"
+	CertificateNotYetValid  KeyHubSecretConditionReason = "CertificateNotYetValid"
+
+	CertificateExpiresSoon KeyHubSecretConditionReason = "CertificateExpiresSoon"
+	CertificateNotExpiring KeyHubSecretConditionReason = "CertificateNotExpiring"
 )
 
 type SyncStatusCode string
@@ -107,10 +288,39 @@ type VaultRecordStatus struct {
 	LastModifiedAt metav1.Time `json:"lastModifiedAt,omitempty"`
 }
 
+// SecretKeyError describes why a key could not be synced
+type SecretKeyError struct {
+	// Key is the name of the failed key in spec.data
+	Key string `json:"key"`
+
+	// +optional
+	Record string `json:"record,omitempty"`
+
+	Reason KeyHubSecretConditionReason `json:"reason"`
+
+	Message string `json:"message"`
+}
+
+// CertificateStatus describes the certificate synced to a TLS secret
+type CertificateStatus struct {
+	// RecordID is the UUID of the vault record containing the certificate
+	RecordID string `json:"recordID"`
+
+	// +optional
+	CommonName string `json:"commonName,omitempty"`
+
+	// NotAfter is the timestamp the certificate expires
+	NotAfter metav1.Time `json:"notAfter"`
+}
+
 type SecretKeyStatus struct {
 	Key string `json:"key"`
 
 	Hash []byte `json:"hash"`
+
+	// TemplateHash is the hash of the template the key was rendered from
+	// +optional
+	TemplateHash []byte `json:"templateHash,omitempty"`
 }
 
 // KeyHubSecretStatus defines the observed state of KeyHubSecret
@@ -123,6 +333,10 @@ type KeyHubSecretStatus struct {
 
 	ObservedSecretGeneration int64 `json:"observedGeneration,omitempty"`
 
+	// SecretName is the name of the current Secret
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
 	Sync SyncStatus `json:"sync,omitempty"`
 
 	// +optional
@@ -130,6 +344,46 @@ type KeyHubSecretStatus struct {
 
 	// +optional
 	SecretKeyStatuses []SecretKeyStatus `json:"secretKeyStatuses,omitempty"`
+
+	// +optional
+	SecretKeyErrors []SecretKeyError `json:"secretKeyErrors,omitempty"`
+
+	// +optional
+	Certificate *CertificateStatus `json:"certificate,omitempty"`
+
+	// +optional
+	ForcedSync *ForcedSyncStatus `json:"forcedSync,omitempty"`
+
+	// MatchedPolicy is the policy selecting the KeyHub client
+	// +optional
+	MatchedPolicy *PolicyStatus `json:"matchedPolicy,omitempty"`
+
+	// ShadowedPolicies also match the KeyHubSecret, but have a lower
+	// precedence than the matched policy
+	// +optional
+	ShadowedPolicies []PolicyStatus `json:"shadowedPolicies,omitempty"`
+}
+
+// PolicyStatus describes a policy matching the KeyHubSecret
+type PolicyStatus struct {
+	// Policy lists the fields of the policy
+	Policy string `json:"policy"`
+
+	ClientID string `json:"clientID"`
+
+	// Source is the vault record defining the policy
+	// +optional
+	Source string `json:"source,omitempty"`
+}
+
+// ForcedSyncStatus describes the last sync requested with the
+// ForceSyncAnnotation
+type ForcedSyncStatus struct {
+	// Request is the value of the annotation that requested the sync
+	Request string `json:"request"`
+
+	// SyncedAt is the time of the forced sync
+	SyncedAt metav1.Time `json:"syncedAt"`
 }
 
 type VaultRecordState2 struct {
@@ -160,6 +414,7 @@ type VaultRecordState2 struct {
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Sync Status",type="string",JSONPath=".status.sync.status",description="Sync state of the Secret"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="Readiness of the Secret"
 
 // KeyHubSecret is the Schema for the keyhubsecrets API
 type KeyHubSecret struct {
@@ -26,6 +26,38 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CertificateStatus) DeepCopyInto(out *CertificateStatus) {
+	*out = *in
+	in.NotAfter.DeepCopyInto(&out.NotAfter)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CertificateStatus.
+func (in *CertificateStatus) DeepCopy() *CertificateStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(CertificateStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForcedSyncStatus) DeepCopyInto(out *ForcedSyncStatus) {
+	*out = *in
+	in.SyncedAt.DeepCopyInto(&out.SyncedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForcedSyncStatus.
+func (in *ForcedSyncStatus) DeepCopy() *ForcedSyncStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ForcedSyncStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KeyHubSecret) DeepCopyInto(out *KeyHubSecret) {
 	*out = *in
@@ -88,11 +120,31 @@ func (in *KeyHubSecretList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *KeyHubSecretSpec) DeepCopyInto(out *KeyHubSecretSpec) {
 	*out = *in
+	out.Target = in.Target
 	in.Template.DeepCopyInto(&out.Template)
 	if in.Data != nil {
 		in, out := &in.Data, &out.Data
 		*out = make([]SecretKeyReference, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.DataFrom != nil {
+		in, out := &in.DataFrom, &out.DataFrom
+		*out = make([]VaultImport, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
 	}
 }
 
@@ -131,6 +183,31 @@ func (in *KeyHubSecretStatus) DeepCopyInto(out *KeyHubSecretStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.SecretKeyErrors != nil {
+		in, out := &in.SecretKeyErrors, &out.SecretKeyErrors
+		*out = make([]SecretKeyError, len(*in))
+		copy(*out, *in)
+	}
+	if in.Certificate != nil {
+		in, out := &in.Certificate, &out.Certificate
+		*out = new(CertificateStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ForcedSync != nil {
+		in, out := &in.ForcedSync, &out.ForcedSync
+		*out = new(ForcedSyncStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MatchedPolicy != nil {
+		in, out := &in.MatchedPolicy, &out.MatchedPolicy
+		*out = new(PolicyStatus)
+		**out = **in
+	}
+	if in.ShadowedPolicies != nil {
+		in, out := &in.ShadowedPolicies, &out.ShadowedPolicies
+		*out = make([]PolicyStatus, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyHubSecretStatus.
@@ -143,9 +220,59 @@ func (in *KeyHubSecretStatus) DeepCopy() *KeyHubSecretStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyRewrite) DeepCopyInto(out *KeyRewrite) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyRewrite.
+func (in *KeyRewrite) DeepCopy() *KeyRewrite {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyRewrite)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PolicyStatus) DeepCopyInto(out *PolicyStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PolicyStatus.
+func (in *PolicyStatus) DeepCopy() *PolicyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PolicyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyError) DeepCopyInto(out *SecretKeyError) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyError.
+func (in *SecretKeyError) DeepCopy() *SecretKeyError {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyError)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretKeyReference) DeepCopyInto(out *SecretKeyReference) {
 	*out = *in
+	if in.RecordRef != nil {
+		in, out := &in.RecordRef, &out.RecordRef
+		*out = new(VaultRecordReference)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyReference.
@@ -166,6 +293,11 @@ func (in *SecretKeyStatus) DeepCopyInto(out *SecretKeyStatus) {
 		*out = make([]byte, len(*in))
 		copy(*out, *in)
 	}
+	if in.TemplateHash != nil {
+		in, out := &in.TemplateHash, &out.TemplateHash
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyStatus.
@@ -178,10 +310,32 @@ func (in *SecretKeyStatus) DeepCopy() *SecretKeyStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretTarget) DeepCopyInto(out *SecretTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTarget.
+func (in *SecretTarget) DeepCopy() *SecretTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretTemplate) DeepCopyInto(out *SecretTemplate) {
 	*out = *in
 	in.Metadata.DeepCopyInto(&out.Metadata)
+	if in.Data != nil {
+		in, out := &in.Data, &out.Data
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretTemplate.
@@ -238,6 +392,41 @@ func (in *SyncStatus) DeepCopy() *SyncStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultImport) DeepCopyInto(out *VaultImport) {
+	*out = *in
+	if in.Rewrite != nil {
+		in, out := &in.Rewrite, &out.Rewrite
+		*out = new(KeyRewrite)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultImport.
+func (in *VaultImport) DeepCopy() *VaultImport {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultImport)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *VaultRecordReference) DeepCopyInto(out *VaultRecordReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new VaultRecordReference.
+func (in *VaultRecordReference) DeepCopy() *VaultRecordReference {
+	if in == nil {
+		return nil
+	}
+	out := new(VaultRecordReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *VaultRecordState2) DeepCopyInto(out *VaultRecordState2) {
 	*out = *in
@@ -0,0 +1,98 @@
+/*
+Copyright 2020 Topicus Security BV
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterKeyHubSecretLabel is set on the KeyHubSecrets generated for a
+// ClusterKeyHubSecret, its value is the name of the ClusterKeyHubSecret
+const ClusterKeyHubSecretLabel = "keyhub.topicus.nl/cluster-keyhubsecret"
+
+// ClusterKeyHubSecretSpec defines the desired state of ClusterKeyHubSecret
+type ClusterKeyHubSecretSpec struct {
+	// NamespaceSelector selects the namespaces the Secret is generated in,
+	// an empty selector selects all namespaces
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+
+	// KeyHubSecretName is the name of the KeyHubSecret generated in every
+	// selected namespace, defaults to the name of the ClusterKeyHubSecret
+	// +optional
+	KeyHubSecretName string `json:"keyhubSecretName,omitempty"`
+
+	// KeyHubSecretSpec is the spec of the generated KeyHubSecrets. The
+	// KeyHub client serving a namespace is selected by the policies.
+	KeyHubSecretSpec KeyHubSecretSpec `json:"keyhubSecretSpec"`
+}
+
+// NamespaceSyncStatus describes the KeyHubSecret generated in a namespace
+type NamespaceSyncStatus struct {
+	Namespace string `json:"namespace"`
+
+	// Ready is the status of the Ready condition of the KeyHubSecret
+	Ready metav1.ConditionStatus `json:"ready"`
+
+	// +optional
+	Reason KeyHubSecretConditionReason `json:"reason,omitempty"`
+
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// SecretName is the name of the current Secret in the namespace
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+}
+
+// ClusterKeyHubSecretStatus defines the observed state of ClusterKeyHubSecret
+type ClusterKeyHubSecretStatus struct {
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Namespaces lists the sync results of the selected namespaces
+	// +optional
+	Namespaces []NamespaceSyncStatus `json:"namespaces,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="Readiness of the Secrets"
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].reason",description="Reason of the readiness"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterKeyHubSecret is the Schema for the clusterkeyhubsecrets API
+type ClusterKeyHubSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterKeyHubSecretSpec   `json:"spec,omitempty"`
+	Status ClusterKeyHubSecretStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterKeyHubSecretList contains a list of ClusterKeyHubSecret
+type ClusterKeyHubSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterKeyHubSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterKeyHubSecret{}, &ClusterKeyHubSecretList{})
+}
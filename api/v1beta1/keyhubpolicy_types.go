@@ -0,0 +1,144 @@
+/*
+Copyright 2020 Topicus Security BV
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// KeyHubPolicySpec defines the desired state of KeyHubPolicy, the fields
+// match those of the policies in the comments of the Policy Vault records
+type KeyHubPolicySpec struct {
+	// Type is the kind of object the policy matches
+	// +kubebuilder:validation:Enum=namespace;keyhubsecret;serviceaccount
+	Type string `json:"type"`
+
+	// Priority precedes the other precedence rules, higher wins
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// Name, NameRegex, LabelSelector and AnnotationSelector match the
+	// namespace, KeyHubSecret or ServiceAccount, depending on the type
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// +optional
+	NameRegex string `json:"nameRegex,omitempty"`
+
+	// +optional
+	LabelSelector string `json:"labelSelector,omitempty"`
+
+	// +optional
+	AnnotationSelector string `json:"annotationSelector,omitempty"`
+
+	// Namespace and NamespaceRegex restrict the keyhubsecret and
+	// serviceaccount policies to namespaces
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// +optional
+	NamespaceRegex string `json:"namespaceRegex,omitempty"`
+
+	// Effect of the policy, a deny policy restricts the records of the
+	// matching KeyHubSecrets regardless of the priority of the allow policies
+	// +kubebuilder:validation:Enum=allow;deny
+	// +kubebuilder:default:="allow"
+	// +optional
+	Effect string `json:"effect,omitempty"`
+
+	// Records restricts the KeyHub vault records the matching KeyHubSecrets
+	// may reference. An allow policy without records allows all records of
+	// its client, a deny policy without records denies all records.
+	// +optional
+	Records []KeyHubPolicyRecordRule `json:"records,omitempty"`
+
+	// Credentials of the KeyHub application used for the matching
+	// KeyHubSecrets, required for allow policies
+	// +optional
+	Credentials KeyHubPolicyCredentials `json:"credentials,omitempty"`
+}
+
+// KeyHubPolicyRecordRule matches KeyHub vault records, every field set must
+// match
+type KeyHubPolicyRecordRule struct {
+	// Group is the name or UUID of the KeyHub group owning the vault
+	// +optional
+	Group string `json:"group,omitempty"`
+
+	// UUID of the record
+	// +optional
+	UUID string `json:"uuid,omitempty"`
+
+	// NamePattern is a regular expression the whole record name must match
+	// +optional
+	NamePattern string `json:"namePattern,omitempty"`
+}
+
+// KeyHubPolicyCredentials references the client credentials of a KeyHub
+// application, exactly one of secretRef and vaultRecord must be set
+type KeyHubPolicyCredentials struct {
+	// SecretRef references a Secret with the keys clientId and clientSecret
+	// +optional
+	SecretRef *corev1.SecretReference `json:"secretRef,omitempty"`
+
+	// VaultRecord is the UUID of a record in a vault read by the operator,
+	// with the client ID as username and the client secret as password
+	// +optional
+	VaultRecord string `json:"vaultRecord,omitempty"`
+}
+
+// KeyHubPolicyStatus defines the observed state of KeyHubPolicy
+type KeyHubPolicyStatus struct {
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ClientID is the client ID of the KeyHub application
+	// +optional
+	ClientID string `json:"clientID,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:printcolumn:name="Type",type="string",JSONPath=".spec.type",description="Type of the policy"
+// +kubebuilder:printcolumn:name="Priority",type="integer",JSONPath=".spec.priority",description="Priority of the policy"
+// +kubebuilder:printcolumn:name="Client",type="string",JSONPath=".status.clientID",description="Client ID of the KeyHub application"
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status",description="Readiness of the policy"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// KeyHubPolicy is the Schema for the keyhubpolicies API
+type KeyHubPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   KeyHubPolicySpec   `json:"spec,omitempty"`
+	Status KeyHubPolicyStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// KeyHubPolicyList contains a list of KeyHubPolicy
+type KeyHubPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []KeyHubPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&KeyHubPolicy{}, &KeyHubPolicyList{})
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2020 Topicus Security BV
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// Names of the keys of TLS and SSH authentication secrets, see the Secret
+// builders
+const (
+	tlsBundlePEM    = "pem"
+	tlsBundlePKCS12 = "pkcs12"
+	tlsCAKey        = "ca.crt"
+	sshAuthKey      = "key"
+)
+
+var supportedProperties = []string{"username", "password", "link", "file", "lastModifiedAt"}
+
+func (r *KeyHubSecret) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-keyhub-topicus-nl-v1beta1-keyhubsecret,mutating=false,failurePolicy=fail,sideEffects=None,groups=keyhub.topicus.nl,resources=keyhubsecrets,verbs=create;update,versions=v1beta1,name=vkeyhubsecret.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &KeyHubSecret{}
+
+// ValidateCreate implements webhook.Validator
+func (r *KeyHubSecret) ValidateCreate() error {
+	return r.validateKeyHubSecret()
+}
+
+// ValidateUpdate implements webhook.Validator, updates that leave the spec
+// as is are allowed, so the finalizer can always be removed
+func (r *KeyHubSecret) ValidateUpdate(old runtime.Object) error {
+	if oldKeyHubSecret, ok := old.(*KeyHubSecret); ok && equality.Semantic.DeepEqual(oldKeyHubSecret.Spec, r.Spec) {
+		return nil
+	}
+	return r.validateKeyHubSecret()
+}
+
+// ValidateDelete implements webhook.Validator
+func (r *KeyHubSecret) ValidateDelete() error {
+	return nil
+}
+
+// validateKeyHubSecret rejects the specs the Secret builders would fail on
+func (r *KeyHubSecret) validateKeyHubSecret() error {
+	allErrs := r.Spec.validate(field.NewPath("spec"))
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("KeyHubSecret").GroupKind(), r.Name, allErrs)
+}
+
+func (spec *KeyHubSecretSpec) validate(specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	dataPath := specPath.Child("data")
+	templatePath := specPath.Child("template")
+
+	secretType := spec.Template.Type
+	if secretType == "" {
+		secretType = corev1.SecretTypeOpaque
+	}
+
+	keys := make(map[string]struct{})
+	for i, ref := range spec.Data {
+		allErrs = append(allErrs, ref.validate(dataPath.Index(i), secretType)...)
+		if _, found := keys[ref.Name]; found {
+			allErrs = append(allErrs, field.Duplicate(dataPath.Index(i).Child("name"), ref.Name))
+		}
+		keys[ref.Name] = struct{}{}
+	}
+
+	for key := range spec.Template.Data {
+		keyPath := templatePath.Child("data").Key(key)
+		if _, found := keys[key]; found {
+			allErrs = append(allErrs, field.Duplicate(keyPath, key))
+		}
+		for _, msg := range validation.IsConfigMapKey(key) {
+			allErrs = append(allErrs, field.Invalid(keyPath, key, msg))
+		}
+	}
+
+	for i, from := range spec.DataFrom {
+		allErrs = append(allErrs, from.validate(specPath.Child("dataFrom").Index(i))...)
+	}
+
+	if secretType != corev1.SecretTypeOpaque {
+		if len(spec.Template.Data) > 0 {
+			allErrs = append(allErrs, field.Forbidden(templatePath.Child("data"), fmt.Sprintf("Template data is not supported for Secret type '%s'", secretType)))
+		}
+		if len(spec.DataFrom) > 0 {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("dataFrom"), fmt.Sprintf("Data from is not supported for Secret type '%s'", secretType)))
+		}
+	}
+
+	switch secretType {
+	case corev1.SecretTypeBasicAuth:
+		if len(spec.Data) != 1 {
+			allErrs = append(allErrs, field.Invalid(dataPath, len(spec.Data), "Expected one key for basic authentication"))
+		}
+	case corev1.SecretTypeSSHAuth:
+		if len(spec.Data) != 1 {
+			allErrs = append(allErrs, field.Invalid(dataPath, len(spec.Data), "Expected one key for SSH authentication"))
+		}
+		for i, ref := range spec.Data {
+			if ref.Name != sshAuthKey {
+				allErrs = append(allErrs, field.NotSupported(dataPath.Index(i).Child("name"), ref.Name, []string{sshAuthKey}))
+			}
+		}
+	case corev1.SecretTypeTLS:
+		allErrs = append(allErrs, validateTLSKeys(dataPath, spec.Data)...)
+	}
+
+	if spec.ServiceAccountName != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(spec.ServiceAccountName) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("serviceAccountName"), spec.ServiceAccountName, msg))
+		}
+	}
+
+	if spec.Target.CreationPolicy == CreationPolicyMerge {
+		if spec.Template.Type != "" {
+			allErrs = append(allErrs, field.Forbidden(templatePath.Child("type"), "Secret type is not supported with creation policy Merge"))
+		}
+		if spec.Template.Immutable {
+			allErrs = append(allErrs, field.Forbidden(templatePath.Child("immutable"), "Immutable Secrets are not supported with creation policy Merge"))
+		}
+	}
+
+	return allErrs
+}
+
+// validateTLSKeys accepts either a single pem or pkcs12 bundle, or the keys
+// tls.crt and tls.key with an optional ca.crt
+func validateTLSKeys(dataPath *field.Path, data []SecretKeyReference) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if len(data) == 1 {
+		if data[0].Name != tlsBundlePEM && data[0].Name != tlsBundlePKCS12 {
+			allErrs = append(allErrs, field.NotSupported(dataPath.Index(0).Child("name"), data[0].Name, []string{tlsBundlePEM, tlsBundlePKCS12}))
+		}
+		return allErrs
+	}
+	if len(data) < 1 || len(data) > 3 {
+		return append(allErrs, field.Invalid(dataPath, len(data), "Expected one to three keys for TLS secret"))
+	}
+
+	found := make(map[string]struct{})
+	for i, ref := range data {
+		switch ref.Name {
+		case corev1.TLSCertKey, corev1.TLSPrivateKeyKey, tlsCAKey:
+			found[ref.Name] = struct{}{}
+		default:
+			allErrs = append(allErrs, field.NotSupported(dataPath.Index(i).Child("name"), ref.Name, []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, tlsCAKey}))
+		}
+	}
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if _, ok := found[key]; !ok {
+			allErrs = append(allErrs, field.Required(dataPath, fmt.Sprintf("Missing key '%s' for TLS secret", key)))
+		}
+	}
+	return allErrs
+}
+
+func (ref *SecretKeyReference) validate(refPath *field.Path, secretType corev1.SecretType) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(refPath.Child("name"), "Key name is required"))
+	} else if secretType == corev1.SecretTypeOpaque {
+		for _, msg := range validation.IsConfigMapKey(ref.Name) {
+			allErrs = append(allErrs, field.Invalid(refPath.Child("name"), ref.Name, msg))
+		}
+	}
+
+	switch {
+	case ref.Record != "" && ref.RecordRef != nil:
+		allErrs = append(allErrs, field.Forbidden(refPath.Child("recordRef"), "Only one of record and recordRef can be set"))
+	case ref.Record != "":
+		if _, err := uuid.Parse(ref.Record); err != nil {
+			allErrs = append(allErrs, field.Invalid(refPath.Child("record"), ref.Record, "Record must be a valid UUID"))
+		}
+	case ref.RecordRef != nil:
+		allErrs = append(allErrs, ref.RecordRef.validate(refPath.Child("recordRef"))...)
+	default:
+		allErrs = append(allErrs, field.Required(refPath.Child("record"), "Either record or recordRef must be set"))
+	}
+
+	if ref.Property != "" {
+		allErrs = append(allErrs, validateProperty(refPath.Child("property"), ref.Property)...)
+	}
+
+	if ref.Format != "" {
+		formatPath := refPath.Child("format")
+		switch {
+		case secretType == corev1.SecretTypeTLS && ref.Name == tlsCAKey:
+			// The name of the key holding the CA certificate chain
+			for _, msg := range validation.IsConfigMapKey(ref.Format) {
+				allErrs = append(allErrs, field.Invalid(formatPath, ref.Format, msg))
+			}
+		case secretType == corev1.SecretTypeOpaque:
+			if ref.Format != "bcrypt" {
+				allErrs = append(allErrs, field.NotSupported(formatPath, ref.Format, []string{"bcrypt"}))
+			}
+		default:
+			allErrs = append(allErrs, field.Forbidden(formatPath, fmt.Sprintf("Format is not supported for Secret type '%s'", secretType)))
+		}
+	}
+
+	return allErrs
+}
+
+func (ref *VaultRecordReference) validate(refPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if ref.Group == "" {
+		allErrs = append(allErrs, field.Required(refPath.Child("group"), "Group is required"))
+	}
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(refPath.Child("name"), "Name is required"))
+	} else if ref.Regex {
+		allErrs = append(allErrs, validateRegex(refPath.Child("name"), ref.Name)...)
+	}
+	return allErrs
+}
+
+func (from *VaultImport) validate(fromPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if from.Group == "" {
+		allErrs = append(allErrs, field.Required(fromPath.Child("group"), "Group is required"))
+	}
+	if from.NamePattern != "" {
+		allErrs = append(allErrs, validateRegex(fromPath.Child("namePattern"), from.NamePattern)...)
+	}
+	if from.Property != "" {
+		allErrs = append(allErrs, validateProperty(fromPath.Child("property"), from.Property)...)
+	}
+	if from.Rewrite != nil {
+		allErrs = append(allErrs, validateRegex(fromPath.Child("rewrite", "regex"), from.Rewrite.Regex)...)
+	}
+	return allErrs
+}
+
+func validateProperty(propertyPath *field.Path, property string) field.ErrorList {
+	for _, supported := range supportedProperties {
+		if property == supported {
+			return nil
+		}
+	}
+	return field.ErrorList{field.NotSupported(propertyPath, property, supportedProperties)}
+}
+
+func validateRegex(regexPath *field.Path, expr string) field.ErrorList {
+	if _, err := regexp.Compile(expr); err != nil {
+		return field.ErrorList{field.Invalid(regexPath, expr, err.Error())}
+	}
+	return nil
+}
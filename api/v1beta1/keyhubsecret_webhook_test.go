@@ -0,0 +1,200 @@
+/*
+Copyright 2020 Topicus Security BV
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const testRecord = "00000000-0000-0000-1001-000000000002"
+
+func TestValidateKeyHubSecret(t *testing.T) {
+	tests := []struct {
+		name string
+		spec KeyHubSecretSpec
+		err  string
+	}{
+		{
+			name: "opaque",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{
+				{Name: "username", Record: testRecord, Property: "username"},
+				{Name: "password", RecordRef: &VaultRecordReference{Group: "Group", Name: "Record.*", Regex: true}, Format: "bcrypt"},
+			}},
+		},
+		{
+			name: "duplicate key",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{
+				{Name: "password", Record: testRecord},
+				{Name: "password", Record: testRecord},
+			}},
+			err: "spec.data[1].name: Duplicate value",
+		},
+		{
+			name: "invalid key",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{{Name: "pass word", Record: testRecord}}},
+			err:  "spec.data[0].name: Invalid value",
+		},
+		{
+			name: "invalid uuid",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{{Name: "password", Record: "not-a-uuid"}}},
+			err:  "spec.data[0].record: Invalid value",
+		},
+		{
+			name: "missing record",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{{Name: "password"}}},
+			err:  "spec.data[0].record: Required value",
+		},
+		{
+			name: "record and recordRef",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{{Name: "password", Record: testRecord, RecordRef: &VaultRecordReference{Group: "Group", Name: "Record"}}}},
+			err:  "spec.data[0].recordRef: Forbidden",
+		},
+		{
+			name: "invalid recordRef regex",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{{Name: "password", RecordRef: &VaultRecordReference{Group: "Group", Name: "Record(", Regex: true}}}},
+			err:  "spec.data[0].recordRef.name: Invalid value",
+		},
+		{
+			name: "invalid service account",
+			spec: KeyHubSecretSpec{ServiceAccountName: "Deployer", Data: []SecretKeyReference{{Name: "password", Record: testRecord}}},
+			err:  "spec.serviceAccountName: Invalid value",
+		},
+		{
+			name: "unknown property",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{{Name: "password", Record: testRecord, Property: "secret"}}},
+			err:  "spec.data[0].property: Unsupported value",
+		},
+		{
+			name: "unknown format",
+			spec: KeyHubSecretSpec{Data: []SecretKeyReference{{Name: "password", Record: testRecord, Format: "md5"}}},
+			err:  "spec.data[0].format: Unsupported value",
+		},
+		{
+			name: "basic auth with two keys",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeBasicAuth},
+				Data: []SecretKeyReference{
+					{Name: "username", Record: testRecord},
+					{Name: "password", Record: testRecord},
+				},
+			},
+			err: "Expected one key for basic authentication",
+		},
+		{
+			name: "ssh auth",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeSSHAuth},
+				Data:     []SecretKeyReference{{Name: "key", Record: testRecord}},
+			},
+		},
+		{
+			name: "ssh auth with invalid name",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeSSHAuth},
+				Data:     []SecretKeyReference{{Name: "id_rsa", Record: testRecord}},
+			},
+			err: "spec.data[0].name: Unsupported value",
+		},
+		{
+			name: "tls bundle",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeTLS},
+				Data:     []SecretKeyReference{{Name: "pkcs12", Record: testRecord}},
+			},
+		},
+		{
+			name: "tls with invalid bundle",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeTLS},
+				Data:     []SecretKeyReference{{Name: "p12", Record: testRecord}},
+			},
+			err: "spec.data[0].name: Unsupported value",
+		},
+		{
+			name: "tls keys",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeTLS},
+				Data: []SecretKeyReference{
+					{Name: "tls.crt", Record: testRecord},
+					{Name: "tls.key", Record: testRecord},
+					{Name: "ca.crt", Record: testRecord, Format: "tls.ca"},
+				},
+			},
+		},
+		{
+			name: "tls without private key",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeTLS},
+				Data: []SecretKeyReference{
+					{Name: "tls.crt", Record: testRecord},
+					{Name: "ca.crt", Record: testRecord},
+				},
+			},
+			err: "Missing key 'tls.key' for TLS secret",
+		},
+		{
+			name: "template data overlapping data",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Data: map[string]string{"password": "{{ .password.password }}"}},
+				Data:     []SecretKeyReference{{Name: "password", Record: testRecord}},
+			},
+			err: "spec.template.data[password]: Duplicate value",
+		},
+		{
+			name: "data from with invalid name pattern",
+			spec: KeyHubSecretSpec{DataFrom: []VaultImport{{Group: "Group", NamePattern: "Record("}}},
+			err:  "spec.dataFrom[0].namePattern: Invalid value",
+		},
+		{
+			name: "data from for basic auth",
+			spec: KeyHubSecretSpec{
+				Template: SecretTemplate{Type: corev1.SecretTypeBasicAuth},
+				Data:     []SecretKeyReference{{Name: "credentials", Record: testRecord}},
+				DataFrom: []VaultImport{{Group: "Group"}},
+			},
+			err: "spec.dataFrom: Forbidden",
+		},
+		{
+			name: "merge with type",
+			spec: KeyHubSecretSpec{
+				Target:   SecretTarget{CreationPolicy: CreationPolicyMerge},
+				Template: SecretTemplate{Type: corev1.SecretTypeBasicAuth},
+				Data:     []SecretKeyReference{{Name: "credentials", Record: testRecord}},
+			},
+			err: "spec.template.type: Forbidden",
+		},
+	}
+
+	for _, tt := range tests {
+		ks := &KeyHubSecret{Spec: tt.spec}
+		ks.Name = "sample-ks"
+
+		err := ks.ValidateCreate()
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/topicuskeyhub/keyhub-vault-operator/api"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+)
+
+// ClusterKeyHubSecretReconciler reconciles a ClusterKeyHubSecret object. It
+// generates a KeyHubSecret in every selected namespace, these are synced by
+// the KeyHubSecretReconciler with the KeyHub client of the policy matching
+// their namespace.
+type ClusterKeyHubSecretReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+// +kubebuilder:rbac:groups=keyhub.topicus.nl,resources=clusterkeyhubsecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=keyhub.topicus.nl,resources=clusterkeyhubsecrets/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=keyhub.topicus.nl,resources=clusterkeyhubsecrets/finalizers,verbs=update
+
+func (r *ClusterKeyHubSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("clusterkeyhubsecret", req.Name)
+
+	cks := &keyhubv1beta1.ClusterKeyHubSecret{}
+	if err := r.Get(ctx, req.NamespacedName, cks); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("ClusterKeyHubSecret resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get ClusterKeyHubSecret")
+		return ctrl.Result{}, err
+	}
+
+	// The generated KeyHubSecrets are garbage collected
+	if cks.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(&cks.Spec.NamespaceSelector)
+	if err != nil {
+		api.SetCondition(&cks.Status.Conditions, cks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionFalse, keyhubv1beta1.InvalidNamespaceSelector, err.Error())
+		cks.Status.Namespaces = nil
+		r.Recorder.Event(cks, "Warning", string(keyhubv1beta1.InvalidNamespaceSelector), err.Error())
+		// Retried on the next update of the spec
+		return ctrl.Result{}, r.Status().Update(ctx, cks)
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		log.Error(err, "Failed to list namespaces")
+		return ctrl.Result{}, err
+	}
+
+	selected := make(map[string]struct{})
+	statuses := make([]keyhubv1beta1.NamespaceSyncStatus, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		if ns.DeletionTimestamp != nil {
+			continue
+		}
+		selected[ns.Name] = struct{}{}
+		statuses = append(statuses, r.generateKeyHubSecret(ctx, cks, ns.Name))
+	}
+
+	if err := r.pruneKeyHubSecrets(ctx, cks, selected); err != nil {
+		log.Error(err, "Failed to delete KeyHubSecrets of deselected namespaces")
+		r.Recorder.Event(cks, "Warning", "PruneFailed", err.Error())
+		return ctrl.Result{}, err
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Namespace < statuses[j].Namespace })
+	cks.Status.Namespaces = statuses
+	setClusterReadyCondition(cks)
+
+	if err := r.Status().Update(ctx, cks); err != nil {
+		log.Error(err, "Failed to update ClusterKeyHubSecret status")
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// generateKeyHubSecret creates or updates the KeyHubSecret of cks in
+// namespace and returns its sync status. A KeyHubSecret with the same name
+// that is not controlled by cks is left untouched.
+func (r *ClusterKeyHubSecretReconciler) generateKeyHubSecret(ctx context.Context, cks *keyhubv1beta1.ClusterKeyHubSecret, namespace string) keyhubv1beta1.NamespaceSyncStatus {
+	status := keyhubv1beta1.NamespaceSyncStatus{Namespace: namespace, Ready: metav1.ConditionFalse}
+
+	ks := &keyhubv1beta1.KeyHubSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      keyhubSecretName(cks),
+			Namespace: namespace,
+		},
+	}
+	res, err := controllerutil.CreateOrPatch(ctx, r.Client, ks, func() error {
+		if !ks.CreationTimestamp.IsZero() && !metav1.IsControlledBy(ks, cks) {
+			return &keyHubSecretConflictError{namespace: namespace, name: ks.Name}
+		}
+		if err := controllerutil.SetControllerReference(cks, ks, r.Scheme); err != nil {
+			return err
+		}
+		if ks.Labels == nil {
+			ks.Labels = make(map[string]string)
+		}
+		ks.Labels[keyhubv1beta1.ClusterKeyHubSecretLabel] = cks.Name
+		ks.Spec = *cks.Spec.KeyHubSecretSpec.DeepCopy()
+		return nil
+	})
+	if err != nil {
+		status.Reason = keyhubv1beta1.GenerateFailed
+		var conflictErr *keyHubSecretConflictError
+		if goerrors.As(err, &conflictErr) {
+			status.Reason = keyhubv1beta1.KeyHubSecretConflict
+		}
+		status.Message = err.Error()
+		r.Recorder.Event(cks, "Warning", string(status.Reason), err.Error())
+		return status
+	}
+
+	if res == controllerutil.OperationResultCreated {
+		r.Recorder.Event(cks, "Normal", "KeyHubSecretCreated", fmt.Sprintf("KeyHubSecret has been created in namespace %s", namespace))
+	}
+
+	ready := meta.FindStatusCondition(ks.Status.Conditions, string(keyhubv1beta1.TypeReady))
+	if ready == nil || ready.ObservedGeneration != ks.Generation {
+		status.Ready = metav1.ConditionUnknown
+		status.Reason = keyhubv1beta1.AwaitingSync
+		status.Message = "KeyHubSecret has not been synced yet"
+		return status
+	}
+
+	status.Ready = ready.Status
+	status.Reason = keyhubv1beta1.KeyHubSecretConditionReason(ready.Reason)
+	status.Message = ready.Message
+	status.SecretName = ks.Status.SecretName
+	return status
+}
+
+// pruneKeyHubSecrets deletes the KeyHubSecrets controlled by cks outside of
+// the selected namespaces, or with a previous name
+func (r *ClusterKeyHubSecretReconciler) pruneKeyHubSecrets(ctx context.Context, cks *keyhubv1beta1.ClusterKeyHubSecret, selected map[string]struct{}) error {
+	list := &keyhubv1beta1.KeyHubSecretList{}
+	if err := r.List(ctx, list, client.MatchingLabels{keyhubv1beta1.ClusterKeyHubSecretLabel: cks.Name}); err != nil {
+		return err
+	}
+
+	for i := range list.Items {
+		ks := &list.Items[i]
+		if !metav1.IsControlledBy(ks, cks) || ks.DeletionTimestamp != nil {
+			continue
+		}
+		if _, found := selected[ks.Namespace]; found && ks.Name == keyhubSecretName(cks) {
+			continue
+		}
+
+		r.Log.Info("Deleting KeyHubSecret", "keyhubsecret", client.ObjectKeyFromObject(ks))
+		if err := r.Delete(ctx, ks); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		r.Recorder.Event(cks, "Normal", "KeyHubSecretDeleted", fmt.Sprintf("KeyHubSecret has been deleted from namespace %s", ks.Namespace))
+	}
+
+	return nil
+}
+
+// setClusterReadyCondition summarizes the sync results of the namespaces, the
+// ClusterKeyHubSecret is ready when the Secret is ready in every namespace.
+func setClusterReadyCondition(cks *keyhubv1beta1.ClusterKeyHubSecret) {
+	if len(cks.Status.Namespaces) == 0 {
+		api.SetCondition(&cks.Status.Conditions, cks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionTrue, keyhubv1beta1.NoNamespaces, "No namespaces match the namespace selector")
+		return
+	}
+
+	var failed []string
+	var pending []string
+	for _, status := range cks.Status.Namespaces {
+		switch status.Ready {
+		case metav1.ConditionTrue:
+		case metav1.ConditionFalse:
+			failed = append(failed, fmt.Sprintf("%s (%s)", status.Namespace, status.Reason))
+		default:
+			pending = append(pending, status.Namespace)
+		}
+	}
+
+	switch {
+	case len(failed) > 0:
+		api.SetCondition(&cks.Status.Conditions, cks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionFalse, keyhubv1beta1.NamespacesFailed,
+			fmt.Sprintf("Secret is not ready in namespace(s): %s", strings.Join(failed, ", ")))
+	case len(pending) > 0:
+		api.SetCondition(&cks.Status.Conditions, cks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionUnknown, keyhubv1beta1.AwaitingSync,
+			fmt.Sprintf("Secret has not been synced yet in namespace(s): %s", strings.Join(pending, ", ")))
+	default:
+		api.SetCondition(&cks.Status.Conditions, cks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionTrue, keyhubv1beta1.Ready,
+			fmt.Sprintf("Secret is ready in %d namespace(s)", len(cks.Status.Namespaces)))
+	}
+}
+
+// keyhubSecretName returns the name of the KeyHubSecrets generated for cks
+func keyhubSecretName(cks *keyhubv1beta1.ClusterKeyHubSecret) string {
+	if cks.Spec.KeyHubSecretName != "" {
+		return cks.Spec.KeyHubSecretName
+	}
+	return cks.Name
+}
+
+// keyHubSecretConflictError reports a KeyHubSecret that is in the way of a
+// generated KeyHubSecret
+type keyHubSecretConflictError struct {
+	namespace string
+	name      string
+}
+
+func (e *keyHubSecretConflictError) Error() string {
+	return fmt.Sprintf("KeyHubSecret %s/%s already exists and is not managed by the ClusterKeyHubSecret", e.namespace, e.name)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterKeyHubSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&keyhubv1beta1.ClusterKeyHubSecret{}).
+		Owns(&keyhubv1beta1.KeyHubSecret{}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForNamespace)).
+		Complete(r)
+}
+
+// requestsForNamespace enqueues all ClusterKeyHubSecrets when a namespace
+// changes, as its labels may now (no longer) match their selector
+func (r *ClusterKeyHubSecretReconciler) requestsForNamespace(obj client.Object) []reconcile.Request {
+	list := &keyhubv1beta1.ClusterKeyHubSecretList{}
+	if err := r.List(context.Background(), list); err != nil {
+		r.Log.Error(err, "Failed to list ClusterKeyHubSecrets", "namespace", obj.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, cks := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&cks)})
+	}
+	return requests
+}
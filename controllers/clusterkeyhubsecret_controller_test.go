@@ -0,0 +1,204 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("ClusterKeyHubSecret Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	const fanOutLabel = "keyhub.topicus.nl/fan-out"
+
+	var manifestToLog interface{}
+
+	BeforeEach(func() {
+		manifestToLog = nil
+
+		// Flush caches
+		policyEngine.Flush()
+		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
+
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+
+		// Reset Prometheus collectors
+		controllerMetrics.Reset()
+	})
+
+	AfterEach(func() {
+		// Add any teardown steps that needs to be executed after each test
+		controllers_test.LogManifest(manifestToLog)
+	})
+
+	setFanOutLabel := func(name string, enabled bool) {
+		ns := &corev1.Namespace{}
+		Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: name}, ns)).Should(Succeed())
+		patch := client.MergeFrom(ns.DeepCopy())
+		if ns.Labels == nil {
+			ns.Labels = make(map[string]string)
+		}
+		if enabled {
+			ns.Labels[fanOutLabel] = "true"
+		} else {
+			delete(ns.Labels, fanOutLabel)
+		}
+		Expect(k8sClient.Patch(context.Background(), ns, patch)).Should(Succeed())
+	}
+
+	Context("Fan-out", func() {
+		It("Should generate the Secret in every selected namespace", func() {
+			// Namespaces can't be deleted in the test environment
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "fan-out-without-policy"}}
+			err := k8sClient.Create(context.Background(), ns)
+			Expect(err == nil || errors.IsAlreadyExists(err)).To(BeTrue())
+
+			setFanOutLabel("default", true)
+			setFanOutLabel("fan-out-without-policy", true)
+			defer setFanOutLabel("default", false)
+
+			toCreate := &keyhubv1beta1.ClusterKeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "sample-cks",
+				},
+				Spec: keyhubv1beta1.ClusterKeyHubSecretSpec{
+					NamespaceSelector: metav1.LabelSelector{
+						MatchLabels: map[string]string{fanOutLabel: "true"},
+					},
+					KeyHubSecretName: "fan-out",
+					KeyHubSecretSpec: keyhubv1beta1.KeyHubSecretSpec{
+						Data: []keyhubv1beta1.SecretKeyReference{
+							{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+						},
+					},
+				},
+			}
+
+			By("By creating a new ClusterKeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the Secret is created in the namespace with a policy")
+			fetched := &corev1.Secret{}
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), types.NamespacedName{Name: "fan-out", Namespace: "default"}, fetched)
+			}, timeout, interval).Should(Succeed())
+			Expect(fetched.Data).Should(HaveKeyWithValue("username", []byte("admin")))
+
+			By("By checking the sync results per namespace")
+			fetchedCks := &keyhubv1beta1.ClusterKeyHubSecret{}
+			Eventually(func() []keyhubv1beta1.NamespaceSyncStatus {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-cks"}, fetchedCks)
+				manifestToLog = fetchedCks
+				return fetchedCks.Status.Namespaces
+			}, timeout, interval).Should(ConsistOf(
+				keyhubv1beta1.NamespaceSyncStatus{
+					Namespace:  "default",
+					Ready:      metav1.ConditionTrue,
+					Reason:     keyhubv1beta1.Ready,
+					Message:    "Secret is ready",
+					SecretName: "fan-out",
+				},
+				keyhubv1beta1.NamespaceSyncStatus{
+					Namespace: "fan-out-without-policy",
+					Ready:     metav1.ConditionFalse,
+					Reason:    keyhubv1beta1.NoPolicyMatch,
+					Message:   "No credentials found for namespace fan-out-without-policy",
+				},
+			))
+			ready := meta.FindStatusCondition(fetchedCks.Status.Conditions, string(keyhubv1beta1.TypeReady))
+			Expect(ready).NotTo(BeNil())
+			Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+			Expect(ready.Reason).To(Equal(string(keyhubv1beta1.NamespacesFailed)))
+
+			By("By deselecting the namespace without a policy")
+			setFanOutLabel("fan-out-without-policy", false)
+
+			Eventually(func() bool {
+				ks := &keyhubv1beta1.KeyHubSecret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), types.NamespacedName{Name: "fan-out", Namespace: "fan-out-without-policy"}, ks))
+			}, timeout, interval).Should(BeTrue())
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-cks"}, fetchedCks)
+				manifestToLog = fetchedCks
+				return len(fetchedCks.Status.Namespaces) == 1 &&
+					meta.IsStatusConditionTrue(fetchedCks.Status.Conditions, string(keyhubv1beta1.TypeReady))
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+		})
+
+		It("Should not take over an existing KeyHubSecret", func() {
+			setFanOutLabel("default", true)
+			defer setFanOutLabel("default", false)
+
+			existing := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-cks",
+					Namespace: "default",
+				},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "password", Record: "00000000-0000-0000-1001-000000000002"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), existing)).Should(Succeed())
+
+			toCreate := &keyhubv1beta1.ClusterKeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "sample-cks",
+				},
+				Spec: keyhubv1beta1.ClusterKeyHubSecretSpec{
+					NamespaceSelector: metav1.LabelSelector{
+						MatchLabels: map[string]string{fanOutLabel: "true"},
+					},
+					KeyHubSecretSpec: keyhubv1beta1.KeyHubSecretSpec{
+						Data: []keyhubv1beta1.SecretKeyReference{
+							{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+						},
+					},
+				},
+			}
+
+			By("By creating a new ClusterKeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the conflict is reported")
+			fetchedCks := &keyhubv1beta1.ClusterKeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-cks"}, fetchedCks)
+				manifestToLog = fetchedCks
+				return len(fetchedCks.Status.Namespaces) == 1 &&
+					fetchedCks.Status.Namespaces[0].Reason == keyhubv1beta1.KeyHubSecretConflict
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("By checking the existing KeyHubSecret is untouched")
+			fetched := &keyhubv1beta1.KeyHubSecret{}
+			Expect(k8sClient.Get(context.Background(), client.ObjectKeyFromObject(existing), fetched)).Should(Succeed())
+			Expect(fetched.Spec.Data).To(HaveLen(1))
+			Expect(fetched.Spec.Data[0].Name).To(Equal("password"))
+			Expect(fetched.OwnerReferences).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,121 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/topicuskeyhub/keyhub-vault-operator/api"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/policy"
+)
+
+// keyhubPolicyRevalidationInterval is the interval at which the credentials
+// of a KeyHubPolicy are read again, the vault records with credentials can't
+// be watched
+const keyhubPolicyRevalidationInterval = 10 * time.Minute
+
+// KeyHubPolicyReconciler reconciles a KeyHubPolicy object. The policies are
+// read by the PolicyEngine on every lookup, the reconciler only reports
+// whether a policy is valid and its credentials can be read. The PolicyEngine
+// skips invalid policies, so their errors are reported here as the Ready
+// condition and a Warning event.
+type KeyHubPolicyReconciler struct {
+	client.Client
+	Log          logr.Logger
+	Recorder     record.EventRecorder
+	PolicyEngine policy.PolicyEngine
+}
+
+// +kubebuilder:rbac:groups=keyhub.topicus.nl,resources=keyhubpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=keyhub.topicus.nl,resources=keyhubpolicies/status,verbs=get;update;patch
+
+func (r *KeyHubPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("keyhubpolicy", req.Name)
+
+	kp := &keyhubv1beta1.KeyHubPolicy{}
+	if err := r.Get(ctx, req.NamespacedName, kp); err != nil {
+		if errors.IsNotFound(err) {
+			log.Info("KeyHubPolicy resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "Failed to get KeyHubPolicy")
+		return ctrl.Result{}, err
+	}
+
+	p, loadErr := r.PolicyEngine.LoadKeyHubPolicy(kp)
+	if loadErr != nil {
+		log.Info("Invalid KeyHubPolicy", "error", loadErr.Error())
+		kp.Status.ClientID = ""
+		api.SetCondition(&kp.Status.Conditions, kp.Generation, keyhubv1beta1.TypeReady, metav1.ConditionFalse, keyhubv1beta1.InvalidPolicy, loadErr.Error())
+		message := fmt.Sprintf("KeyHubPolicy is ignored: %s", loadErr.Error())
+		if kp.Spec.Effect == policy.PolicyEffectDeny {
+			message = fmt.Sprintf("KeyHubPolicy fails the KeyHubSecrets it may match: %s", loadErr.Error())
+		}
+		r.Recorder.Event(kp, "Warning", string(keyhubv1beta1.InvalidPolicy), message)
+	} else if p.IsDeny() {
+		kp.Status.ClientID = ""
+		message := fmt.Sprintf("Policy %s denies all records", p.String())
+		if len(p.Records) > 0 {
+			message = fmt.Sprintf("Policy %s denies the records of %d rule(s)", p.String(), len(p.Records))
+		}
+		api.SetCondition(&kp.Status.Conditions, kp.Generation, keyhubv1beta1.TypeReady, metav1.ConditionTrue, keyhubv1beta1.PolicyLoaded, message)
+	} else {
+		kp.Status.ClientID = p.Credentials.ClientID
+		api.SetCondition(&kp.Status.Conditions, kp.Generation, keyhubv1beta1.TypeReady, metav1.ConditionTrue, keyhubv1beta1.PolicyLoaded,
+			fmt.Sprintf("Policy %s uses KeyHub client %s", p.String(), p.Credentials.ClientID))
+	}
+
+	if err := r.Status().Update(ctx, kp); err != nil {
+		log.Error(err, "Failed to update KeyHubPolicy status")
+		return ctrl.Result{}, err
+	}
+
+	// The referenced Secret or vault record may still be created, retry with
+	// a backoff
+	if loadErr != nil {
+		return ctrl.Result{}, loadErr
+	}
+	return ctrl.Result{RequeueAfter: keyhubPolicyRevalidationInterval}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *KeyHubPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&keyhubv1beta1.KeyHubPolicy{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForSecret)).
+		Complete(r)
+}
+
+// requestsForSecret enqueues the KeyHubPolicies reading their credentials
+// from the Secret, so their status follows changes of the Secret
+func (r *KeyHubPolicyReconciler) requestsForSecret(obj client.Object) []reconcile.Request {
+	list := &keyhubv1beta1.KeyHubPolicyList{}
+	if err := r.List(context.Background(), list); err != nil {
+		r.Log.Error(err, "Failed to list KeyHubPolicies", "secret", client.ObjectKeyFromObject(obj).String())
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, kp := range list.Items {
+		ref := kp.Spec.Credentials.SecretRef
+		if ref != nil && ref.Namespace == obj.GetNamespace() && ref.Name == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&kp)})
+		}
+	}
+	return requests
+}
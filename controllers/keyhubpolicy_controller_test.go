@@ -0,0 +1,266 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("KeyHubPolicy Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	var manifestToLog interface{}
+
+	BeforeEach(func() {
+		manifestToLog = nil
+
+		// Flush caches
+		policyEngine.Flush()
+		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
+
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+
+		// Reset Prometheus collectors
+		controllerMetrics.Reset()
+	})
+
+	AfterEach(func() {
+		// Add any teardown steps that needs to be executed after each test
+		controllers_test.LogManifest(manifestToLog)
+	})
+
+	newKeyHubPolicy := func(namespace string, secretName string) *keyhubv1beta1.KeyHubPolicy {
+		return &keyhubv1beta1.KeyHubPolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "sample-kp",
+			},
+			Spec: keyhubv1beta1.KeyHubPolicySpec{
+				Type: "namespace",
+				Name: namespace,
+				Credentials: keyhubv1beta1.KeyHubPolicyCredentials{
+					SecretRef: &corev1.SecretReference{Namespace: "default", Name: secretName},
+				},
+			},
+		}
+	}
+
+	Context("Secret credentials", func() {
+		It("Should sync KeyHubSecrets with the client of the KeyHubPolicy", func() {
+			// Namespaces can't be deleted in the test environment
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "keyhubpolicy-only"}}
+			err := k8sClient.Create(context.Background(), ns)
+			Expect(err == nil || errors.IsAlreadyExists(err)).To(BeTrue())
+
+			credentials := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "keyhubpolicy-credentials",
+					Namespace: "default",
+				},
+				Data: map[string][]byte{
+					"clientId":     []byte("CLIENT-0001"),
+					"clientSecret": []byte("VERY_SECRET_PHRASE"),
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), credentials)).Should(Succeed())
+
+			By("By creating a new KeyHubPolicy")
+			Expect(k8sClient.Create(context.Background(), newKeyHubPolicy("keyhubpolicy-only", "keyhubpolicy-credentials"))).Should(Succeed())
+
+			By("By checking the KeyHubPolicy status")
+			fetchedPolicy := &keyhubv1beta1.KeyHubPolicy{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-kp"}, fetchedPolicy)
+				manifestToLog = fetchedPolicy
+				return fetchedPolicy.Status.ClientID == "CLIENT-0001" &&
+					meta.IsStatusConditionTrue(fetchedPolicy.Status.Conditions, string(keyhubv1beta1.TypeReady))
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "keyhubpolicy-only",
+				},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the Secret is created")
+			fetched := &corev1.Secret{}
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-ks", Namespace: "keyhubpolicy-only"}, fetched)
+			}, timeout, interval).Should(Succeed())
+			Expect(fetched.Data).Should(HaveKeyWithValue("username", []byte("admin")))
+
+			By("By checking the matched policy")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() *keyhubv1beta1.PolicyStatus {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-ks", Namespace: "keyhubpolicy-only"}, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+				return fetchedKeyHubSecret.Status.MatchedPolicy
+			}, timeout, interval).Should(Equal(&keyhubv1beta1.PolicyStatus{
+				Policy:   "type=namespace name='keyhubpolicy-only'",
+				ClientID: "CLIENT-0001",
+				Source:   "KeyHubPolicy sample-kp",
+			}))
+			manifestToLog = nil
+		})
+
+		It("Should report a missing Secret", func() {
+			By("By creating a new KeyHubPolicy")
+			Expect(k8sClient.Create(context.Background(), newKeyHubPolicy("keyhubpolicy-only", "missing"))).Should(Succeed())
+
+			By("By checking the KeyHubPolicy status")
+			fetchedPolicy := &keyhubv1beta1.KeyHubPolicy{}
+			Eventually(func() string {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-kp"}, fetchedPolicy)
+				manifestToLog = fetchedPolicy
+				ready := meta.FindStatusCondition(fetchedPolicy.Status.Conditions, string(keyhubv1beta1.TypeReady))
+				if ready == nil || ready.Status != metav1.ConditionFalse {
+					return ""
+				}
+				return ready.Reason
+			}, timeout, interval).Should(Equal(string(keyhubv1beta1.InvalidPolicy)))
+			Expect(fetchedPolicy.Status.ClientID).To(BeEmpty())
+			manifestToLog = nil
+		})
+
+		It("Should fail the matching KeyHubSecrets of an invalid deny KeyHubPolicy", func() {
+			ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "keyhubpolicy-only"}}
+			err := k8sClient.Create(context.Background(), ns)
+			Expect(err == nil || errors.IsAlreadyExists(err)).To(BeTrue())
+
+			credentials := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "keyhubpolicy-credentials",
+					Namespace: "default",
+				},
+				Data: map[string][]byte{
+					"clientId":     []byte("CLIENT-0001"),
+					"clientSecret": []byte("VERY_SECRET_PHRASE"),
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), credentials)).Should(Succeed())
+			Expect(k8sClient.Create(context.Background(), newKeyHubPolicy("keyhubpolicy-only", "keyhubpolicy-credentials"))).Should(Succeed())
+
+			By("By creating a deny KeyHubPolicy with an invalid record pattern")
+			deny := &keyhubv1beta1.KeyHubPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "sample-kp-deny",
+				},
+				Spec: keyhubv1beta1.KeyHubPolicySpec{
+					Type:   "namespace",
+					Name:   "keyhubpolicy-only",
+					Effect: "deny",
+					Records: []keyhubv1beta1.KeyHubPolicyRecordRule{
+						{NamePattern: "prod-("},
+					},
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), deny)).Should(Succeed())
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "keyhubpolicy-only",
+				},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the policy resolution fails")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() string {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-ks", Namespace: "keyhubpolicy-only"}, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+				matched := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypePolicyMatched))
+				if matched == nil || matched.Status != metav1.ConditionFalse {
+					return ""
+				}
+				return matched.Reason
+			}, timeout, interval).Should(Equal(string(keyhubv1beta1.InvalidPolicy)))
+			manifestToLog = nil
+
+			By("By checking the Secret is not created")
+			Consistently(func() bool {
+				err := k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-ks", Namespace: "keyhubpolicy-only"}, &corev1.Secret{})
+				return errors.IsNotFound(err)
+			}, 2*time.Second, interval).Should(BeTrue())
+		})
+
+		It("Should report an invalid KeyHubPolicy until its Secret is created", func() {
+			By("By creating a new KeyHubPolicy")
+			Expect(k8sClient.Create(context.Background(), newKeyHubPolicy("keyhubpolicy-only", "keyhubpolicy-late-credentials"))).Should(Succeed())
+
+			By("By checking the Warning event")
+			Eventually(func() bool {
+				events := &corev1.EventList{}
+				k8sClient.List(context.Background(), events, client.InNamespace("default"))
+				for _, event := range events.Items {
+					if event.InvolvedObject.Kind == "KeyHubPolicy" && event.InvolvedObject.Name == "sample-kp" &&
+						event.Type == "Warning" && event.Reason == string(keyhubv1beta1.InvalidPolicy) &&
+						strings.Contains(event.Message, "keyhubpolicy-late-credentials") {
+						return true
+					}
+				}
+				return false
+			}, timeout, interval).Should(BeTrue())
+
+			credentials := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "keyhubpolicy-late-credentials",
+					Namespace: "default",
+				},
+				Data: map[string][]byte{
+					"clientId":     []byte("CLIENT-0001"),
+					"clientSecret": []byte("VERY_SECRET_PHRASE"),
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), credentials)).Should(Succeed())
+
+			By("By checking the KeyHubPolicy status")
+			fetchedPolicy := &keyhubv1beta1.KeyHubPolicy{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), types.NamespacedName{Name: "sample-kp"}, fetchedPolicy)
+				manifestToLog = fetchedPolicy
+				return fetchedPolicy.Status.ClientID == "CLIENT-0001" &&
+					meta.IsStatusConditionTrue(fetchedPolicy.Status.Conditions, string(keyhubv1beta1.TypeReady))
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+		})
+	})
+})
@@ -5,20 +5,36 @@ package controllers
 
 import (
 	"context"
+	goerrors "errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/api"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/policy"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/secret"
@@ -27,8 +43,14 @@ import (
 )
 
 const (
-	requeueDelay           = time.Duration(5 * time.Minute)
-	requeueDelayAfterError = time.Duration(2 * time.Minute)
+	keyhubSecretFinalizer = "keyhub.topicus.nl/finalizer"
+
+	defaultRevisionHistoryLimit = 2
+	// revisionAnnotation orders the immutable Secrets of a KeyHubSecret
+	revisionAnnotation = "keyhub.topicus.nl/revision"
+	// vaultDiffBufferSize is the number of vault index diffs, and of
+	// KeyHubSecrets to enqueue, buffered before the senders block
+	vaultDiffBufferSize = 100
 )
 
 // KeyHubSecretReconciler reconciles a KeyHubSecret object
@@ -40,6 +62,23 @@ type KeyHubSecretReconciler struct {
 	SettingsManager settings.SettingsManager
 	PolicyEngine    policy.PolicyEngine
 	VaultIndexCache vault.VaultIndexCache
+	// VaultRecordCache shares the fetched records between the syncs
+	VaultRecordCache vault.VaultRecordCache
+
+	// CertificateExpiryWindow is the period before expiry in which a
+	// synced certificate is reported as expiring
+	CertificateExpiryWindow time.Duration
+
+	// RefreshInterval is the default interval in which Secrets are synced,
+	// overridden by spec.refreshInterval
+	RefreshInterval time.Duration
+	// RefreshJitter is the maximum fraction of the refresh interval added
+	// to spread the refreshes against KeyHub
+	RefreshJitter float64
+	// RetryBaseDelay and RetryMaxDelay bound the exponential backoff after
+	// failed syncs
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
 }
 
 func init() {
@@ -53,6 +92,7 @@ func init() {
 // +kubebuilder:rbac:groups=keyhub.topicus.nl,resources=keyhubsecrets/finalizers,verbs=update
 
 // +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups="",resources=events,verbs=get;list;watch;create;update;patch;delete
 
@@ -72,7 +112,7 @@ func (r *KeyHubSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	log.Info("", "KeyHubSecret", req.NamespacedName)
 
 	// Fetch the KeyHubSecret instance
-	keyhubsecret := &keyhubv1alpha1.KeyHubSecret{}
+	keyhubsecret := &keyhubv1beta1.KeyHubSecret{}
 	err := r.Get(ctx, req.NamespacedName, keyhubsecret)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -88,18 +128,72 @@ func (r *KeyHubSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request
 	}
 
 	if keyhubsecret.DeletionTimestamp != nil {
-		log.Info("KeyHubSecret resource marked for deletion. Ignoring since object must be deleted")
+		if !controllerutil.ContainsFinalizer(keyhubsecret, keyhubSecretFinalizer) {
+			return ctrl.Result{}, nil
+		}
+
+		log.Info("KeyHubSecret resource marked for deletion", "deletionPolicy", keyhubsecret.Spec.DeletionPolicy)
+		if err := r.finalize(ctx, keyhubsecret); err != nil {
+			log.Error(err, "Failed to finalize KeyHubSecret")
+			r.Recorder.Event(keyhubsecret, "Warning", "FinalizeFailed", err.Error())
+			return ctrl.Result{}, err
+		}
+
+		controllerutil.RemoveFinalizer(keyhubsecret, keyhubSecretFinalizer)
+		return ctrl.Result{}, r.Update(ctx, keyhubsecret)
+	}
+
+	if !controllerutil.ContainsFinalizer(keyhubsecret, keyhubSecretFinalizer) {
+		controllerutil.AddFinalizer(keyhubsecret, keyhubSecretFinalizer)
+		if err := r.Update(ctx, keyhubsecret); err != nil {
+			log.Error(err, "Failed to add finalizer to KeyHubSecret")
+			return ctrl.Result{}, err
+		}
+	}
+
+	if keyhubsecret.Spec.Suspend {
+		log.Info("Sync of KeyHubSecret is suspended")
+		api.SetCondition(&keyhubsecret.Status.Conditions, keyhubsecret.Generation, keyhubv1beta1.TypeReady, metav1.ConditionFalse, keyhubv1beta1.Suspended, "Sync of the Secret is suspended")
+		if err := r.Status().Update(ctx, keyhubsecret); err != nil {
+			log.Error(err, "Failed to update KeyHubSecret status")
+			return ctrl.Result{}, err
+		}
+		// Resumed by the update of the spec
 		return ctrl.Result{}, nil
 	}
 
+	forceSyncRequest, forceSync := forceSyncRequested(keyhubsecret)
+	if forceSync {
+		log.Info("Forced sync requested", "request", forceSyncRequest)
+	}
+
 	secret := r.newSecretForCR(keyhubsecret)
-	res, err := controllerutil.CreateOrPatch(ctx, r.Client, secret, r.reconcileFn(keyhubsecret, secret))
+	var res controllerutil.OperationResult
+	switch keyhubsecret.Spec.Target.CreationPolicy {
+	case keyhubv1beta1.CreationPolicyNone:
+		res, err = controllerutil.OperationResultNone, r.validate(keyhubsecret, forceSync)
+	case keyhubv1beta1.CreationPolicyMerge:
+		err = r.Get(ctx, client.ObjectKeyFromObject(secret), secret)
+		if errors.IsNotFound(err) {
+			err = fmt.Errorf("Secret %s not found, creation policy Merge requires an existing Secret", secret.Name)
+		}
+		if err == nil {
+			res, err = controllerutil.CreateOrPatch(ctx, r.Client, secret, r.reconcileFn(keyhubsecret, secret, forceSync))
+		}
+	default:
+		if keyhubsecret.Spec.Template.Immutable {
+			secret, res, err = r.createImmutableSecret(ctx, keyhubsecret, forceSync)
+		} else {
+			res, err = controllerutil.CreateOrPatch(ctx, r.Client, secret, r.reconcileFn(keyhubsecret, secret, forceSync))
+		}
+	}
 	if err != nil {
-		keyhubsecret.Status.Sync.Status = keyhubv1alpha1.SyncStatusCodeOutOfSync
+		api.SetCondition(&keyhubsecret.Status.Conditions, keyhubsecret.Generation, keyhubv1beta1.TypeSynced, metav1.ConditionFalse, keyhubv1beta1.SyncFailed, err.Error())
+		r.setReadyCondition(keyhubsecret)
 		r.Status().Update(ctx, keyhubsecret)
-		r.Recorder.Event(keyhubsecret, "Warning", "ProcessingError", err.Error())
+		r.Recorder.Event(keyhubsecret, "Warning", processingErrorReason(err), err.Error())
 		log.Error(err, "sync failed")
-		return ctrl.Result{RequeueAfter: requeueDelayAfterError}, err
+		return ctrl.Result{}, err
 	}
 
 	if res != controllerutil.OperationResultNone {
@@ -116,63 +210,551 @@ func (r *KeyHubSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request
 		r.Recorder.Event(keyhubsecret, "Normal", reason, message)
 	}
 
-	if len(keyhubsecret.Status.SecretKeyStatuses) > 0 {
-		keyhubsecret.Status.Sync.Status = keyhubv1alpha1.SyncStatusCodeSynced
+	switch keyhubsecret.Spec.Target.CreationPolicy {
+	case keyhubv1beta1.CreationPolicyNone:
+		keyhubsecret.Status.SecretName = ""
+	case keyhubv1beta1.CreationPolicyMerge:
+		keyhubsecret.Status.SecretName = secret.Name
+	default:
+		keyhubsecret.Status.SecretName = secret.Name
+		if err := r.pruneSecretGenerations(ctx, keyhubsecret); err != nil {
+			log.Error(err, "Failed to delete previous Secrets")
+			r.Recorder.Event(keyhubsecret, "Warning", "PruneFailed", err.Error())
+		}
+	}
+
+	if forceSync {
+		keyhubsecret.Status.ForcedSync = &keyhubv1beta1.ForcedSyncStatus{
+			Request:  forceSyncRequest,
+			SyncedAt: metav1.Now(),
+		}
+		r.Recorder.Event(keyhubsecret, "Normal", "ForcedSync", fmt.Sprintf("Forced sync '%s' completed", forceSyncRequest))
+	}
+
+	r.trackCertificateExpiry(keyhubsecret)
+
+	if keyErrors := keyhubsecret.Status.SecretKeyErrors; len(keyErrors) > 0 {
+		var failedKeys []string
+		for _, keyError := range keyErrors {
+			failedKeys = append(failedKeys, fmt.Sprintf("%s (%s)", keyError.Key, keyError.Reason))
+		}
+		message := fmt.Sprintf("Failed to sync key(s): %s", strings.Join(failedKeys, ", "))
+		api.SetCondition(&keyhubsecret.Status.Conditions, keyhubsecret.Generation, keyhubv1beta1.TypeSynced, metav1.ConditionFalse, keyhubv1beta1.KeysFailed, message)
+		r.Recorder.Event(keyhubsecret, "Warning", string(keyhubv1beta1.KeysFailed), message)
+	} else if keyhubsecret.Spec.Target.CreationPolicy == keyhubv1beta1.CreationPolicyNone {
+		api.SetCondition(&keyhubsecret.Status.Conditions, keyhubsecret.Generation, keyhubv1beta1.TypeSynced, metav1.ConditionUnknown, keyhubv1beta1.NotManaged, "Secret is not synced with creation policy None")
 	} else {
-		keyhubsecret.Status.Sync.Status = keyhubv1alpha1.SyncStatusCodeUnknown
+		api.SetCondition(&keyhubsecret.Status.Conditions, keyhubsecret.Generation, keyhubv1beta1.TypeSynced, metav1.ConditionTrue, keyhubv1beta1.SecretSynced, "Secret is in sync with KeyHub")
 	}
+	r.setReadyCondition(keyhubsecret)
 
 	err = r.Status().Update(ctx, keyhubsecret)
 	if err != nil {
 		log.Error(err, "Failed to update KeyHubSecret status")
 		r.Recorder.Event(keyhubsecret, "Warning", "FailedUpdate", err.Error())
-		return ctrl.Result{RequeueAfter: requeueDelayAfterError}, err
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{RequeueAfter: r.refreshInterval(keyhubsecret)}, nil
+}
+
+// forceSyncRequested returns the value of the ForceSyncAnnotation and whether
+// it requests a sync that has not been handled yet.
+func forceSyncRequested(ks *keyhubv1beta1.KeyHubSecret) (string, bool) {
+	request, found := ks.Annotations[keyhubv1beta1.ForceSyncAnnotation]
+	if !found || request == "" {
+		return "", false
 	}
 
-	return ctrl.Result{RequeueAfter: requeueDelay}, nil
+	return request, ks.Status.ForcedSync == nil || ks.Status.ForcedSync.Request != request
 }
 
-func (r *KeyHubSecretReconciler) reconcileFn(cr *keyhubv1alpha1.KeyHubSecret, s *corev1.Secret) controllerutil.MutateFn {
+// refreshInterval returns the jittered interval until the next sync of ks
+func (r *KeyHubSecretReconciler) refreshInterval(ks *keyhubv1beta1.KeyHubSecret) time.Duration {
+	interval := r.RefreshInterval
+	if ks.Spec.RefreshInterval != nil && ks.Spec.RefreshInterval.Duration > 0 {
+		interval = ks.Spec.RefreshInterval.Duration
+	}
+
+	// wait.Jitter treats a factor <= 0 as 1.0, a jitter of 0 disables it
+	if r.RefreshJitter <= 0 {
+		return interval
+	}
+	return wait.Jitter(interval, r.RefreshJitter)
+}
+
+func (r *KeyHubSecretReconciler) reconcileFn(cr *keyhubv1beta1.KeyHubSecret, s *corev1.Secret, forceSync bool) controllerutil.MutateFn {
 	return func() error {
-		// Set KeyHubSecret instance as the owner and controller
-		if err := controllerutil.SetControllerReference(cr, s, r.Scheme); err != nil {
-			return err
+		// Set KeyHubSecret instance as the owner and controller, merged
+		// Secrets are owned by someone else
+		if cr.Spec.Target.CreationPolicy != keyhubv1beta1.CreationPolicyMerge {
+			if err := controllerutil.SetControllerReference(cr, s, r.Scheme); err != nil {
+				return err
+			}
 		}
 
-		client, err := r.PolicyEngine.GetClient(cr)
+		secretBuilder, err := r.newSecretBuilder(cr, forceSync)
 		if err != nil {
 			return err
 		}
 
-		records, err := r.VaultIndexCache.Get(client)
-		if err != nil {
+		return secretBuilder.Build(cr, s)
+	}
+}
+
+// createImmutableSecret builds the Secret on top of the current Secret, a new
+// immutable Secret is created when the content changed
+func (r *KeyHubSecretReconciler) createImmutableSecret(ctx context.Context, cr *keyhubv1beta1.KeyHubSecret, forceSync bool) (*corev1.Secret, controllerutil.OperationResult, error) {
+	current := &corev1.Secret{}
+	if cr.Status.SecretName != "" {
+		err := r.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: cr.Status.SecretName}, current)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, controllerutil.OperationResultNone, err
+		}
+	}
+
+	base := current.DeepCopy()
+	desired := r.newSecretForCR(cr)
+	desired.Labels = base.Labels
+	desired.Annotations = base.Annotations
+	desired.Type = base.Type
+	desired.Data = base.Data
+
+	secretBuilder, err := r.newSecretBuilder(cr, forceSync)
+	if err != nil {
+		return nil, controllerutil.OperationResultNone, err
+	}
+	if err := secretBuilder.Build(cr, desired); err != nil {
+		return nil, controllerutil.OperationResultNone, err
+	}
+
+	s := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      desired.Name,
+			Namespace: desired.Namespace,
+		},
+	}
+	res, err := controllerutil.CreateOrPatch(ctx, r.Client, s, func() error {
+		if err := controllerutil.SetControllerReference(cr, s, r.Scheme); err != nil {
 			return err
 		}
+		revision := s.Annotations[revisionAnnotation]
+		if s.CreationTimestamp.IsZero() {
+			revision = strconv.FormatInt(secretRevision(current)+1, 10)
+		}
+		s.Labels = desired.Labels
+		s.Annotations = desired.Annotations
+		if s.Annotations == nil {
+			s.Annotations = make(map[string]string)
+		}
+		s.Annotations[revisionAnnotation] = revision
+		s.Type = desired.Type
+		s.Data = desired.Data
+		s.Immutable = desired.Immutable
+		return nil
+	})
 
-		secretBuilder := secret.NewSecretBuilder(
-			r.Client,
-			ctrl.Log.WithName("SecretBuilder"),
-			records,
-			vault.NewVaultSecretRetriever(r.Log, client),
-		)
+	return s, res, err
+}
 
-		return secretBuilder.Build(cr, s)
+// pruneSecretGenerations deletes the previous Secrets of cr beyond the
+// revision history limit, newest first. The current Secret is always kept.
+func (r *KeyHubSecretReconciler) pruneSecretGenerations(ctx context.Context, cr *keyhubv1beta1.KeyHubSecret) error {
+	generations, err := r.listOwnedSecrets(ctx, cr)
+	if err != nil {
+		return err
+	}
+
+	previous := make([]corev1.Secret, 0, len(generations))
+	for _, s := range generations {
+		if s.Name != cr.Status.SecretName {
+			previous = append(previous, s)
+		}
+	}
+
+	limit := defaultRevisionHistoryLimit
+	if cr.Spec.RevisionHistoryLimit != nil {
+		limit = int(*cr.Spec.RevisionHistoryLimit)
+	}
+	if len(previous) <= limit {
+		return nil
 	}
+
+	sort.Slice(previous, func(i, j int) bool {
+		ri, rj := secretRevision(&previous[i]), secretRevision(&previous[j])
+		if ri != rj {
+			return ri > rj
+		}
+		return previous[j].CreationTimestamp.Before(&previous[i].CreationTimestamp)
+	})
+	for i := range previous[limit:] {
+		s := &previous[limit+i]
+		r.Log.Info("Deleting previous Secret", "secret", client.ObjectKeyFromObject(s))
+		if err := r.Delete(ctx, s); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// secretRevision returns the revision of an immutable Secret, 0 for other Secrets
+func secretRevision(s *corev1.Secret) int64 {
+	revision, err := strconv.ParseInt(s.Annotations[revisionAnnotation], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return revision
+}
+
+// listOwnedSecrets returns the Secrets controlled by cr
+func (r *KeyHubSecretReconciler) listOwnedSecrets(ctx context.Context, cr *keyhubv1beta1.KeyHubSecret) ([]corev1.Secret, error) {
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.InNamespace(cr.Namespace)); err != nil {
+		return nil, err
+	}
+
+	owned := make([]corev1.Secret, 0)
+	for _, s := range secrets.Items {
+		if metav1.IsControlledBy(&s, cr) {
+			owned = append(owned, s)
+		}
+	}
+
+	return owned, nil
+}
+
+// validate resolves the policy and KeyHub vault records of cr, without
+// touching the Secret
+func (r *KeyHubSecretReconciler) validate(cr *keyhubv1beta1.KeyHubSecret, forceSync bool) error {
+	secretBuilder, err := r.newSecretBuilder(cr, forceSync)
+	if err != nil {
+		return err
+	}
+
+	secretBuilder.Validate(cr)
+
+	return nil
 }
 
-func (r *KeyHubSecretReconciler) newSecretForCR(cr *keyhubv1alpha1.KeyHubSecret) *corev1.Secret {
+func (r *KeyHubSecretReconciler) newSecretBuilder(cr *keyhubv1beta1.KeyHubSecret, forceSync bool) (secret.SecretBuilder, error) {
+	client, resolution, err := r.PolicyEngine.Resolve(cr)
+	if err != nil {
+		cr.Status.MatchedPolicy = nil
+		cr.Status.ShadowedPolicies = nil
+		reason := keyhubv1beta1.NoPolicyMatch
+		if goerrors.Is(err, policy.ErrInvalidDenyPolicy) {
+			reason = keyhubv1beta1.InvalidPolicy
+		}
+		api.SetCondition(&cr.Status.Conditions, cr.Generation, keyhubv1beta1.TypePolicyMatched, metav1.ConditionFalse, reason, err.Error())
+		return nil, err
+	}
+	r.setPolicyStatus(cr, resolution)
+
+	// A failed rebuild only fails the forced sync, the other KeyHubSecrets
+	// keep using the previous index
+	if forceSync {
+		err = r.VaultIndexCache.Invalidate(client)
+	}
+	var records map[string]vault.VaultRecordWithGroup
+	if err == nil {
+		records, err = r.VaultIndexCache.Get(client)
+	}
+	if err != nil {
+		api.SetCondition(&cr.Status.Conditions, cr.Generation, keyhubv1beta1.TypeRecordsResolved, metav1.ConditionFalse, keyhubv1beta1.VaultIndexUnavailable, err.Error())
+		return nil, err
+	}
+
+	// Records the policies deny are hidden from the SecretBuilder, so they
+	// can't be imported or resolved by name
+	access := resolution.RecordAccess()
+	if err := authorizeRecords(cr, access, records); err != nil {
+		api.SetCondition(&cr.Status.Conditions, cr.Generation, keyhubv1beta1.TypeRecordsResolved, metav1.ConditionFalse, keyhubv1beta1.RecordDenied, err.Error())
+		return nil, err
+	}
+	records = access.Filter(records)
+
+	hashKey, err := r.SettingsManager.GetHashKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// A forced sync fetches the records from KeyHub
+	recordCache := r.VaultRecordCache
+	if forceSync {
+		recordCache = nil
+	}
+
+	return secret.NewSecretBuilder(
+		r.Client,
+		ctrl.Log.WithName("SecretBuilder"),
+		records,
+		vault.NewVaultSecretRetriever(r.Log, client, recordCache),
+		api.NewSecretKeyHasher(hashKey),
+		forceSync,
+	), nil
+}
+
+// authorizeRecords checks the records referenced by spec.data against the
+// policies, a denied record fails the sync before the Secret is built
+func authorizeRecords(cr *keyhubv1beta1.KeyHubSecret, access *policy.RecordAccess, records map[string]vault.VaultRecordWithGroup) error {
+	var denied []string
+	for _, ref := range cr.Spec.Data {
+		idxEntry, found := records[ref.Record]
+		if ref.RecordRef != nil && ref.Record == "" {
+			var err error
+			idxEntry, err = vault.ResolveRecord(records, ref.RecordRef.Group, ref.RecordRef.Name, ref.RecordRef.Regex)
+			found = err == nil
+		}
+		// Missing records are reported by the SecretBuilder
+		if !found {
+			continue
+		}
+		if err := access.Check(idxEntry); err != nil {
+			denied = append(denied, fmt.Sprintf("%s (%s)", ref.Name, err))
+		}
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("Access to KeyHub vault record(s) denied for key(s): %s: %w", strings.Join(denied, ", "), policy.ErrRecordDenied)
+	}
+	return nil
+}
+
+// setPolicyStatus records the matched and shadowed policies, a change of the
+// policies is written to the event log
+func (r *KeyHubSecretReconciler) setPolicyStatus(cr *keyhubv1beta1.KeyHubSecret, resolution *policy.Resolution) {
+	matched := policyStatus(resolution.Policy)
+	shadowed := make([]keyhubv1beta1.PolicyStatus, 0, len(resolution.Shadowed))
+	for _, p := range resolution.Shadowed {
+		shadowed = append(shadowed, policyStatus(p))
+	}
+
+	message := fmt.Sprintf("Using KeyHub client %s of policy '%s'", matched.ClientID, matched.Policy)
+	if matched.Source != "" {
+		message += fmt.Sprintf(" from vault record %s", matched.Source)
+	}
+	api.SetCondition(&cr.Status.Conditions, cr.Generation, keyhubv1beta1.TypePolicyMatched, metav1.ConditionTrue, keyhubv1beta1.PolicyMatched, message)
+
+	changed := cr.Status.MatchedPolicy == nil || *cr.Status.MatchedPolicy != matched || len(cr.Status.ShadowedPolicies) != len(shadowed)
+	for i := 0; !changed && i < len(shadowed); i++ {
+		changed = cr.Status.ShadowedPolicies[i] != shadowed[i]
+	}
+	cr.Status.MatchedPolicy = &matched
+	cr.Status.ShadowedPolicies = shadowed
+	if !changed {
+		return
+	}
+
+	if len(shadowed) > 0 {
+		descriptions := make([]string, 0, len(shadowed))
+		for _, s := range shadowed {
+			descriptions = append(descriptions, fmt.Sprintf("'%s' (client %s)", s.Policy, s.ClientID))
+		}
+		message += fmt.Sprintf(", shadowing %s", strings.Join(descriptions, ", "))
+	}
+	r.Recorder.Event(cr, "Normal", string(keyhubv1beta1.PolicyMatched), message)
+
+	for _, p := range resolution.Conflicting {
+		r.Recorder.Event(cr, "Warning", string(keyhubv1beta1.PolicyConflict),
+			fmt.Sprintf("Policy '%s' for client %s has the same precedence as the matched policy, set a priority to resolve the conflict", p.String(), p.Credentials.ClientID))
+	}
+}
+
+// policyStatus describes p in the status of a KeyHubSecret
+func policyStatus(p policy.Policy) keyhubv1beta1.PolicyStatus {
+	return keyhubv1beta1.PolicyStatus{
+		Policy:   p.String(),
+		ClientID: p.Credentials.ClientID,
+		Source:   p.Source.String(),
+	}
+}
+
+func (r *KeyHubSecretReconciler) newSecretForCR(cr *keyhubv1beta1.KeyHubSecret) *corev1.Secret {
 	return &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      cr.Name,
+			Name:      secret.TargetName(cr),
 			Namespace: cr.Namespace,
 		},
 	}
 }
 
+// setReadyCondition summarizes the other conditions, the KeyHubSecret is ready
+// when the Secret is synced and none of the conditions report a failure.
+func (r *KeyHubSecretReconciler) setReadyCondition(ks *keyhubv1beta1.KeyHubSecret) {
+	for _, conditionType := range []keyhubv1beta1.KeyHubSecretConditionType{
+		keyhubv1beta1.TypePolicyMatched,
+		keyhubv1beta1.TypeRecordsResolved,
+		keyhubv1beta1.TypeCertificateValid,
+		keyhubv1beta1.TypeSynced,
+	} {
+		condition := meta.FindStatusCondition(ks.Status.Conditions, string(conditionType))
+		if condition != nil && condition.Status == metav1.ConditionFalse {
+			api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionFalse, keyhubv1beta1.KeyHubSecretConditionReason(condition.Reason), condition.Message)
+			return
+		}
+	}
+
+	if ks.Spec.Target.CreationPolicy == keyhubv1beta1.CreationPolicyNone {
+		api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionTrue, keyhubv1beta1.NotManaged, "KeyHub vault records are valid, the Secret is not managed")
+		return
+	}
+
+	if !meta.IsStatusConditionTrue(ks.Status.Conditions, string(keyhubv1beta1.TypeSynced)) {
+		api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionUnknown, keyhubv1beta1.AwaitingSync, "Secret has not been synced yet")
+		return
+	}
+	api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeReady, metav1.ConditionTrue, keyhubv1beta1.Ready, "Secret is ready")
+}
+
+// trackCertificateExpiry exports the expiry of the synced certificate and
+// warns once when the certificate enters the expiry window.
+func (r *KeyHubSecretReconciler) trackCertificateExpiry(ks *keyhubv1beta1.KeyHubSecret) {
+	cert := ks.Status.Certificate
+	if cert == nil {
+		return
+	}
+
+	metrics.CertificateExpiry.DeletePartialMatch(prometheus.Labels{"namespace": ks.Namespace, "keyhubsecret": ks.Name})
+	metrics.CertificateExpiry.WithLabelValues(ks.Namespace, ks.Name, cert.RecordID, cert.CommonName).Set(float64(cert.NotAfter.Unix()))
+
+	expiresAt := cert.NotAfter.UTC().Format(time.RFC3339)
+	if time.Until(cert.NotAfter.Time) > r.CertificateExpiryWindow {
+		api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeCertificateExpiring, metav1.ConditionFalse, keyhubv1beta1.CertificateNotExpiring,
+			fmt.Sprintf("Certificate '%s' expires at %s", cert.CommonName, expiresAt))
+		return
+	}
+
+	message := fmt.Sprintf("Certificate '%s' from vault record %s expires at %s", cert.CommonName, cert.RecordID, expiresAt)
+	if !meta.IsStatusConditionTrue(ks.Status.Conditions, string(keyhubv1beta1.TypeCertificateExpiring)) {
+		r.Recorder.Event(ks, "Warning", string(keyhubv1beta1.CertificateExpiresSoon), message)
+	}
+	api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeCertificateExpiring, metav1.ConditionTrue, keyhubv1beta1.CertificateExpiresSoon, message)
+}
+
+// finalize applies the deletion policy to the Secret and removes the state
+// held by the operator for the KeyHubSecret.
+func (r *KeyHubSecretReconciler) finalize(ctx context.Context, ks *keyhubv1beta1.KeyHubSecret) error {
+	retain := ks.Spec.DeletionPolicy == keyhubv1beta1.DeletionPolicyRetain || ks.Spec.DeletionPolicy == keyhubv1beta1.DeletionPolicyOrphan
+
+	if ks.Spec.Target.CreationPolicy == keyhubv1beta1.CreationPolicyMerge {
+		if retain {
+			r.forget(ks)
+			return nil
+		}
+
+		// Only remove the keys merged into the Secret
+		s := &corev1.Secret{}
+		err := r.Get(ctx, client.ObjectKeyFromObject(r.newSecretForCR(ks)), s)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		if err == nil {
+			r.Log.Info("Removing merged keys from Secret", "secret", client.ObjectKeyFromObject(s))
+			for _, status := range ks.Status.SecretKeyStatuses {
+				delete(s.Data, status.Key)
+			}
+			if err := r.Update(ctx, s); err != nil {
+				return fmt.Errorf("Failed to remove merged keys from Secret: %w", err)
+			}
+		}
+
+		r.forget(ks)
+		return nil
+	}
+
+	// Includes the previous immutable Secrets
+	owned, err := r.listOwnedSecrets(ctx, ks)
+	if err != nil {
+		return err
+	}
+
+	for i := range owned {
+		s := &owned[i]
+		if retain {
+			r.Log.Info("Retaining Secret", "secret", client.ObjectKeyFromObject(s))
+			s.OwnerReferences = removeOwnerReference(s.OwnerReferences, ks.UID)
+			if err := r.Update(ctx, s); err != nil {
+				return fmt.Errorf("Failed to retain Secret: %w", err)
+			}
+		} else {
+			r.Log.Info("Deleting Secret", "secret", client.ObjectKeyFromObject(s))
+			if err := r.Delete(ctx, s); err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("Failed to delete Secret: %w", err)
+			}
+		}
+	}
+
+	r.forget(ks)
+
+	return nil
+}
+
+// forget removes the metrics series exported for the KeyHubSecret
+func (r *KeyHubSecretReconciler) forget(ks *keyhubv1beta1.KeyHubSecret) {
+	metrics.CertificateExpiry.DeletePartialMatch(prometheus.Labels{"namespace": ks.Namespace, "keyhubsecret": ks.Name})
+}
+
+func removeOwnerReference(refs []metav1.OwnerReference, uid types.UID) []metav1.OwnerReference {
+	ret := make([]metav1.OwnerReference, 0, len(refs))
+	for _, ref := range refs {
+		if ref.UID != uid {
+			ret = append(ret, ref)
+		}
+	}
+	return ret
+}
+
+// processingErrorReason returns the event reason for a failed sync
+func processingErrorReason(err error) string {
+	var certErr *secret.CertificateValidationError
+	if goerrors.As(err, &certErr) {
+		return string(certErr.Reason)
+	}
+	if goerrors.Is(err, policy.ErrRecordDenied) {
+		return string(keyhubv1beta1.RecordDenied)
+	}
+	if goerrors.Is(err, policy.ErrInvalidDenyPolicy) {
+		return string(keyhubv1beta1.InvalidPolicy)
+	}
+	return "ProcessingError"
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *KeyHubSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// Failed syncs are retried with an exponential backoff per KeyHubSecret,
+	// bounded by the overall rate limit of the default controller rate limiter
+	rateLimiter := workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(r.RetryBaseDelay, r.RetryMaxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+	)
+
+	if err := setupFieldIndexes(context.Background(), mgr); err != nil {
+		return err
+	}
+
+	// Only the KeyHubSecrets referencing the records that changed in a
+	// refresh of the vault index are synced, instead of waiting for the
+	// refresh interval. A single consumer handles the diffs in order, a full
+	// buffer delays the refresh of the vault index.
+	vaultDiffs := make(chan *vault.VaultIndexDiff, vaultDiffBufferSize)
+	vaultEvents := make(chan event.GenericEvent, vaultDiffBufferSize)
+	r.VaultIndexCache.Subscribe(func(diff *vault.VaultIndexDiff) {
+		vaultDiffs <- diff
+	})
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return r.consumeVaultChanges(ctx, vaultDiffs, vaultEvents)
+	})); err != nil {
+		return err
+	}
+
+	// Secrets with the Merge creation policy are not owned, they are
+	// watched through the index of the target names
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&keyhubv1alpha1.KeyHubSecret{}).
+		For(&keyhubv1beta1.KeyHubSecret{}).
 		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForMergedSecret)).
+		Watches(&source.Channel{Source: vaultEvents}, &handler.EnqueueRequestForObject{}).
+		WithOptions(controller.Options{RateLimiter: rateLimiter}).
 		Complete(r)
 }
@@ -5,19 +5,17 @@ package controllers
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
 	"strconv"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	"golang.org/x/crypto/bcrypt"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
@@ -38,6 +36,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// Flush caches
 		policyEngine.Flush()
 		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
 
 		// Failed test runs that don't clean up leave resources behind.
 		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
@@ -54,11 +53,11 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 	Context("BasicAuth secret", func() {
 		It("Should handle basic auth credentials correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
 					Type: corev1.SecretTypeBasicAuth,
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "auth", Record: "00000000-0000-0000-1001-000000000002"},
 				},
 			}
@@ -68,7 +67,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -89,25 +88,24 @@ var _ = Describe("KeyHubSecret Controller", func() {
 					string(fetched.Data["password"]) == "test1234"
 			}, timeout, interval).Should(BeTrue())
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		It("Should handle KeyHubSecret updates correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
 					Type: corev1.SecretTypeBasicAuth,
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "auth", Record: "00000000-0000-0000-1001-000000000002"},
 				},
 			}
@@ -117,7 +115,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -142,7 +140,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By checking the KeyHubSecret status")
-			fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
@@ -150,27 +148,19 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				records := fetchedKeyHubSecret.Status.VaultRecordStatuses
 				keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
 
-				encKey0 := make([]byte, base64.StdEncoding.EncodedLen(len("admin")))
-				base64.StdEncoding.Encode(encKey0, []byte("admin"))
-				shaKey0 := sha256.Sum256(encKey0)
-
-				encKey1 := make([]byte, base64.StdEncoding.EncodedLen(len("test1234")))
-				base64.StdEncoding.Encode(encKey1, []byte("test1234"))
-				shaKey1 := sha256.Sum256(encKey1)
-
 				return len(records) == 1 &&
 					len(keys) == 2 &&
 					records[0].RecordID == "00000000-0000-0000-1001-000000000002" &&
 					records[0].Name == "Username + password" &&
 					keys[0].Key == "username" &&
-					bcrypt.CompareHashAndPassword(keys[0].Hash, shaKey0[:]) == nil &&
+					secretKeyHasher.Verify(keys[0].Hash, []byte("admin")) &&
 					keys[1].Key == "password" &&
-					bcrypt.CompareHashAndPassword(keys[1].Hash, shaKey1[:]) == nil
+					secretKeyHasher.Verify(keys[1].Hash, []byte("test1234"))
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
 			By("By updating the KeyHubSecret")
-			fetchedKeyHubSecret.Spec.Data = []keyhubv1alpha1.SecretKeyReference{
+			fetchedKeyHubSecret.Spec.Data = []keyhubv1beta1.SecretKeyReference{
 				{Name: "auth", Record: "00000000-0000-0000-1001-000000000003"},
 			}
 			manifestToLog = fetchedKeyHubSecret
@@ -190,7 +180,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By checking the KeyHubSecret status is updated correctly")
-			fetchedKeyHubSecret = &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret = &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
@@ -198,49 +188,40 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				records := fetchedKeyHubSecret.Status.VaultRecordStatuses
 				keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
 
-				encKey0 := make([]byte, base64.StdEncoding.EncodedLen(len("example.io")))
-				base64.StdEncoding.Encode(encKey0, []byte("example.io"))
-				shaKey0 := sha256.Sum256(encKey0)
-
-				encKey1 := make([]byte, base64.StdEncoding.EncodedLen(len("test5678")))
-				base64.StdEncoding.Encode(encKey1, []byte("test5678"))
-				shaKey1 := sha256.Sum256(encKey1)
-
 				return len(records) == 1 &&
 					len(keys) == 2 &&
 					records[0].RecordID == "00000000-0000-0000-1001-000000000003" &&
 					records[0].Name == "Certificate" &&
 					keys[0].Key == "username" &&
-					bcrypt.CompareHashAndPassword(keys[0].Hash, shaKey0[:]) == nil &&
+					secretKeyHasher.Verify(keys[0].Hash, []byte("example.io")) &&
 					keys[1].Key == "password" &&
-					bcrypt.CompareHashAndPassword(keys[1].Hash, shaKey1[:]) == nil
+					secretKeyHasher.Verify(keys[1].Hash, []byte("test5678"))
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		It("Should not make excessive api calls to KeyHub", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
 					Type: corev1.SecretTypeBasicAuth,
-					Metadata: keyhubv1alpha1.SecretTemplateMetadata{
+					Metadata: keyhubv1beta1.SecretTemplateMetadata{
 						Labels: map[string]string{
 							"iteration": "0",
 						},
 					},
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "auth", Record: "00000000-0000-0000-1001-000000000002"},
 				},
 			}
@@ -250,7 +231,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -303,7 +284,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			By("By repeatedly triggering a reconcile")
 			for i := 1; i <= 3; i++ {
 				By("By setting the 'iteration' label")
-				fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+				fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				fetchedKeyHubSecret.Spec.Template.Metadata.Labels["iteration"] = strconv.Itoa(i)
 				k8sClient.Update(context.Background(), fetchedKeyHubSecret)
@@ -336,17 +317,16 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Expect(actualKeyhubApiCalls).To(Equal(allowedKeyhubApiCalls))
 			}
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 	})
@@ -0,0 +1,175 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("KeyHubSecret Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	var manifestToLog interface{}
+
+	BeforeEach(func() {
+		manifestToLog = nil
+
+		// Flush caches
+		policyEngine.Flush()
+		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
+
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+
+		// Reset Prometheus collectors
+		controllerMetrics.Reset()
+	})
+
+	AfterEach(func() {
+		// Add any teardown steps that needs to be executed after each test
+		controllers_test.LogManifest(manifestToLog)
+	})
+
+	Context("Deletion policy", func() {
+		It("Should delete the Secret and metrics by default", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
+					Type: corev1.SecretTypeTLS,
+				},
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000003"},
+					{Name: "tls.key", Record: "00000000-0000-0000-1001-000000000004"},
+				},
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the finalizer is added")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				return fetchedKeyHubSecret.Spec.DeletionPolicy == keyhubv1beta1.DeletionPolicyDelete &&
+					controllerutil.ContainsFinalizer(fetchedKeyHubSecret, keyhubSecretFinalizer) &&
+					fetchedKeyHubSecret.Status.Certificate != nil
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("By checking the Secret is created")
+			fetched := &corev1.Secret{}
+			Eventually(func() error {
+				return k8sClient.Get(context.Background(), key, fetched)
+			}, timeout, interval).Should(Succeed())
+			Expect(testutil.CollectAndCount(controllerMetrics.CertificateExpiry)).To(Equal(1))
+
+			By("Deleting the KeyHubSecret")
+			Expect(k8sClient.Delete(context.Background(), fetchedKeyHubSecret)).Should(Succeed())
+
+			By("By checking the KeyHubSecret and Secret are deleted")
+			Eventually(func() bool {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+			Expect(testutil.CollectAndCount(controllerMetrics.CertificateExpiry)).To(Equal(0))
+		})
+
+		It("Should retain the Secret", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+				},
+				DeletionPolicy: keyhubv1beta1.DeletionPolicyRetain,
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the Secret is created")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetched)
+				manifestToLog = fetched
+
+				return len(fetched.OwnerReferences) == 1 &&
+					string(fetched.Data["username"]) == "admin"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("Deleting the KeyHubSecret")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+
+			By("By checking the KeyHubSecret is deleted")
+			Eventually(func() bool {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+
+			By("By checking the Secret is retained without owner")
+			fetched = &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), key, fetched)).Should(Succeed())
+			manifestToLog = fetched
+			Expect(fetched.OwnerReferences).To(BeEmpty())
+			Expect(string(fetched.Data["username"])).To(Equal("admin"))
+			manifestToLog = nil
+
+			By("Deleting the Secret")
+			Expect(k8sClient.Delete(context.Background(), fetched)).Should(Succeed())
+		})
+	})
+})
@@ -12,9 +12,10 @@ import (
 	. "github.com/onsi/gomega"
 	"golang.org/x/crypto/bcrypt"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -34,6 +35,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// Flush caches
 		policyEngine.Flush()
 		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
 
 		// Failed test runs that don't clean up leave resources behind.
 		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
@@ -50,11 +52,11 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 	Context("htpasswd secret", func() {
 		It("Should handle htpasswd credentials correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
-					Type: keyhubv1alpha1.SecretTypeApachePasswordFile,
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
+					Type: keyhubv1beta1.SecretTypeApachePasswordFile,
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "auth", Record: "00000000-0000-0000-1001-000000000002"},
 				},
 			}
@@ -64,7 +66,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -81,7 +83,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				k8sClient.Get(context.Background(), key, fetched)
 				manifestToLog = fetched
 
-				if fetched.Type != keyhubv1alpha1.SecretTypeApachePasswordFile ||
+				if fetched.Type != keyhubv1beta1.SecretTypeApachePasswordFile ||
 					len(fetched.Data) != 1 {
 					return false
 				}
@@ -96,25 +98,24 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		// 	It("Should handle KeyHubSecret updates correctly", func() {
-		// 		spec := keyhubv1alpha1.KeyHubSecretSpec{
-		// 			Template: keyhubv1alpha1.SecretTemplate{
+		// 		spec := keyhubv1beta1.KeyHubSecretSpec{
+		// 			Template: keyhubv1beta1.SecretTemplate{
 		// 				Type: corev1.SecretTypeBasicAuth,
 		// 			},
-		// 			Data: []keyhubv1alpha1.SecretKeyReference{
+		// 			Data: []keyhubv1beta1.SecretKeyReference{
 		// 				{Name: "auth", Record: "1001-0002"},
 		// 			},
 		// 		}
@@ -124,7 +125,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 			Namespace: "default",
 		// 		}
 
-		// 		toCreate := &keyhubv1alpha1.KeyHubSecret{
+		// 		toCreate := &keyhubv1beta1.KeyHubSecret{
 		// 			ObjectMeta: metav1.ObjectMeta{
 		// 				Name:      "sample-ks",
 		// 				Namespace: "default",
@@ -149,7 +150,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 		manifestToLog = nil
 
 		// 		By("By checking the KeyHubSecret status")
-		// 		fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+		// 		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 		// 		Eventually(func() bool {
 		// 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 		// 			manifestToLog = fetchedKeyHubSecret
@@ -175,7 +176,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 		manifestToLog = nil
 
 		// 		By("By updating the KeyHubSecret")
-		// 		fetchedKeyHubSecret.Spec.Data = []keyhubv1alpha1.SecretKeyReference{
+		// 		fetchedKeyHubSecret.Spec.Data = []keyhubv1beta1.SecretKeyReference{
 		// 			{Name: "auth", Record: "1001-0003"},
 		// 		}
 		// 		manifestToLog = fetchedKeyHubSecret
@@ -195,7 +196,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 		manifestToLog = nil
 
 		// 		By("By checking the KeyHubSecret status is updated correctly")
-		// 		fetchedKeyHubSecret = &keyhubv1alpha1.KeyHubSecret{}
+		// 		fetchedKeyHubSecret = &keyhubv1beta1.KeyHubSecret{}
 		// 		Eventually(func() bool {
 		// 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 		// 			manifestToLog = fetchedKeyHubSecret
@@ -220,9 +221,9 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 		}, timeout, interval).Should(BeTrue())
 		// 		manifestToLog = nil
 
-		// 		By("Deleting the KeyHubSecret and Secret")
+		// 		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		// 		Eventually(func() error {
-		// 			f := &keyhubv1alpha1.KeyHubSecret{}
+		// 			f := &keyhubv1beta1.KeyHubSecret{}
 		// 			k8sClient.Get(context.Background(), key, f)
 		// 			return k8sClient.Delete(context.Background(), f)
 		// 		}, timeout, interval).Should(Succeed())
@@ -234,16 +235,16 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 	})
 
 		// 	It("Should not make excessive api calls to KeyHub", func() {
-		// 		spec := keyhubv1alpha1.KeyHubSecretSpec{
-		// 			Template: keyhubv1alpha1.SecretTemplate{
+		// 		spec := keyhubv1beta1.KeyHubSecretSpec{
+		// 			Template: keyhubv1beta1.SecretTemplate{
 		// 				Type: corev1.SecretTypeBasicAuth,
-		// 				Metadata: keyhubv1alpha1.SecretTemplateMetadata{
+		// 				Metadata: keyhubv1beta1.SecretTemplateMetadata{
 		// 					Labels: map[string]string{
 		// 						"iteration": "0",
 		// 					},
 		// 				},
 		// 			},
-		// 			Data: []keyhubv1alpha1.SecretKeyReference{
+		// 			Data: []keyhubv1beta1.SecretKeyReference{
 		// 				{Name: "auth", Record: "1001-0002"},
 		// 			},
 		// 		}
@@ -253,7 +254,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 			Namespace: "default",
 		// 		}
 
-		// 		toCreate := &keyhubv1alpha1.KeyHubSecret{
+		// 		toCreate := &keyhubv1beta1.KeyHubSecret{
 		// 			ObjectMeta: metav1.ObjectMeta{
 		// 				Name:      "sample-ks",
 		// 				Namespace: "default",
@@ -306,7 +307,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 		By("By repeatedly triggering a reconcile")
 		// 		for i := 1; i <= 3; i++ {
 		// 			By("By setting the 'iteration' label")
-		// 			fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+		// 			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 		// 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 		// 			fetchedKeyHubSecret.Spec.Template.Metadata.Labels["iteration"] = strconv.Itoa(i)
 		// 			k8sClient.Update(context.Background(), fetchedKeyHubSecret)
@@ -339,9 +340,9 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// 			Expect(actualKeyhubApiCalls).To(Equal(allowedKeyhubApiCalls))
 		// 		}
 
-		// 		By("Deleting the KeyHubSecret and Secret")
+		// 		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		// 		Eventually(func() error {
-		// 			f := &keyhubv1alpha1.KeyHubSecret{}
+		// 			f := &keyhubv1beta1.KeyHubSecret{}
 		// 			k8sClient.Get(context.Background(), key, f)
 		// 			return k8sClient.Delete(context.Background(), f)
 		// 		}, timeout, interval).Should(Succeed())
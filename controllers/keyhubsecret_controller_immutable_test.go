@@ -0,0 +1,162 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("KeyHubSecret Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	var manifestToLog interface{}
+
+	BeforeEach(func() {
+		manifestToLog = nil
+
+		// Flush caches
+		policyEngine.Flush()
+		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
+
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+
+		// Reset Prometheus collectors
+		controllerMetrics.Reset()
+	})
+
+	AfterEach(func() {
+		// Add any teardown steps that needs to be executed after each test
+		controllers_test.LogManifest(manifestToLog)
+	})
+
+	Context("Immutable Secrets", func() {
+		It("Should create a new Secret for every change", func() {
+			limit := int32(1)
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
+					Immutable: true,
+				},
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+				},
+				RevisionHistoryLimit: &limit,
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the immutable Secret is created")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				return meta.IsStatusConditionTrue(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced)) &&
+					len(fetchedKeyHubSecret.Status.SecretName) == len("sample-ks-")+10 &&
+					strings.HasPrefix(fetchedKeyHubSecret.Status.SecretName, "sample-ks-")
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			firstName := fetchedKeyHubSecret.Status.SecretName
+
+			fetched := &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: firstName, Namespace: "default"}, fetched)).Should(Succeed())
+			Expect(fetched.Immutable).ToNot(BeNil())
+			Expect(*fetched.Immutable).To(BeTrue())
+			Expect(string(fetched.Data["username"])).To(Equal("admin"))
+			Expect(errors.IsNotFound(k8sClient.Get(context.Background(), key, &corev1.Secret{}))).To(BeTrue())
+
+			By("By changing the KeyHubSecret")
+			Eventually(func() error {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				fetchedKeyHubSecret.Spec.Data = append(fetchedKeyHubSecret.Spec.Data,
+					keyhubv1beta1.SecretKeyReference{Name: "password", Record: "00000000-0000-0000-1001-000000000002", Property: "password"})
+				return k8sClient.Update(context.Background(), fetchedKeyHubSecret)
+			}, timeout, interval).Should(Succeed())
+
+			By("By checking a new Secret is created and the previous one is kept")
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				return fetchedKeyHubSecret.Status.SecretName != firstName &&
+					strings.HasPrefix(fetchedKeyHubSecret.Status.SecretName, "sample-ks-")
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			secondName := fetchedKeyHubSecret.Status.SecretName
+
+			fetched = &corev1.Secret{}
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: secondName, Namespace: "default"}, fetched)).Should(Succeed())
+			Expect(string(fetched.Data["username"])).To(Equal("admin"))
+			Expect(string(fetched.Data["password"])).To(Equal("test1234"))
+			Expect(fetched.Annotations["keyhub.topicus.nl/revision"]).To(Equal("2"))
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: firstName, Namespace: "default"}, &corev1.Secret{})).Should(Succeed())
+
+			By("By changing the KeyHubSecret again")
+			Eventually(func() error {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				fetchedKeyHubSecret.Spec.Data = fetchedKeyHubSecret.Spec.Data[1:]
+				return k8sClient.Update(context.Background(), fetchedKeyHubSecret)
+			}, timeout, interval).Should(Succeed())
+
+			By("By checking the oldest Secret is deleted")
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				return fetchedKeyHubSecret.Status.SecretName != firstName &&
+					fetchedKeyHubSecret.Status.SecretName != secondName &&
+					errors.IsNotFound(k8sClient.Get(context.Background(), types.NamespacedName{Name: firstName, Namespace: "default"}, &corev1.Secret{}))
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			thirdName := fetchedKeyHubSecret.Status.SecretName
+			Expect(k8sClient.Get(context.Background(), types.NamespacedName{Name: secondName, Namespace: "default"}, &corev1.Secret{})).Should(Succeed())
+
+			By("Deleting the KeyHubSecret and checking the Secrets are deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			for _, name := range []string{secondName, thirdName} {
+				Eventually(func() bool {
+					f := &corev1.Secret{}
+					return errors.IsNotFound(k8sClient.Get(context.Background(), types.NamespacedName{Name: name, Namespace: "default"}, f))
+				}, timeout, interval).Should(BeTrue())
+			}
+		})
+	})
+})
@@ -11,9 +11,10 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -33,6 +34,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// Flush caches
 		policyEngine.Flush()
 		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
 
 		// Failed test runs that don't clean up leave resources behind.
 		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
@@ -49,8 +51,8 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 	Context("Labels and annotations", func() {
 		It("Should handle default labels correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Data: []keyhubv1alpha1.SecretKeyReference{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
 				},
 			}
@@ -60,7 +62,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -94,29 +96,28 @@ var _ = Describe("KeyHubSecret Controller", func() {
 					fetched.Labels["app.kubernetes.io/part-of"] == "part-of"
 			}, timeout, interval).Should(BeTrue())
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		It("Should handle custom labels correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
-					Metadata: keyhubv1alpha1.SecretTemplateMetadata{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
+					Metadata: keyhubv1beta1.SecretTemplateMetadata{
 						Labels: map[string]string{
 							"custom-label": "custom-value",
 						},
 					},
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
 				},
 			}
@@ -126,7 +127,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -145,29 +146,28 @@ var _ = Describe("KeyHubSecret Controller", func() {
 					fetched.Labels["custom-label"] == "custom-value"
 			}, timeout, interval).Should(BeTrue())
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		It("Should handle annotations correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
-					Metadata: keyhubv1alpha1.SecretTemplateMetadata{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
+					Metadata: keyhubv1beta1.SecretTemplateMetadata{
 						Annotations: map[string]string{
 							"custom-annotation": "custom-value",
 						},
 					},
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
 				},
 			}
@@ -177,7 +177,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -197,17 +197,16 @@ var _ = Describe("KeyHubSecret Controller", func() {
 					fetched.Annotations["custom-annotation"] == "custom-value"
 			}, timeout, interval).Should(BeTrue())
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 	})
 })
@@ -12,9 +12,11 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -34,6 +36,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// Flush caches
 		policyEngine.Flush()
 		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
 
 		// Failed test runs that don't clean up leave resources behind.
 		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
@@ -50,8 +53,8 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 	Context("Opaque secret", func() {
 		It("Should handle keys correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Data: []keyhubv1alpha1.SecretKeyReference{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
 					{Name: "password", Record: "00000000-0000-0000-1001-000000000002", Property: "password"},
 					{Name: "password_by_default", Record: "00000000-0000-0000-1001-000000000002"},
@@ -67,7 +70,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -99,7 +102,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By checking the KeyHubSecret status")
-			fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
@@ -109,27 +112,37 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 				return len(records) == 1 &&
 					len(keys) == 7 &&
+					len(fetchedKeyHubSecret.Status.SecretKeyErrors) == 0 &&
 					records[0].RecordID == "00000000-0000-0000-1001-000000000002" &&
-					records[0].Name == "Username + password"
+					records[0].Name == "Username + password" &&
+					fetchedKeyHubSecret.Status.MatchedPolicy != nil &&
+					*fetchedKeyHubSecret.Status.MatchedPolicy == keyhubv1beta1.PolicyStatus{
+						Policy:   "type=namespace name='default'",
+						ClientID: "CLIENT-0001",
+						Source:   "Controller Policy Group/Controller Client 1 (00000000-0000-0000-1000-000000000002) #0",
+					} &&
+					meta.IsStatusConditionTrue(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypePolicyMatched)) &&
+					meta.IsStatusConditionTrue(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeRecordsResolved)) &&
+					meta.IsStatusConditionTrue(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced)) &&
+					meta.IsStatusConditionTrue(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeReady))
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		It("Should handle KeyHubSecret updates correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Data: []keyhubv1alpha1.SecretKeyReference{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
 					{Name: "pkey", Record: "00000000-0000-0000-1001-000000000004", Property: "username"},
 				},
@@ -140,7 +153,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -166,7 +179,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By checking the KeyHubSecret status")
-			fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
@@ -208,9 +221,9 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By updating the KeyHubSecret")
-			fetchedKeyHubSecret = &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret = &keyhubv1beta1.KeyHubSecret{}
 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
-			fetchedKeyHubSecret.Spec.Data = []keyhubv1alpha1.SecretKeyReference{
+			fetchedKeyHubSecret.Spec.Data = []keyhubv1beta1.SecretKeyReference{
 				{Name: "username", Record: "00000000-0000-0000-1001-000000000003", Property: "username"},
 				{Name: "cacerts", Record: "00000000-0000-0000-1001-000000000005", Property: "username"},
 			}
@@ -231,7 +244,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By checking the KeyHubSecret status is updated")
-			fetchedKeyHubSecret = &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret = &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
@@ -254,17 +267,289 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Should report failed keys", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					{Name: "missing", Record: "00000000-0000-0000-1001-999999999999"},
+					{Name: "unsupported", Record: "00000000-0000-0000-1001-000000000002", Property: "totp"},
+				},
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the Secret is created with the valid keys")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetched)
+				manifestToLog = fetched
+
+				return fetched.Type == corev1.SecretTypeOpaque &&
+					len(fetched.Data) == 1 &&
+					string(fetched.Data["username"]) == "admin"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("By checking the KeyHubSecret status")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				keyErrors := fetchedKeyHubSecret.Status.SecretKeyErrors
+				conditions := fetchedKeyHubSecret.Status.Conditions
+				recordsResolved := meta.FindStatusCondition(conditions, string(keyhubv1beta1.TypeRecordsResolved))
+				synced := meta.FindStatusCondition(conditions, string(keyhubv1beta1.TypeSynced))
+				ready := meta.FindStatusCondition(conditions, string(keyhubv1beta1.TypeReady))
+
+				return meta.IsStatusConditionFalse(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced)) &&
+					len(keyErrors) == 2 &&
+					keyErrors[0].Key == "missing" &&
+					keyErrors[0].Record == "00000000-0000-0000-1001-999999999999" &&
+					keyErrors[0].Reason == keyhubv1beta1.RecordNotFound &&
+					keyErrors[1].Key == "unsupported" &&
+					keyErrors[1].Reason == keyhubv1beta1.UnsupportedProperty &&
+					keyErrors[1].Message == "Unsupported property 'totp'" &&
+					recordsResolved != nil &&
+					recordsResolved.Status == metav1.ConditionFalse &&
+					recordsResolved.Reason == string(keyhubv1beta1.RecordNotFound) &&
+					synced != nil &&
+					synced.Status == metav1.ConditionFalse &&
+					synced.Reason == string(keyhubv1beta1.KeysFailed) &&
+					synced.Message == "Failed to sync key(s): missing (RecordNotFound), unsupported (UnsupportedProperty)" &&
+					ready != nil &&
+					ready.Status == metav1.ConditionFalse &&
+					ready.Reason == string(keyhubv1beta1.RecordNotFound)
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Should report a missing policy", func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "no-policy",
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), ns)).Should(Succeed())
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "no-policy",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "no-policy",
+				},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the KeyHubSecret status")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				conditions := fetchedKeyHubSecret.Status.Conditions
+				policyMatched := meta.FindStatusCondition(conditions, string(keyhubv1beta1.TypePolicyMatched))
+				ready := meta.FindStatusCondition(conditions, string(keyhubv1beta1.TypeReady))
+
+				return policyMatched != nil &&
+					policyMatched.Status == metav1.ConditionFalse &&
+					policyMatched.Reason == string(keyhubv1beta1.NoPolicyMatch) &&
+					policyMatched.Message == "No credentials found for namespace no-policy" &&
+					meta.IsStatusConditionFalse(conditions, string(keyhubv1beta1.TypeSynced)) &&
+					ready != nil &&
+					ready.Status == metav1.ConditionFalse &&
+					ready.Reason == string(keyhubv1beta1.NoPolicyMatch)
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("Deleting the KeyHubSecret")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
+		})
+
+		It("Should resolve records by group and name", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", RecordRef: &keyhubv1beta1.VaultRecordReference{Group: "Group 1001", Name: "Username + password"}, Property: "username"},
+					{Name: "password", RecordRef: &keyhubv1beta1.VaultRecordReference{Group: "00000000-0000-0000-1001-000000000000", Name: "Username.*", Regex: true}},
+					{Name: "ambiguous", RecordRef: &keyhubv1beta1.VaultRecordReference{Group: "Group 1001", Name: "PKCS#12 .*", Regex: true}},
+				},
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the referenced records are synced")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetched)
+				manifestToLog = fetched
+
+				return string(fetched.Data["username"]) == "admin" &&
+					string(fetched.Data["password"]) == "test1234"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			Expect(fetched.Data).NotTo(HaveKey("ambiguous"))
+
+			By("By checking the ambiguous reference is reported")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				keyErrors := fetchedKeyHubSecret.Status.SecretKeyErrors
+				return len(keyErrors) == 1 &&
+					keyErrors[0].Key == "ambiguous" &&
+					keyErrors[0].Reason == keyhubv1beta1.RecordAmbiguous &&
+					meta.IsStatusConditionFalse(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeRecordsResolved))
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
 				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Should import the records of a group vault", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "p12-ECDSA", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+				},
+				DataFrom: []keyhubv1beta1.VaultImport{
+					{
+						Group:       "Group 1001",
+						NamePattern: "PKCS#12 .*",
+						Color:       "NONE",
+						Property:    "lastModifiedAt",
+						Rewrite:     &keyhubv1beta1.KeyRewrite{Regex: "^PKCS#12 (.*)$", Replacement: "p12-${1}"},
+					},
+				},
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the records are imported")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetched)
+				manifestToLog = fetched
+
+				return len(fetched.Data) == 4
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			Expect(fetched.Data).To(HaveKey("p12-without_CA_chain"))
+			Expect(fetched.Data).To(HaveKey("p12-with_CA_chain"))
+			Expect(fetched.Data).To(HaveKey("p12-Ed25519"))
+			Expect(string(fetched.Data["p12-ECDSA"])).To(Equal("admin"))
+
+			By("By checking the conflicting key is reported")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				keyErrors := fetchedKeyHubSecret.Status.SecretKeyErrors
+				return len(keyErrors) == 1 &&
+					keyErrors[0].Key == "p12-ECDSA" &&
+					keyErrors[0].Reason == keyhubv1beta1.ImportFailed
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 	})
 })
@@ -0,0 +1,66 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("KeyHubSecret Controller", func() {
+
+	Context("Refresh interval", func() {
+		r := &KeyHubSecretReconciler{
+			RefreshInterval: 5 * time.Minute,
+			RefreshJitter:   0.1,
+		}
+
+		It("Should use the default refresh interval", func() {
+			ks := &keyhubv1beta1.KeyHubSecret{}
+			for i := 0; i < 100; i++ {
+				interval := r.refreshInterval(ks)
+				Expect(interval).To(BeNumerically(">=", 5*time.Minute))
+				Expect(interval).To(BeNumerically("<=", 5*time.Minute+30*time.Second))
+			}
+		})
+
+		It("Should use the refresh interval of the KeyHubSecret", func() {
+			ks := &keyhubv1beta1.KeyHubSecret{
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					RefreshInterval: &metav1.Duration{Duration: time.Hour},
+				},
+			}
+			for i := 0; i < 100; i++ {
+				interval := r.refreshInterval(ks)
+				Expect(interval).To(BeNumerically(">=", time.Hour))
+				Expect(interval).To(BeNumerically("<=", time.Hour+6*time.Minute))
+			}
+		})
+
+		It("Should spread the refreshes", func() {
+			ks := &keyhubv1beta1.KeyHubSecret{}
+			intervals := make(map[time.Duration]struct{})
+			for i := 0; i < 100; i++ {
+				intervals[r.refreshInterval(ks)] = struct{}{}
+			}
+			Expect(len(intervals)).To(BeNumerically(">", 1))
+		})
+
+		It("Should not add jitter when the jitter is 0", func() {
+			r := &KeyHubSecretReconciler{
+				RefreshInterval: 5 * time.Minute,
+				RefreshJitter:   0,
+			}
+			ks := &keyhubv1beta1.KeyHubSecret{}
+			for i := 0; i < 100; i++ {
+				Expect(r.refreshInterval(ks)).To(Equal(5 * time.Minute))
+			}
+		})
+	})
+})
@@ -5,20 +5,18 @@ package controllers
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
 	"strconv"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	"golang.org/x/crypto/bcrypt"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
@@ -38,6 +36,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// Flush caches
 		policyEngine.Flush()
 		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
 
 		// Failed test runs that don't clean up leave resources behind.
 		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
@@ -54,11 +53,11 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 	Context("SSHAuth secret", func() {
 		It("Should handle ssh auth correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
 					Type: corev1.SecretTypeSSHAuth,
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "key", Record: "00000000-0000-0000-1001-000000000002"},
 				},
 			}
@@ -68,7 +67,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -88,25 +87,24 @@ var _ = Describe("KeyHubSecret Controller", func() {
 					string(fetched.Data[corev1.SSHAuthPrivateKey]) == "lorem ipsum"
 			}, timeout, interval).Should(BeTrue())
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		It("Should handle KeyHubSecret updates correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
 					Type: corev1.SecretTypeSSHAuth,
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "key", Record: "00000000-0000-0000-1001-000000000002"},
 				},
 			}
@@ -116,7 +114,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -140,7 +138,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By checking the KeyHubSecret status")
-			fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
@@ -148,21 +146,17 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				records := fetchedKeyHubSecret.Status.VaultRecordStatuses
 				keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
 
-				encKey := make([]byte, base64.StdEncoding.EncodedLen(len("lorem ipsum")))
-				base64.StdEncoding.Encode(encKey, []byte("lorem ipsum"))
-				shaKey := sha256.Sum256(encKey)
-
 				return len(records) == 1 &&
 					len(keys) == 1 &&
 					records[0].RecordID == "00000000-0000-0000-1001-000000000002" &&
 					records[0].Name == "Username + password" &&
 					keys[0].Key == "ssh-privatekey" &&
-					bcrypt.CompareHashAndPassword(keys[0].Hash, shaKey[:]) == nil
+					secretKeyHasher.Verify(keys[0].Hash, []byte("lorem ipsum"))
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
 			By("By updating the KeyHubSecret")
-			fetchedKeyHubSecret.Spec.Data = []keyhubv1alpha1.SecretKeyReference{
+			fetchedKeyHubSecret.Spec.Data = []keyhubv1beta1.SecretKeyReference{
 				{Name: "key", Record: "00000000-0000-0000-1001-000000000008"},
 			}
 			k8sClient.Update(context.Background(), fetchedKeyHubSecret)
@@ -180,7 +174,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			manifestToLog = nil
 
 			By("By checking the KeyHubSecret status is updated correctly")
-			fetchedKeyHubSecret = &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret = &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
@@ -188,43 +182,38 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				records := fetchedKeyHubSecret.Status.VaultRecordStatuses
 				keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
 
-				encKey := make([]byte, base64.StdEncoding.EncodedLen(len("consectetur adipiscing elit")))
-				base64.StdEncoding.Encode(encKey, []byte("consectetur adipiscing elit"))
-				shaKey := sha256.Sum256(encKey)
-
 				return len(records) == 1 &&
 					len(keys) == 1 &&
 					records[0].RecordID == "00000000-0000-0000-1001-000000000008" &&
 					records[0].Name == "key file" &&
 					keys[0].Key == "ssh-privatekey" &&
-					bcrypt.CompareHashAndPassword(keys[0].Hash, shaKey[:]) == nil
+					secretKeyHasher.Verify(keys[0].Hash, []byte("consectetur adipiscing elit"))
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 		It("Should not make excessive api calls to KeyHub", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
 					Type: corev1.SecretTypeSSHAuth,
-					Metadata: keyhubv1alpha1.SecretTemplateMetadata{
+					Metadata: keyhubv1beta1.SecretTemplateMetadata{
 						Labels: map[string]string{
 							"iteration": "0",
 						},
 					},
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "key", Record: "00000000-0000-0000-1001-000000000002"},
 				},
 			}
@@ -234,7 +223,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -287,7 +276,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			By("By repeatedly triggering a reconcile")
 			for i := 1; i <= 3; i++ {
 				By("By setting the 'iteration' label")
-				fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+				fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				fetchedKeyHubSecret.Spec.Template.Metadata.Labels["iteration"] = strconv.Itoa(i)
 				k8sClient.Update(context.Background(), fetchedKeyHubSecret)
@@ -320,17 +309,16 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Expect(actualKeyhubApiCalls).To(Equal(allowedKeyhubApiCalls))
 			}
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 
 	})
@@ -0,0 +1,208 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("KeyHubSecret Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	var manifestToLog interface{}
+
+	BeforeEach(func() {
+		manifestToLog = nil
+
+		// Flush caches
+		policyEngine.Flush()
+		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
+
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+
+		// Reset Prometheus collectors
+		controllerMetrics.Reset()
+	})
+
+	AfterEach(func() {
+		// Add any teardown steps that needs to be executed after each test
+		controllers_test.LogManifest(manifestToLog)
+	})
+
+	Context("Sync controls", func() {
+		It("Should not sync a suspended KeyHubSecret", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+				},
+				Suspend: true,
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new suspended KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the KeyHubSecret is reported as suspended")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				ready := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeReady))
+				return ready != nil &&
+					ready.Status == metav1.ConditionFalse &&
+					ready.Reason == string(keyhubv1beta1.Suspended)
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("By checking the Secret is not created")
+			Consistently(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, 3*time.Second, interval).Should(BeTrue())
+
+			By("By resuming the KeyHubSecret")
+			Eventually(func() error {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				fetchedKeyHubSecret.Spec.Suspend = false
+				return k8sClient.Update(context.Background(), fetchedKeyHubSecret)
+			}, timeout, interval).Should(Succeed())
+
+			By("By checking the Secret is created")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetched)
+				manifestToLog = fetched
+
+				return string(fetched.Data["username"]) == "admin"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				return meta.IsStatusConditionTrue(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeReady))
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Should force a sync", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+				},
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the KeyHubSecret is synced")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				return meta.IsStatusConditionTrue(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced))
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			Expect(fetchedKeyHubSecret.Status.ForcedSync).To(BeNil())
+
+			groupListRequests := testutil.ToFloat64(controllerMetrics.KeyHubApiRequests.WithLabelValues("group", "list"))
+			vaultGetRequests := testutil.ToFloat64(controllerMetrics.KeyHubApiRequests.WithLabelValues("vault", "get"))
+
+			By("By requesting a forced sync")
+			Eventually(func() error {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				fetchedKeyHubSecret.Annotations = map[string]string{
+					keyhubv1beta1.ForceSyncAnnotation: "2024-01-01T00:00:00Z",
+				}
+				return k8sClient.Update(context.Background(), fetchedKeyHubSecret)
+			}, timeout, interval).Should(Succeed())
+
+			By("By checking the forced sync is recorded")
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				forcedSync := fetchedKeyHubSecret.Status.ForcedSync
+				return forcedSync != nil &&
+					forcedSync.Request == "2024-01-01T00:00:00Z" &&
+					!forcedSync.SyncedAt.IsZero()
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("By checking the vault index and record are fetched from KeyHub")
+			Expect(testutil.ToFloat64(controllerMetrics.KeyHubApiRequests.WithLabelValues("group", "list"))).To(BeNumerically(">", groupListRequests))
+			Expect(testutil.ToFloat64(controllerMetrics.KeyHubApiRequests.WithLabelValues("vault", "get"))).To(Equal(vaultGetRequests + 1))
+
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+})
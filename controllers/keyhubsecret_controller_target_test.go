@@ -0,0 +1,291 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("KeyHubSecret Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	var manifestToLog interface{}
+
+	BeforeEach(func() {
+		manifestToLog = nil
+
+		// Flush caches
+		policyEngine.Flush()
+		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
+
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+
+		// Reset Prometheus collectors
+		controllerMetrics.Reset()
+	})
+
+	AfterEach(func() {
+		// Add any teardown steps that needs to be executed after each test
+		controllers_test.LogManifest(manifestToLog)
+	})
+
+	Context("Target", func() {
+		It("Should create the Secret with the target name", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Target: keyhubv1beta1.SecretTarget{
+					Name: "sample-target",
+				},
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+				},
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+			targetKey := types.NamespacedName{
+				Name:      "sample-target",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the Secret is created with the target name")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), targetKey, fetched)
+				manifestToLog = fetched
+
+				return len(fetched.OwnerReferences) == 1 &&
+					fetched.OwnerReferences[0].Name == "sample-ks" &&
+					string(fetched.Data["username"]) == "admin"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			Expect(errors.IsNotFound(k8sClient.Get(context.Background(), key, &corev1.Secret{}))).To(BeTrue())
+
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), targetKey, f))
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Should merge the keys into an existing Secret", func() {
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+			targetKey := types.NamespacedName{
+				Name:      "sample-helm",
+				Namespace: "default",
+			}
+
+			By("By creating the existing Secret")
+			existing := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-helm",
+					Namespace: "default",
+					Labels: map[string]string{
+						"app.kubernetes.io/managed-by": "Helm",
+					},
+				},
+				Data: map[string][]byte{
+					"foo": []byte("bar"),
+				},
+			}
+			Expect(k8sClient.Create(context.Background(), existing)).Should(Succeed())
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+					Labels: map[string]string{
+						"app.kubernetes.io/managed-by": "kustomize",
+					},
+				},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Target: keyhubv1beta1.SecretTarget{
+						Name:           "sample-helm",
+						CreationPolicy: keyhubv1beta1.CreationPolicyMerge,
+					},
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the keys are merged into the Secret")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), targetKey, fetched)
+				manifestToLog = fetched
+
+				return len(fetched.OwnerReferences) == 0 &&
+					fetched.Type == corev1.SecretTypeOpaque &&
+					fetched.Labels["app.kubernetes.io/managed-by"] == "Helm" &&
+					len(fetched.Data) == 2 &&
+					string(fetched.Data["foo"]) == "bar" &&
+					string(fetched.Data["username"]) == "admin"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("Deleting the KeyHubSecret")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+
+			By("By checking the merged keys are removed from the Secret")
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), targetKey, fetched)
+				manifestToLog = fetched
+
+				return len(fetched.Data) == 1 &&
+					string(fetched.Data["foo"]) == "bar"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+		})
+
+		It("Should require an existing Secret to merge into", func() {
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Target: keyhubv1beta1.SecretTarget{
+						CreationPolicy: keyhubv1beta1.CreationPolicyMerge,
+					},
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the KeyHubSecret status")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				synced := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced))
+				return synced != nil &&
+					synced.Status == metav1.ConditionFalse &&
+					synced.Reason == string(keyhubv1beta1.SyncFailed) &&
+					synced.Message == "Secret sample-ks not found, creation policy Merge requires an existing Secret"
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			Expect(errors.IsNotFound(k8sClient.Get(context.Background(), key, &corev1.Secret{}))).To(BeTrue())
+
+			By("Deleting the KeyHubSecret")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+		})
+
+		It("Should only validate with creation policy None", func() {
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Target: keyhubv1beta1.SecretTarget{
+						CreationPolicy: keyhubv1beta1.CreationPolicyNone,
+					},
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the KeyHubSecret status")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				conditions := fetchedKeyHubSecret.Status.Conditions
+				ready := meta.FindStatusCondition(conditions, string(keyhubv1beta1.TypeReady))
+				return meta.IsStatusConditionTrue(conditions, string(keyhubv1beta1.TypePolicyMatched)) &&
+					meta.IsStatusConditionTrue(conditions, string(keyhubv1beta1.TypeRecordsResolved)) &&
+					ready != nil &&
+					ready.Status == metav1.ConditionTrue &&
+					ready.Reason == string(keyhubv1beta1.NotManaged)
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+
+			By("By checking the Secret is not created")
+			Consistently(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, 3*time.Second, interval).Should(BeTrue())
+
+			By("Deleting the KeyHubSecret")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+		})
+	})
+})
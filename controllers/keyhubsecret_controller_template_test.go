@@ -0,0 +1,119 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("KeyHubSecret Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	var manifestToLog interface{}
+
+	BeforeEach(func() {
+		manifestToLog = nil
+
+		// Flush caches
+		policyEngine.Flush()
+		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
+
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+
+		// Reset Prometheus collectors
+		controllerMetrics.Reset()
+	})
+
+	AfterEach(func() {
+		// Add any teardown steps that needs to be executed after each test
+		controllers_test.LogManifest(manifestToLog)
+	})
+
+	Context("Template data", func() {
+		It("Should render templates over the records", func() {
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
+					Data: map[string]string{
+						"jdbc-url":    "jdbc:postgresql://db:5432/app?user={{ .db.username }}&password={{ .db.password | urlquery }}",
+						"config.json": `{"url": {{ .db.link | quote }}, "file": {{ .db.file | b64enc | quote }}}`,
+						"broken":      "{{ .db.unknown }}",
+					},
+				},
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "db", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+				},
+			}
+
+			key := types.NamespacedName{
+				Name:      "sample-ks",
+				Namespace: "default",
+			}
+
+			toCreate := &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "sample-ks",
+					Namespace: "default",
+				},
+				Spec: spec,
+			}
+
+			By("By creating a new KeyHubSecret")
+			Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+			By("By checking the templates are rendered")
+			fetched := &corev1.Secret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetched)
+				manifestToLog = fetched
+
+				return len(fetched.Data) == 3
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			Expect(string(fetched.Data["db"])).To(Equal("admin"))
+			Expect(string(fetched.Data["jdbc-url"])).To(Equal("jdbc:postgresql://db:5432/app?user=admin&password=test1234"))
+			Expect(string(fetched.Data["config.json"])).To(Equal(`{"url": "http://example.com", "file": "bG9yZW0gaXBzdW0="}`))
+
+			By("By checking the failing template is reported")
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+			Eventually(func() bool {
+				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+				manifestToLog = fetchedKeyHubSecret
+
+				return len(fetchedKeyHubSecret.Status.SecretKeyErrors) == 1
+			}, timeout, interval).Should(BeTrue())
+			manifestToLog = nil
+			Expect(fetchedKeyHubSecret.Status.SecretKeyErrors[0].Key).To(Equal("broken"))
+			Expect(fetchedKeyHubSecret.Status.SecretKeyErrors[0].Reason).To(Equal(keyhubv1beta1.TemplateFailed))
+
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
+			Eventually(func() error {
+				f := &keyhubv1beta1.KeyHubSecret{}
+				k8sClient.Get(context.Background(), key, f)
+				return k8sClient.Delete(context.Background(), f)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() bool {
+				f := &corev1.Secret{}
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+})
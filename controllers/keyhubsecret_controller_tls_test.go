@@ -6,17 +6,21 @@ package controllers
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/pem"
 	"fmt"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
 	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
 )
@@ -34,6 +38,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		// Flush caches
 		policyEngine.Flush()
 		vaultIndexCache.Flush()
+		vaultRecordCache.Flush()
 
 		// Failed test runs that don't clean up leave resources behind.
 		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
@@ -50,11 +55,11 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 	Context("TLS secret", func() {
 		It("Should handle seperate records correctly", func() {
-			spec := keyhubv1alpha1.KeyHubSecretSpec{
-				Template: keyhubv1alpha1.SecretTemplate{
+			spec := keyhubv1beta1.KeyHubSecretSpec{
+				Template: keyhubv1beta1.SecretTemplate{
 					Type: corev1.SecretTypeTLS,
 				},
-				Data: []keyhubv1alpha1.SecretKeyReference{
+				Data: []keyhubv1beta1.SecretKeyReference{
 					{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000003"},
 					{Name: "tls.key", Record: "00000000-0000-0000-1001-000000000004"},
 				},
@@ -65,7 +70,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				Namespace: "default",
 			}
 
-			toCreate := &keyhubv1alpha1.KeyHubSecret{
+			toCreate := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
@@ -106,16 +111,20 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			}, timeout, interval).Should(BeTrue())
 
 			By("By checking the KeyHubSecret status")
-			fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+			fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 			Eventually(func() bool {
 				k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 				manifestToLog = fetchedKeyHubSecret
 
 				records := fetchedKeyHubSecret.Status.VaultRecordStatuses
 				keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
+				condition := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeCertificateValid))
 
 				return len(records) == 2 &&
 					len(keys) == 2 &&
+					condition != nil &&
+					condition.Status == metav1.ConditionTrue &&
+					condition.Reason == string(keyhubv1beta1.CertificateValid) &&
 					records[0].RecordID == "00000000-0000-0000-1001-000000000004" &&
 					records[0].Name == "Privatekey" &&
 					records[1].RecordID == "00000000-0000-0000-1001-000000000003" &&
@@ -125,27 +134,26 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			}, timeout, interval).Should(BeTrue())
 			manifestToLog = nil
 
-			By("Deleting the KeyHubSecret and Secret")
+			By("Deleting the KeyHubSecret and checking the Secret is deleted")
 			Eventually(func() error {
-				f := &keyhubv1alpha1.KeyHubSecret{}
+				f := &keyhubv1beta1.KeyHubSecret{}
 				k8sClient.Get(context.Background(), key, f)
 				return k8sClient.Delete(context.Background(), f)
 			}, timeout, interval).Should(Succeed())
-			Eventually(func() error {
+			Eventually(func() bool {
 				f := &corev1.Secret{}
-				k8sClient.Get(context.Background(), key, f)
-				return k8sClient.Delete(context.Background(), f)
-			}, timeout, interval).Should(Succeed())
+				return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+			}, timeout, interval).Should(BeTrue())
 		})
 	})
 
 	It("Should handle seperate records with ca certs correctly", func() {
-		spec := keyhubv1alpha1.KeyHubSecretSpec{
-			Template: keyhubv1alpha1.SecretTemplate{
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
 				Type: corev1.SecretTypeTLS,
 			},
-			Data: []keyhubv1alpha1.SecretKeyReference{
-				{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000003"},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000017"},
 				{Name: "tls.key", Record: "00000000-0000-0000-1001-000000000004"},
 				{Name: "ca.crt", Record: "00000000-0000-0000-1001-000000000005"},
 			},
@@ -156,7 +164,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			Namespace: "default",
 		}
 
-		toCreate := &keyhubv1alpha1.KeyHubSecret{
+		toCreate := &keyhubv1beta1.KeyHubSecret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "sample-ks",
 				Namespace: "default",
@@ -181,12 +189,12 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 			return fetched.Type == corev1.SecretTypeTLS &&
 				len(fetched.Data) == 2 &&
-				crtHash == "7827b5dc11728f92186350064bf28208a503dc0c4f14806d33eae1a659b0dee0" &&
+				crtHash == "3240e3a5051e3089bdd4576be16d5aa881ee8776d7d1c13a7e28b0ce7e29b9a3" &&
 				keyHash == "9f76ad4473255e2220c0bbb64a20f1ceec58565ff2f17728fe7bb2f057fe8dcb"
 		}, timeout, interval).Should(BeTrue())
 
 		By("By checking the KeyHubSecret status")
-		fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 		Eventually(func() bool {
 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 			manifestToLog = fetchedKeyHubSecret
@@ -198,8 +206,8 @@ var _ = Describe("KeyHubSecret Controller", func() {
 				len(keys) == 2 &&
 				records[0].RecordID == "00000000-0000-0000-1001-000000000004" &&
 				records[0].Name == "Privatekey" &&
-				records[1].RecordID == "00000000-0000-0000-1001-000000000003" &&
-				records[1].Name == "Certificate" &&
+				records[1].RecordID == "00000000-0000-0000-1001-000000000017" &&
+				records[1].Name == "Certificate signed by CA" &&
 				records[2].RecordID == "00000000-0000-0000-1001-000000000005" &&
 				records[2].Name == "CA Certs" &&
 				keys[0].Key == corev1.TLSCertKey &&
@@ -207,25 +215,24 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
-		By("Deleting the KeyHubSecret and Secret")
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		Eventually(func() error {
-			f := &keyhubv1alpha1.KeyHubSecret{}
+			f := &keyhubv1beta1.KeyHubSecret{}
 			k8sClient.Get(context.Background(), key, f)
 			return k8sClient.Delete(context.Background(), f)
 		}, timeout, interval).Should(Succeed())
-		Eventually(func() error {
+		Eventually(func() bool {
 			f := &corev1.Secret{}
-			k8sClient.Get(context.Background(), key, f)
-			return k8sClient.Delete(context.Background(), f)
-		}, timeout, interval).Should(Succeed())
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
 	})
 
 	It("Should handle pem correctly", func() {
-		spec := keyhubv1alpha1.KeyHubSecretSpec{
-			Template: keyhubv1alpha1.SecretTemplate{
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
 				Type: corev1.SecretTypeTLS,
 			},
-			Data: []keyhubv1alpha1.SecretKeyReference{
+			Data: []keyhubv1beta1.SecretKeyReference{
 				{Name: "pem", Record: "00000000-0000-0000-1001-000000000006"},
 			},
 		}
@@ -235,7 +242,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			Namespace: "default",
 		}
 
-		toCreate := &keyhubv1alpha1.KeyHubSecret{
+		toCreate := &keyhubv1beta1.KeyHubSecret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "sample-ks",
 				Namespace: "default",
@@ -265,7 +272,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 
 		By("By checking the KeyHubSecret status")
-		fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 		Eventually(func() bool {
 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 			manifestToLog = fetchedKeyHubSecret
@@ -282,25 +289,24 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
-		By("Deleting the KeyHubSecret and Secret")
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		Eventually(func() error {
-			f := &keyhubv1alpha1.KeyHubSecret{}
+			f := &keyhubv1beta1.KeyHubSecret{}
 			k8sClient.Get(context.Background(), key, f)
 			return k8sClient.Delete(context.Background(), f)
 		}, timeout, interval).Should(Succeed())
-		Eventually(func() error {
+		Eventually(func() bool {
 			f := &corev1.Secret{}
-			k8sClient.Get(context.Background(), key, f)
-			return k8sClient.Delete(context.Background(), f)
-		}, timeout, interval).Should(Succeed())
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
 	})
 
 	It("Should handle pem including a chain correctly", func() {
-		spec := keyhubv1alpha1.KeyHubSecretSpec{
-			Template: keyhubv1alpha1.SecretTemplate{
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
 				Type: corev1.SecretTypeTLS,
 			},
-			Data: []keyhubv1alpha1.SecretKeyReference{
+			Data: []keyhubv1beta1.SecretKeyReference{
 				{Name: "pem", Record: "00000000-0000-0000-1001-000000000007"},
 			},
 		}
@@ -310,7 +316,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			Namespace: "default",
 		}
 
-		toCreate := &keyhubv1alpha1.KeyHubSecret{
+		toCreate := &keyhubv1beta1.KeyHubSecret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "sample-ks",
 				Namespace: "default",
@@ -336,13 +342,13 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 			return fetched.Type == corev1.SecretTypeTLS &&
 				len(fetched.Data) == 2 &&
-				crtHash == "7827b5dc11728f92186350064bf28208a503dc0c4f14806d33eae1a659b0dee0" &&
+				crtHash == "3240e3a5051e3089bdd4576be16d5aa881ee8776d7d1c13a7e28b0ce7e29b9a3" &&
 				keyHash == "9f76ad4473255e2220c0bbb64a20f1ceec58565ff2f17728fe7bb2f057fe8dcb"
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
 		By("By checking the KeyHubSecret status")
-		fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 		Eventually(func() bool {
 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 			manifestToLog = fetchedKeyHubSecret
@@ -359,25 +365,24 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
-		By("Deleting the KeyHubSecret and Secret")
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		Eventually(func() error {
-			f := &keyhubv1alpha1.KeyHubSecret{}
+			f := &keyhubv1beta1.KeyHubSecret{}
 			k8sClient.Get(context.Background(), key, f)
 			return k8sClient.Delete(context.Background(), f)
 		}, timeout, interval).Should(Succeed())
-		Eventually(func() error {
+		Eventually(func() bool {
 			f := &corev1.Secret{}
-			k8sClient.Get(context.Background(), key, f)
-			return k8sClient.Delete(context.Background(), f)
-		}, timeout, interval).Should(Succeed())
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
 	})
 
 	It("Should handle pkcs12 correctly", func() {
-		spec := keyhubv1alpha1.KeyHubSecretSpec{
-			Template: keyhubv1alpha1.SecretTemplate{
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
 				Type: corev1.SecretTypeTLS,
 			},
-			Data: []keyhubv1alpha1.SecretKeyReference{
+			Data: []keyhubv1beta1.SecretKeyReference{
 				{Name: "pkcs12", Record: "00000000-0000-0000-1001-000000000009"},
 			},
 		}
@@ -387,7 +392,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			Namespace: "default",
 		}
 
-		toCreate := &keyhubv1alpha1.KeyHubSecret{
+		toCreate := &keyhubv1beta1.KeyHubSecret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "sample-ks",
 				Namespace: "default",
@@ -413,13 +418,13 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 			return fetched.Type == corev1.SecretTypeTLS &&
 				len(fetched.Data) == 2 &&
-				crtHash == "9fdb3c05d7153487ab85efb5630acfaf528c456cf823fcc5fd04a79e7d6ed12e" &&
+				crtHash == "4521ec1cc9ba2bb3c60bfd782130cf00ea466a34d8d6e71727b81efb889c8788" &&
 				keyHash == "1cee0f3daa68714795d98cacb98e68b65a27f4701cc0196727dba59ea582bf98"
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
 		By("By checking the KeyHubSecret status")
-		fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 		Eventually(func() bool {
 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 			manifestToLog = fetchedKeyHubSecret
@@ -436,25 +441,24 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
-		By("Deleting the KeyHubSecret and Secret")
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		Eventually(func() error {
-			f := &keyhubv1alpha1.KeyHubSecret{}
+			f := &keyhubv1beta1.KeyHubSecret{}
 			k8sClient.Get(context.Background(), key, f)
 			return k8sClient.Delete(context.Background(), f)
 		}, timeout, interval).Should(Succeed())
-		Eventually(func() error {
+		Eventually(func() bool {
 			f := &corev1.Secret{}
-			k8sClient.Get(context.Background(), key, f)
-			return k8sClient.Delete(context.Background(), f)
-		}, timeout, interval).Should(Succeed())
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
 	})
 
 	It("Should handle pkcs12 with ca certs correctly", func() {
-		spec := keyhubv1alpha1.KeyHubSecretSpec{
-			Template: keyhubv1alpha1.SecretTemplate{
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
 				Type: corev1.SecretTypeTLS,
 			},
-			Data: []keyhubv1alpha1.SecretKeyReference{
+			Data: []keyhubv1beta1.SecretKeyReference{
 				{Name: "pkcs12", Record: "00000000-0000-0000-1001-000000000010"},
 			},
 		}
@@ -464,7 +468,7 @@ var _ = Describe("KeyHubSecret Controller", func() {
 			Namespace: "default",
 		}
 
-		toCreate := &keyhubv1alpha1.KeyHubSecret{
+		toCreate := &keyhubv1beta1.KeyHubSecret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "sample-ks",
 				Namespace: "default",
@@ -490,13 +494,13 @@ var _ = Describe("KeyHubSecret Controller", func() {
 
 			return fetched.Type == corev1.SecretTypeTLS &&
 				len(fetched.Data) == 2 &&
-				crtHash == "f9fd4f2ffbeb19bf2c59bdaa257915e8f8af03d3f4c9ce1700ef45dc96139784" &&
+				crtHash == "26175370f3c3f38c6125f7a1aee848e251b1d271cd2f5477d817243c434d8934" &&
 				keyHash == "1cee0f3daa68714795d98cacb98e68b65a27f4701cc0196727dba59ea582bf98"
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
 		By("By checking the KeyHubSecret status")
-		fetchedKeyHubSecret := &keyhubv1alpha1.KeyHubSecret{}
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
 		Eventually(func() bool {
 			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
 			manifestToLog = fetchedKeyHubSecret
@@ -513,17 +517,666 @@ var _ = Describe("KeyHubSecret Controller", func() {
 		}, timeout, interval).Should(BeTrue())
 		manifestToLog = nil
 
-		By("Deleting the KeyHubSecret and Secret")
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		Eventually(func() error {
-			f := &keyhubv1alpha1.KeyHubSecret{}
+			f := &keyhubv1beta1.KeyHubSecret{}
 			k8sClient.Get(context.Background(), key, f)
 			return k8sClient.Delete(context.Background(), f)
 		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			f := &corev1.Secret{}
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should handle seperate records with an ECDSA key correctly", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000011"},
+				{Name: "tls.key", Record: "00000000-0000-0000-1001-000000000012"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the Secret is created correctly")
+		fetched := &corev1.Secret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetched)
+			manifestToLog = fetched
+
+			h := sha256.New()
+			h.Write(fetched.Data[corev1.TLSCertKey])
+			crtHash := fmt.Sprintf("%x", h.Sum(nil))
+			h.Reset()
+			h.Write(fetched.Data[corev1.TLSPrivateKeyKey])
+			keyHash := fmt.Sprintf("%x", h.Sum(nil))
+			keyBlock, _ := pem.Decode(fetched.Data[corev1.TLSPrivateKeyKey])
+
+			return fetched.Type == corev1.SecretTypeTLS &&
+				len(fetched.Data) == 2 &&
+				keyBlock != nil &&
+				keyBlock.Type == "EC PRIVATE KEY" &&
+				fetched.Annotations["field.cattle.io/algorithm"] == "ECDSA" &&
+				fetched.Annotations["field.cattle.io/keySize"] == "256" &&
+				fetched.Annotations["field.cattle.io/cn"] == "ecdsa.example.io" &&
+				fetched.Annotations["field.cattle.io/expiresAt"] == "2054-01-01T00:00:00Z" &&
+				crtHash == "0b472567422d85e8350fe31ac3554f56bd881f10f3fef59c5b89e7cb44c5d50d" &&
+				keyHash == "3eed76a1beea2c5f988a95aaaca69f8cd5ab05dd81b40d032ed8ef0e21847acf"
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			records := fetchedKeyHubSecret.Status.VaultRecordStatuses
+			keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
+
+			return len(records) == 2 &&
+				len(keys) == 2 &&
+				keys[0].Key == corev1.TLSCertKey &&
+				keys[1].Key == corev1.TLSPrivateKeyKey
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			f := &corev1.Secret{}
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should handle pem with a PKCS#8 ECDSA key correctly", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "pem", Record: "00000000-0000-0000-1001-000000000013"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the Secret is created correctly")
+		fetched := &corev1.Secret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetched)
+			manifestToLog = fetched
+
+			h := sha256.New()
+			h.Write(fetched.Data[corev1.TLSCertKey])
+			crtHash := fmt.Sprintf("%x", h.Sum(nil))
+			h.Reset()
+			h.Write(fetched.Data[corev1.TLSPrivateKeyKey])
+			keyHash := fmt.Sprintf("%x", h.Sum(nil))
+			keyBlock, _ := pem.Decode(fetched.Data[corev1.TLSPrivateKeyKey])
+
+			return fetched.Type == corev1.SecretTypeTLS &&
+				len(fetched.Data) == 2 &&
+				keyBlock != nil &&
+				keyBlock.Type == "EC PRIVATE KEY" &&
+				fetched.Annotations["field.cattle.io/algorithm"] == "ECDSA" &&
+				fetched.Annotations["field.cattle.io/keySize"] == "384" &&
+				fetched.Annotations["field.cattle.io/cn"] == "ecdsa-pkcs8.example.io" &&
+				fetched.Annotations["field.cattle.io/expiresAt"] == "2054-01-01T00:00:00Z" &&
+				crtHash == "ece3aa0f00e4391ef35c0d2a95fa0186ec5e5c2dd1965fcdb281ccf865ac2b66" &&
+				keyHash == "338579ad3470d99efba60ee4ad2a7f8c198362ba6a49388d8a3af5c91a5c9bfc"
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			records := fetchedKeyHubSecret.Status.VaultRecordStatuses
+			keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
+
+			return len(records) == 1 &&
+				len(keys) == 2 &&
+				keys[0].Key == corev1.TLSCertKey &&
+				keys[1].Key == corev1.TLSPrivateKeyKey
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
 		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
 			f := &corev1.Secret{}
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should handle pem with an Ed25519 key correctly", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "pem", Record: "00000000-0000-0000-1001-000000000014"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the Secret is created correctly")
+		fetched := &corev1.Secret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetched)
+			manifestToLog = fetched
+
+			h := sha256.New()
+			h.Write(fetched.Data[corev1.TLSCertKey])
+			crtHash := fmt.Sprintf("%x", h.Sum(nil))
+			h.Reset()
+			h.Write(fetched.Data[corev1.TLSPrivateKeyKey])
+			keyHash := fmt.Sprintf("%x", h.Sum(nil))
+			keyBlock, _ := pem.Decode(fetched.Data[corev1.TLSPrivateKeyKey])
+
+			return fetched.Type == corev1.SecretTypeTLS &&
+				len(fetched.Data) == 2 &&
+				keyBlock != nil &&
+				keyBlock.Type == "PRIVATE KEY" &&
+				fetched.Annotations["field.cattle.io/algorithm"] == "Ed25519" &&
+				fetched.Annotations["field.cattle.io/keySize"] == "256" &&
+				fetched.Annotations["field.cattle.io/cn"] == "ed25519.example.io" &&
+				fetched.Annotations["field.cattle.io/expiresAt"] == "2054-01-01T00:00:00Z" &&
+				crtHash == "680f485c05d6ddf6650fa9acc8f53742a02367a96e9c3ca2e85b798e0f426362" &&
+				keyHash == "2cfebbaae44907f49c5bf502cc8d9b4cc576eeb2c897cee85bf2c0f27f3238b9"
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			records := fetchedKeyHubSecret.Status.VaultRecordStatuses
+			keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
+
+			return len(records) == 1 &&
+				len(keys) == 2 &&
+				keys[0].Key == corev1.TLSCertKey &&
+				keys[1].Key == corev1.TLSPrivateKeyKey
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
 			k8sClient.Get(context.Background(), key, f)
 			return k8sClient.Delete(context.Background(), f)
 		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			f := &corev1.Secret{}
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should handle pkcs12 with an ECDSA key correctly", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "pkcs12", Record: "00000000-0000-0000-1001-000000000015"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the Secret is created correctly")
+		fetched := &corev1.Secret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetched)
+			manifestToLog = fetched
+
+			h := sha256.New()
+			h.Write(fetched.Data[corev1.TLSCertKey])
+			crtHash := fmt.Sprintf("%x", h.Sum(nil))
+			h.Reset()
+			h.Write(fetched.Data[corev1.TLSPrivateKeyKey])
+			keyHash := fmt.Sprintf("%x", h.Sum(nil))
+			keyBlock, _ := pem.Decode(fetched.Data[corev1.TLSPrivateKeyKey])
+
+			return fetched.Type == corev1.SecretTypeTLS &&
+				len(fetched.Data) == 2 &&
+				keyBlock != nil &&
+				keyBlock.Type == "EC PRIVATE KEY" &&
+				fetched.Annotations["field.cattle.io/algorithm"] == "ECDSA" &&
+				fetched.Annotations["field.cattle.io/keySize"] == "256" &&
+				fetched.Annotations["field.cattle.io/cn"] == "ecdsa.example.io" &&
+				fetched.Annotations["field.cattle.io/expiresAt"] == "2054-01-01T00:00:00Z" &&
+				crtHash == "0b472567422d85e8350fe31ac3554f56bd881f10f3fef59c5b89e7cb44c5d50d" &&
+				keyHash == "3eed76a1beea2c5f988a95aaaca69f8cd5ab05dd81b40d032ed8ef0e21847acf"
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			records := fetchedKeyHubSecret.Status.VaultRecordStatuses
+			keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
+
+			return len(records) == 1 &&
+				len(keys) == 2 &&
+				keys[0].Key == corev1.TLSCertKey &&
+				keys[1].Key == corev1.TLSPrivateKeyKey
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			f := &corev1.Secret{}
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should handle pkcs12 with an Ed25519 key correctly", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "pkcs12", Record: "00000000-0000-0000-1001-000000000016"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the Secret is created correctly")
+		fetched := &corev1.Secret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetched)
+			manifestToLog = fetched
+
+			h := sha256.New()
+			h.Write(fetched.Data[corev1.TLSCertKey])
+			crtHash := fmt.Sprintf("%x", h.Sum(nil))
+			h.Reset()
+			h.Write(fetched.Data[corev1.TLSPrivateKeyKey])
+			keyHash := fmt.Sprintf("%x", h.Sum(nil))
+			keyBlock, _ := pem.Decode(fetched.Data[corev1.TLSPrivateKeyKey])
+
+			return fetched.Type == corev1.SecretTypeTLS &&
+				len(fetched.Data) == 2 &&
+				keyBlock != nil &&
+				keyBlock.Type == "PRIVATE KEY" &&
+				fetched.Annotations["field.cattle.io/algorithm"] == "Ed25519" &&
+				fetched.Annotations["field.cattle.io/keySize"] == "256" &&
+				fetched.Annotations["field.cattle.io/cn"] == "ed25519.example.io" &&
+				fetched.Annotations["field.cattle.io/expiresAt"] == "2054-01-01T00:00:00Z" &&
+				crtHash == "680f485c05d6ddf6650fa9acc8f53742a02367a96e9c3ca2e85b798e0f426362" &&
+				keyHash == "2cfebbaae44907f49c5bf502cc8d9b4cc576eeb2c897cee85bf2c0f27f3238b9"
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			records := fetchedKeyHubSecret.Status.VaultRecordStatuses
+			keys := fetchedKeyHubSecret.Status.SecretKeyStatuses
+
+			return len(records) == 1 &&
+				len(keys) == 2 &&
+				keys[0].Key == corev1.TLSCertKey &&
+				keys[1].Key == corev1.TLSPrivateKeyKey
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			f := &corev1.Secret{}
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
+	})
+
+	It("Should reject a certificate not matching the private key", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000011"},
+				{Name: "tls.key", Record: "00000000-0000-0000-1001-000000000004"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			condition := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeCertificateValid))
+
+			return meta.IsStatusConditionFalse(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced)) &&
+				len(fetchedKeyHubSecret.Status.VaultRecordStatuses) == 0 &&
+				condition != nil &&
+				condition.Status == metav1.ConditionFalse &&
+				condition.Reason == string(keyhubv1beta1.CertificateKeyMismatch)
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the Secret is not created")
+		err := k8sClient.Get(context.Background(), key, &corev1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("Deleting the KeyHubSecret")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("Should reject ca certs not signing the certificate", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000017"},
+				{Name: "tls.key", Record: "00000000-0000-0000-1001-000000000004"},
+				{Name: "ca.crt", Record: "00000000-0000-0000-1001-000000000018"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			condition := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeCertificateValid))
+
+			return meta.IsStatusConditionFalse(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced)) &&
+				len(fetchedKeyHubSecret.Status.VaultRecordStatuses) == 0 &&
+				condition != nil &&
+				condition.Status == metav1.ConditionFalse &&
+				condition.Reason == string(keyhubv1beta1.CertificateChainInvalid)
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the Secret is not created")
+		err := k8sClient.Get(context.Background(), key, &corev1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("Deleting the KeyHubSecret")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("Should reject an expired certificate", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "pkcs12", Record: "00000000-0000-0000-1001-000000000019"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			condition := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeCertificateValid))
+
+			return meta.IsStatusConditionFalse(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeSynced)) &&
+				len(fetchedKeyHubSecret.Status.VaultRecordStatuses) == 0 &&
+				condition != nil &&
+				condition.Status == metav1.ConditionFalse &&
+				condition.Reason == string(keyhubv1beta1.CertificateExpired)
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the Secret is not created")
+		err := k8sClient.Get(context.Background(), key, &corev1.Secret{})
+		Expect(errors.IsNotFound(err)).To(BeTrue())
+
+		By("Deleting the KeyHubSecret")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+	})
+
+	It("Should track the certificate expiry", func() {
+		spec := keyhubv1beta1.KeyHubSecretSpec{
+			Template: keyhubv1beta1.SecretTemplate{
+				Type: corev1.SecretTypeTLS,
+			},
+			Data: []keyhubv1beta1.SecretKeyReference{
+				{Name: "tls.crt", Record: "00000000-0000-0000-1001-000000000003"},
+				{Name: "tls.key", Record: "00000000-0000-0000-1001-000000000004"},
+			},
+		}
+
+		key := types.NamespacedName{
+			Name:      "sample-ks",
+			Namespace: "default",
+		}
+
+		toCreate := &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "sample-ks",
+				Namespace: "default",
+			},
+			Spec: spec,
+		}
+
+		By("By creating a new KeyHubSecret")
+		Expect(k8sClient.Create(context.Background(), toCreate)).Should(Succeed())
+
+		By("By checking the KeyHubSecret status")
+		fetchedKeyHubSecret := &keyhubv1beta1.KeyHubSecret{}
+		Eventually(func() bool {
+			k8sClient.Get(context.Background(), key, fetchedKeyHubSecret)
+			manifestToLog = fetchedKeyHubSecret
+
+			cert := fetchedKeyHubSecret.Status.Certificate
+			condition := meta.FindStatusCondition(fetchedKeyHubSecret.Status.Conditions, string(keyhubv1beta1.TypeCertificateExpiring))
+
+			return cert != nil &&
+				cert.RecordID == "00000000-0000-0000-1001-000000000003" &&
+				cert.CommonName == "example.io" &&
+				cert.NotAfter.UTC().Format(time.RFC3339) == "2031-03-24T07:19:37Z" &&
+				condition != nil &&
+				condition.Status == metav1.ConditionFalse &&
+				condition.Reason == string(keyhubv1beta1.CertificateNotExpiring)
+		}, timeout, interval).Should(BeTrue())
+		manifestToLog = nil
+
+		By("By checking the certificate expiry metric")
+		var expiry float64
+		collectedMetrics, _ := metrics.Registry.Gather()
+		for _, metricFamily := range collectedMetrics {
+			if "keyhub_certificate_expiry_timestamp_seconds" == *metricFamily.Name {
+				for _, metric := range metricFamily.GetMetric() {
+					labels := make(map[string]string)
+					for _, label := range metric.GetLabel() {
+						labels[label.GetName()] = label.GetValue()
+					}
+					if labels["namespace"] == "default" &&
+						labels["keyhubsecret"] == "sample-ks" &&
+						labels["record"] == "00000000-0000-0000-1001-000000000003" &&
+						labels["cn"] == "example.io" {
+						expiry = metric.GetGauge().GetValue()
+					}
+				}
+			}
+		}
+		Expect(expiry).To(Equal(1932103177.0))
+
+		By("Deleting the KeyHubSecret and checking the Secret is deleted")
+		Eventually(func() error {
+			f := &keyhubv1beta1.KeyHubSecret{}
+			k8sClient.Get(context.Background(), key, f)
+			return k8sClient.Delete(context.Background(), f)
+		}, timeout, interval).Should(Succeed())
+		Eventually(func() bool {
+			f := &corev1.Secret{}
+			return errors.IsNotFound(k8sClient.Get(context.Background(), key, f))
+		}, timeout, interval).Should(BeTrue())
 	})
 
 })
@@ -0,0 +1,173 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/secret"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
+)
+
+const (
+	// vaultRecordField indexes the KeyHubSecrets by the UUIDs of the records
+	// in spec.data, and of the synced records in the status, which includes
+	// the records resolved by recordRef and imported by dataFrom
+	vaultRecordField = ".spec.data.record"
+	// vaultGroupField indexes the KeyHubSecrets by the groups of
+	// spec.data[].recordRef and spec.dataFrom, new records in these groups
+	// may have to be synced
+	vaultGroupField = ".spec.dataFrom.group"
+	// mergedSecretField indexes the KeyHubSecrets with the Merge creation
+	// policy by the name of their target Secret, which they don't own
+	mergedSecretField = ".spec.target.name"
+)
+
+// setupFieldIndexes registers the indexes used to find the KeyHubSecrets
+// affected by a change in a vault
+func setupFieldIndexes(ctx context.Context, mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &keyhubv1beta1.KeyHubSecret{}, vaultRecordField, indexVaultRecords); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &keyhubv1beta1.KeyHubSecret{}, vaultGroupField, indexVaultGroups); err != nil {
+		return err
+	}
+	return mgr.GetFieldIndexer().IndexField(ctx, &keyhubv1beta1.KeyHubSecret{}, mergedSecretField, indexMergedSecret)
+}
+
+func indexVaultRecords(obj client.Object) []string {
+	ks := obj.(*keyhubv1beta1.KeyHubSecret)
+	uuids := make(map[string]struct{})
+	for _, ref := range ks.Spec.Data {
+		if ref.Record != "" {
+			uuids[ref.Record] = struct{}{}
+		}
+	}
+	for _, status := range ks.Status.VaultRecordStatuses {
+		uuids[status.RecordID] = struct{}{}
+	}
+	return sortedKeys(uuids)
+}
+
+func indexVaultGroups(obj client.Object) []string {
+	ks := obj.(*keyhubv1beta1.KeyHubSecret)
+	groups := make(map[string]struct{})
+	for _, ref := range ks.Spec.Data {
+		if ref.RecordRef != nil {
+			groups[ref.RecordRef.Group] = struct{}{}
+		}
+	}
+	for _, imp := range ks.Spec.DataFrom {
+		groups[imp.Group] = struct{}{}
+	}
+	return sortedKeys(groups)
+}
+
+func indexMergedSecret(obj client.Object) []string {
+	ks := obj.(*keyhubv1beta1.KeyHubSecret)
+	if ks.Spec.Target.CreationPolicy != keyhubv1beta1.CreationPolicyMerge {
+		return nil
+	}
+	return []string{secret.TargetName(ks)}
+}
+
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// keyHubSecretsForDiff returns the KeyHubSecrets referencing a changed record,
+// or a group containing one, by UUID or name
+func (r *KeyHubSecretReconciler) keyHubSecretsForDiff(ctx context.Context, diff *vault.VaultIndexDiff) ([]types.NamespacedName, error) {
+	lookups := make(map[string]map[string]struct{})
+	lookups[vaultRecordField] = make(map[string]struct{})
+	lookups[vaultGroupField] = make(map[string]struct{})
+	for _, records := range [][]vault.VaultRecordWithGroup{diff.Added, diff.Removed, diff.Modified} {
+		for _, idxEntry := range records {
+			lookups[vaultRecordField][idxEntry.Record.UUID] = struct{}{}
+			lookups[vaultGroupField][idxEntry.Group.UUID] = struct{}{}
+			lookups[vaultGroupField][idxEntry.Group.Name] = struct{}{}
+		}
+	}
+
+	found := make(map[types.NamespacedName]struct{})
+	for field, values := range lookups {
+		for value := range values {
+			list := &keyhubv1beta1.KeyHubSecretList{}
+			if err := r.List(ctx, list, client.MatchingFields{field: value}); err != nil {
+				return nil, err
+			}
+			for _, ks := range list.Items {
+				found[types.NamespacedName{Namespace: ks.Namespace, Name: ks.Name}] = struct{}{}
+			}
+		}
+	}
+
+	result := make([]types.NamespacedName, 0, len(found))
+	for key := range found {
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].String() < result[j].String() })
+	return result, nil
+}
+
+// requestsForMergedSecret enqueues the KeyHubSecrets merging their keys into
+// the Secret, so drift of the merged keys is corrected right away
+func (r *KeyHubSecretReconciler) requestsForMergedSecret(obj client.Object) []reconcile.Request {
+	list := &keyhubv1beta1.KeyHubSecretList{}
+	if err := r.List(context.Background(), list, client.InNamespace(obj.GetNamespace()), client.MatchingFields{mergedSecretField: obj.GetName()}); err != nil {
+		r.Log.Error(err, "Failed to list the KeyHubSecrets merging into Secret", "secret", client.ObjectKeyFromObject(obj).String())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(list.Items))
+	for _, ks := range list.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ks)})
+	}
+	return requests
+}
+
+// consumeVaultChanges enqueues the KeyHubSecrets affected by the diffs of the
+// vault index refreshes, in order, until ctx is done
+func (r *KeyHubSecretReconciler) consumeVaultChanges(ctx context.Context, diffs <-chan *vault.VaultIndexDiff, events chan<- event.GenericEvent) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case diff := <-diffs:
+			r.enqueueVaultChanges(diff, events)
+		}
+	}
+}
+
+// enqueueVaultChanges sends an event for every KeyHubSecret affected by diff
+func (r *KeyHubSecretReconciler) enqueueVaultChanges(diff *vault.VaultIndexDiff, events chan<- event.GenericEvent) {
+	keys, err := r.keyHubSecretsForDiff(context.Background(), diff)
+	if err != nil {
+		r.Log.Error(err, "Failed to find the KeyHubSecrets of the changed vault records", "client", diff.ClientID)
+		return
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	r.Log.Info("Syncing KeyHubSecrets of changed vault records", "client", diff.ClientID, "keyhubsecrets", len(keys))
+	for _, key := range keys {
+		ks := &keyhubv1beta1.KeyHubSecret{}
+		ks.Namespace, ks.Name = key.Namespace, key.Name
+		events <- event.GenericEvent{Object: ks}
+	}
+}
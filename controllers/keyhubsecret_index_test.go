@@ -0,0 +1,184 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+func vaultRecordWithGroup(groupUUID string, groupName string, recordUUID string) vault.VaultRecordWithGroup {
+	idxEntry := vault.VaultRecordWithGroup{}
+	idxEntry.Group.UUID = groupUUID
+	idxEntry.Group.Name = groupName
+	idxEntry.Record = keyhubmodel.VaultRecord{}
+	idxEntry.Record.UUID = recordUUID
+	return idxEntry
+}
+
+var _ = Describe("KeyHubSecret Controller", func() {
+
+	const timeout = time.Second * 10
+	const interval = time.Second * 1
+
+	BeforeEach(func() {
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+	})
+
+	Context("Vault changes", func() {
+		r := &KeyHubSecretReconciler{
+			Log: ctrl.Log.WithName("controllers").WithName("KeyHubSecret"),
+		}
+
+		It("Should find the KeyHubSecrets of the changed records", func() {
+			r.Client = k8sClient
+
+			recordKey := types.NamespacedName{Name: "record-ks", Namespace: "default"}
+			importKey := types.NamespacedName{Name: "import-ks", Namespace: "default"}
+
+			By("By creating a KeyHubSecret referencing a record")
+			Expect(k8sClient.Create(context.Background(), &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: recordKey.Name, Namespace: recordKey.Namespace},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			})).Should(Succeed())
+
+			By("By creating a KeyHubSecret importing a group vault")
+			Expect(k8sClient.Create(context.Background(), &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: importKey.Name, Namespace: importKey.Namespace},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					DataFrom: []keyhubv1beta1.VaultImport{
+						{Group: "Group 1001", NamePattern: "Not imported", Property: "password"},
+					},
+				},
+			})).Should(Succeed())
+
+			By("By checking a modified record finds the KeyHubSecret referencing it")
+			modified := &vault.VaultIndexDiff{
+				ClientID: "CLIENT-0001",
+				Modified: []vault.VaultRecordWithGroup{
+					vaultRecordWithGroup("00000000-0000-0000-0000-000000000000", "Other", "00000000-0000-0000-1001-000000000002"),
+				},
+			}
+			Eventually(func() []types.NamespacedName {
+				keys, err := r.keyHubSecretsForDiff(context.Background(), modified)
+				Expect(err).To(BeNil())
+				return keys
+			}, timeout, interval).Should(Equal([]types.NamespacedName{recordKey}))
+
+			By("By checking an added record finds the KeyHubSecret importing its group")
+			added := &vault.VaultIndexDiff{
+				ClientID: "CLIENT-0001",
+				Added: []vault.VaultRecordWithGroup{
+					vaultRecordWithGroup("00000000-0000-0000-0000-000000000000", "Group 1001", "00000000-0000-0000-1001-999999999999"),
+				},
+			}
+			events := make(chan event.GenericEvent, 10)
+			r.enqueueVaultChanges(added, events)
+			Expect(events).To(HaveLen(1))
+			ev := <-events
+			Expect(ev.Object.GetNamespace()).To(Equal(importKey.Namespace))
+			Expect(ev.Object.GetName()).To(Equal(importKey.Name))
+
+			By("By checking an unrelated record finds no KeyHubSecrets")
+			unrelated := &vault.VaultIndexDiff{
+				ClientID: "CLIENT-0001",
+				Removed: []vault.VaultRecordWithGroup{
+					vaultRecordWithGroup("00000000-0000-0000-0000-000000000000", "Other", "00000000-0000-0000-9999-000000000001"),
+				},
+			}
+			Expect(r.keyHubSecretsForDiff(context.Background(), unrelated)).To(BeEmpty())
+		})
+
+		It("Should handle the diffs with a single consumer", func() {
+			r.Client = k8sClient
+
+			recordKey := types.NamespacedName{Name: "record-ks", Namespace: "default"}
+			Expect(k8sClient.Create(context.Background(), &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: recordKey.Name, Namespace: recordKey.Namespace},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			})).Should(Succeed())
+			modified := &vault.VaultIndexDiff{
+				ClientID: "CLIENT-0001",
+				Modified: []vault.VaultRecordWithGroup{
+					vaultRecordWithGroup("00000000-0000-0000-0000-000000000000", "Other", "00000000-0000-0000-1001-000000000002"),
+				},
+			}
+			Eventually(func() []types.NamespacedName {
+				keys, _ := r.keyHubSecretsForDiff(context.Background(), modified)
+				return keys
+			}, timeout, interval).Should(HaveLen(1))
+
+			diffs := make(chan *vault.VaultIndexDiff, 2)
+			events := make(chan event.GenericEvent, 10)
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error)
+			go func() { done <- r.consumeVaultChanges(ctx, diffs, events) }()
+
+			diffs <- modified
+			diffs <- modified
+			Eventually(func() int { return len(events) }, timeout, interval).Should(Equal(2))
+
+			cancel()
+			Eventually(done, timeout).Should(Receive(BeNil()))
+		})
+
+		It("Should find the KeyHubSecrets merging into a Secret", func() {
+			r.Client = k8sClient
+
+			mergeKey := types.NamespacedName{Name: "merge-ks", Namespace: "default"}
+			Expect(k8sClient.Create(context.Background(), &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: mergeKey.Name, Namespace: mergeKey.Namespace},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Target: keyhubv1beta1.SecretTarget{Name: "shared-secret", CreationPolicy: keyhubv1beta1.CreationPolicyMerge},
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			})).Should(Succeed())
+
+			By("By creating a KeyHubSecret owning a Secret of the same name")
+			Expect(k8sClient.Create(context.Background(), &keyhubv1beta1.KeyHubSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "shared-secret", Namespace: "default"},
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
+						{Name: "username", Record: "00000000-0000-0000-1001-000000000002", Property: "username"},
+					},
+				},
+			})).Should(Succeed())
+
+			shared := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared-secret", Namespace: "default"}}
+			Eventually(func() []reconcile.Request {
+				return r.requestsForMergedSecret(shared)
+			}, timeout, interval).Should(Equal([]reconcile.Request{{NamespacedName: mergeKey}}))
+
+			other := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared-secret", Namespace: "other"}}
+			Expect(r.requestsForMergedSecret(other)).To(BeEmpty())
+		})
+	})
+})
@@ -4,17 +4,34 @@
 package metrics
 
 import (
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var (
 	KeyHubApiRequests = createKeyHubApiRequestTotal()
+	CertificateExpiry = createCertificateExpiryTimestamp()
+	VaultIndexAge     = &vaultIndexAgeCollector{
+		desc: prometheus.NewDesc(
+			"keyhub_vault_index_age_seconds",
+			"Time since the vault index of a KeyHub client was last refreshed",
+			[]string{"client"}, nil,
+		),
+	}
+	// VaultRecordCacheRequests counts the lookups of the vault record cache
+	// by result, hit or miss
+	VaultRecordCacheRequests = createVaultRecordCacheRequestTotal()
 )
 
 func init() {
 	// Register custom metrics with the global prometheus registry
 	metrics.Registry.MustRegister(KeyHubApiRequests)
+	metrics.Registry.MustRegister(CertificateExpiry)
+	metrics.Registry.MustRegister(VaultRecordCacheRequests)
+	metrics.Registry.MustRegister(VaultIndexAge)
 }
 
 func createKeyHubApiRequestTotal() *prometheus.CounterVec {
@@ -29,7 +46,66 @@ func createKeyHubApiRequestTotal() *prometheus.CounterVec {
 	)
 }
 
+func createCertificateExpiryTimestamp() *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "keyhub",
+			Subsystem: "certificate",
+			Name:      "expiry_timestamp_seconds",
+			Help:      "Expiry time of the synced TLS certificate in seconds since epoch",
+		},
+		[]string{"namespace", "keyhubsecret", "record", "cn"},
+	)
+}
+
+func createVaultRecordCacheRequestTotal() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "keyhub",
+			Subsystem: "vault_record_cache",
+			Name:      "requests_total",
+			Help:      "Number of lookups of vault records with their secrets in the cache",
+		},
+		[]string{"result"},
+	)
+}
+
 // Reset all metrics during tests
 func Reset() {
 	KeyHubApiRequests.Reset()
+	CertificateExpiry.Reset()
+	VaultRecordCacheRequests.Reset()
+}
+
+// vaultIndexAgeCollector reads the age of the vault indexes on every scrape,
+// so the age keeps growing while a refresh fails
+type vaultIndexAgeCollector struct {
+	desc   *prometheus.Desc
+	mutex  sync.Mutex
+	source func() map[string]time.Duration
+}
+
+// SetVaultIndexAgeSource sets the function returning the age of the vault
+// index per client ID
+func SetVaultIndexAgeSource(source func() map[string]time.Duration) {
+	VaultIndexAge.mutex.Lock()
+	defer VaultIndexAge.mutex.Unlock()
+	VaultIndexAge.source = source
+}
+
+func (c *vaultIndexAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *vaultIndexAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	source := c.source
+	c.mutex.Unlock()
+	if source == nil {
+		return
+	}
+
+	for clientID, age := range source() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, age.Seconds(), clientID)
+	}
 }
@@ -9,7 +9,7 @@ import (
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/policy"
 	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
 	corev1 "k8s.io/api/core/v1"
@@ -49,13 +49,13 @@ var _ = Describe("Namespace Policy Resolver", func() {
 
 	Context("No policy match", func() {
 		It("Should not find a policy match", func() {
-			ks := &keyhubv1alpha1.KeyHubSecret{
+			ks := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
 				},
-				Spec: keyhubv1alpha1.KeyHubSecretSpec{
-					Data: []keyhubv1alpha1.SecretKeyReference{
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
 						{Name: "username", Record: "1001-0002", Property: "username"},
 					},
 				},
@@ -77,13 +77,13 @@ var _ = Describe("Namespace Policy Resolver", func() {
 
 	Context("Name Match", func() {
 		It("Should match the policy by namespace", func() {
-			ks := &keyhubv1alpha1.KeyHubSecret{
+			ks := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
 				},
-				Spec: keyhubv1alpha1.KeyHubSecretSpec{
-					Data: []keyhubv1alpha1.SecretKeyReference{
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
 						{Name: "username", Record: "1001-0002", Property: "username"},
 					},
 				},
@@ -105,13 +105,13 @@ var _ = Describe("Namespace Policy Resolver", func() {
 
 	Context("Name Regex Match", func() {
 		It("Should match the policy by regular expression", func() {
-			ks := &keyhubv1alpha1.KeyHubSecret{
+			ks := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "default",
 				},
-				Spec: keyhubv1alpha1.KeyHubSecretSpec{
-					Data: []keyhubv1alpha1.SecretKeyReference{
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
 						{Name: "username", Record: "1001-0002", Property: "username"},
 					},
 				},
@@ -161,13 +161,13 @@ var _ = Describe("Namespace Policy Resolver", func() {
 			}
 
 			By("By resolving the policy for a KeyHubSecret in a labeled namespace")
-			ks := &keyhubv1alpha1.KeyHubSecret{
+			ks := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "resolver-test-1-1",
 				},
-				Spec: keyhubv1alpha1.KeyHubSecretSpec{
-					Data: []keyhubv1alpha1.SecretKeyReference{
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
 						{Name: "username", Record: "1001-0002", Property: "username"},
 					},
 				},
@@ -218,13 +218,13 @@ var _ = Describe("Namespace Policy Resolver", func() {
 			}
 
 			By("By resolving the policy for a KeyHubSecret in a labeled namespace")
-			ks := &keyhubv1alpha1.KeyHubSecret{
+			ks := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "resolver-test-2-1",
 				},
-				Spec: keyhubv1alpha1.KeyHubSecretSpec{
-					Data: []keyhubv1alpha1.SecretKeyReference{
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
 						{Name: "username", Record: "1001-0002", Property: "username"},
 					},
 				},
@@ -247,7 +247,7 @@ var _ = Describe("Namespace Policy Resolver", func() {
 			Expect(policy.Credentials.ClientID).To(Equal("0246"))
 		})
 
-		It("Should fail when two policies have the same score", func() {
+		It("Should order policies with the same score by their vault record", func() {
 			By("By creating multiple labeled namespaces")
 			nsl := &corev1.NamespaceList{
 				Items: []corev1.Namespace{
@@ -287,13 +287,13 @@ var _ = Describe("Namespace Policy Resolver", func() {
 			// }
 
 			By("By resolving the policy for a KeyHubSecret in a labeled namespace")
-			ks := &keyhubv1alpha1.KeyHubSecret{
+			ks := &keyhubv1beta1.KeyHubSecret{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      "sample-ks",
 					Namespace: "resolver-test-3-1",
 				},
-				Spec: keyhubv1alpha1.KeyHubSecretSpec{
-					Data: []keyhubv1alpha1.SecretKeyReference{
+				Spec: keyhubv1beta1.KeyHubSecretSpec{
+					Data: []keyhubv1beta1.SecretKeyReference{
 						{Name: "username", Record: "1001-0002", Property: "username"},
 					},
 				},
@@ -303,16 +303,21 @@ var _ = Describe("Namespace Policy Resolver", func() {
 			p1.Type = "namespace"
 			p1.LabelSelector = "project=x3"
 			p1.Credentials = policy.ClientCredentials{ClientID: "1357"}
+			p1.Source = policy.PolicySource{RecordUUID: "00000000-0000-0000-1000-000000000003"}
 			p2 := policy.Policy{}
 			p2.Type = "namespace"
 			p2.LabelSelector = "project=x3"
 			p2.Credentials = policy.ClientCredentials{ClientID: "0246"}
+			p2.Source = policy.PolicySource{RecordUUID: "00000000-0000-0000-1000-000000000002"}
 			policies := []policy.Policy{p1, p2}
 
 			resolver := policy.NewNamespacePolicyResolver(k8sClient, logf.Log.WithName("NamespacePolicyResolver"), policies)
-			policy, err := resolver.Resolve(ks)
-			Expect(err).ToNot(BeNil())
-			Expect(policy).To(BeNil())
+			resolution, err := resolver.ResolveAll(ks)
+			Expect(err).To(BeNil())
+			Expect(resolution.Policy.Credentials.ClientID).To(Equal("0246"))
+			Expect(resolution.Shadowed).To(HaveLen(1))
+			Expect(resolution.Conflicting).To(HaveLen(1))
+			Expect(resolution.Conflicting[0].Credentials.ClientID).To(Equal("1357"))
 		})
 
 	})
@@ -0,0 +1,164 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
+)
+
+const (
+	// PolicyEffectAllow selects the KeyHub client of the matching
+	// KeyHubSecrets, it is the default effect
+	PolicyEffectAllow = "allow"
+	// PolicyEffectDeny denies the matching KeyHubSecrets access to vault
+	// records, regardless of the priority of the allow policies
+	PolicyEffectDeny = "deny"
+)
+
+// ErrRecordDenied is wrapped by the errors of denied records
+var ErrRecordDenied = errors.New("record denied")
+
+// ErrInvalidDenyPolicy is wrapped by the errors of the KeyHubSecrets an invalid
+// deny policy may match
+var ErrInvalidDenyPolicy = errors.New("invalid deny policy")
+
+// RecordRule matches vault records, every field set in the rule must match.
+// A rule without fields matches all records.
+type RecordRule struct {
+	// Group is the name or UUID of the group owning the vault
+	Group string `yaml:"group,omitempty"`
+	UUID  string `yaml:"uuid,omitempty"`
+	// NamePattern is a regular expression the whole record name must match
+	NamePattern string `yaml:"namePattern,omitempty"`
+
+	// nameRegex is NamePattern compiled when the policy is loaded
+	nameRegex *regexp.Regexp
+}
+
+// NewRecordRule returns the rule with its name pattern compiled
+func NewRecordRule(group, uuid, namePattern string) (RecordRule, error) {
+	rule := RecordRule{Group: group, UUID: uuid, NamePattern: namePattern}
+	if err := rule.compile(); err != nil {
+		return RecordRule{}, err
+	}
+	return rule, nil
+}
+
+// compile compiles the name pattern of the rule
+func (rule *RecordRule) compile() error {
+	if rule.NamePattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + rule.NamePattern + ")$")
+	if err != nil {
+		return fmt.Errorf("Invalid regular expression '%s': %w", rule.NamePattern, err)
+	}
+	rule.nameRegex = re
+	return nil
+}
+
+// compileRecordRules compiles the name patterns of rules
+func compileRecordRules(rules []RecordRule) error {
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the record of idxEntry matches the rule
+func (rule RecordRule) matches(idxEntry vault.VaultRecordWithGroup) (bool, error) {
+	if rule.Group != "" && rule.Group != idxEntry.Group.UUID && rule.Group != idxEntry.Group.Name {
+		return false, nil
+	}
+	if rule.UUID != "" && rule.UUID != idxEntry.Record.UUID {
+		return false, nil
+	}
+	if rule.NamePattern != "" {
+		if rule.nameRegex == nil {
+			return false, fmt.Errorf("Regular expression '%s' is not compiled", rule.NamePattern)
+		}
+		return rule.nameRegex.MatchString(idxEntry.Record.Name), nil
+	}
+	return true, nil
+}
+
+// matchesAny reports whether the record of idxEntry matches one of rules
+func matchesAny(rules []RecordRule, idxEntry vault.VaultRecordWithGroup) (bool, error) {
+	for _, rule := range rules {
+		found, err := rule.matches(idxEntry)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// RecordAccess restricts the vault records a KeyHubSecret may reference to
+// the records of the matched policy, without the records of the deny
+// policies
+type RecordAccess struct {
+	allow  *Policy
+	denied []Policy
+}
+
+// RecordAccess returns the record restrictions of the resolved policies
+func (r *Resolution) RecordAccess() *RecordAccess {
+	access := &RecordAccess{denied: r.Denied}
+	if len(r.Policy.Records) > 0 {
+		access.allow = &r.Policy
+	}
+	return access
+}
+
+// Check returns an error when the record of idxEntry may not be referenced.
+// Invalid rules deny access.
+func (a *RecordAccess) Check(idxEntry vault.VaultRecordWithGroup) error {
+	record := fmt.Sprintf("%s (%s in group %s)", idxEntry.Record.UUID, idxEntry.Record.Name, idxEntry.Group.Name)
+
+	for _, policy := range a.denied {
+		found := len(policy.Records) == 0
+		if !found {
+			var err error
+			if found, err = matchesAny(policy.Records, idxEntry); err != nil {
+				return fmt.Errorf("Record %s is denied, policy '%s' is invalid: %s", record, policy.String(), err)
+			}
+		}
+		if found {
+			return fmt.Errorf("Record %s is denied by policy '%s'", record, policy.String())
+		}
+	}
+
+	if a.allow != nil {
+		found, err := matchesAny(a.allow.Records, idxEntry)
+		if err != nil {
+			return fmt.Errorf("Record %s is denied, policy '%s' is invalid: %s", record, a.allow.String(), err)
+		}
+		if !found {
+			return fmt.Errorf("Record %s is not allowed by policy '%s'", record, a.allow.String())
+		}
+	}
+
+	return nil
+}
+
+// Filter returns the records that may be referenced
+func (a *RecordAccess) Filter(records map[string]vault.VaultRecordWithGroup) map[string]vault.VaultRecordWithGroup {
+	if a.allow == nil && len(a.denied) == 0 {
+		return records
+	}
+
+	filtered := make(map[string]vault.VaultRecordWithGroup, len(records))
+	for uuid, idxEntry := range records {
+		if a.Check(idxEntry) == nil {
+			filtered[uuid] = idxEntry
+		}
+	}
+	return filtered
+}
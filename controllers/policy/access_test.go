@@ -0,0 +1,48 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"testing"
+
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
+)
+
+func newTestRecord(name string) vault.VaultRecordWithGroup {
+	group := keyhubmodel.Group{}
+	group.UUID = "uuid-team-a"
+	group.Name = "Team A"
+	return vault.VaultRecordWithGroup{Group: group, Record: keyhubmodel.VaultRecord{UUID: "1001-0001", Name: name}}
+}
+
+func TestNewRecordRuleRejectsInvalidPattern(t *testing.T) {
+	if _, err := NewRecordRule("", "", "prod-("); err == nil {
+		t.Fatal("Expected the invalid name pattern to be rejected")
+	}
+}
+
+func TestRecordRuleMatchesWholeName(t *testing.T) {
+	rule, err := NewRecordRule("Team A", "", "prod-.*|db")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for name, expected := range map[string]bool{"prod-db": true, "db": true, "test-db": false, "dbs": false} {
+		found, err := rule.matches(newTestRecord(name))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if found != expected {
+			t.Errorf("Expected match of %s to be %t", name, expected)
+		}
+	}
+}
+
+func TestRecordRuleWithoutCompiledPatternFails(t *testing.T) {
+	rule := RecordRule{NamePattern: "prod-.*"}
+	if _, err := rule.matches(newTestRecord("prod-db")); err == nil {
+		t.Fatal("Expected the uncompiled name pattern to fail the match")
+	}
+}
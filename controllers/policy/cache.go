@@ -12,7 +12,7 @@ import (
 )
 
 type PolicyCache interface {
-	GetPolicies() ([]Policy, error)
+	GetPolicies() (*VaultPolicies, error)
 	Flush()
 }
 
@@ -32,7 +32,7 @@ func NewPolicyCache(log logr.Logger, loader PolicyLoader) PolicyCache {
 	}
 }
 
-func (pc *policyCache) GetPolicies() ([]Policy, error) {
+func (pc *policyCache) GetPolicies() (*VaultPolicies, error) {
 	policies, found := pc.cache.Get("policies")
 	if !found {
 		pc.mutex.Lock()
@@ -40,15 +40,15 @@ func (pc *policyCache) GetPolicies() ([]Policy, error) {
 
 		policies, found = pc.cache.Get("policies")
 		if !found {
-			policiesPtr, err := pc.loader.Load()
+			loaded, err := pc.loader.Load()
 			if err != nil {
 				return nil, err
 			}
-			policies = *policiesPtr
+			policies = loaded
 			pc.cache.SetDefault("policies", policies)
 		}
 	}
-	return policies.([]Policy), nil
+	return policies.(*VaultPolicies), nil
 }
 
 func (pc *policyCache) Flush() {
@@ -4,6 +4,8 @@
 package policy
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"sync"
@@ -12,13 +14,18 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/patrickmn/go-cache"
 	keyhub "github.com/topicuskeyhub/go-keyhub"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/settings"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type PolicyEngine interface {
-	GetClient(secret *keyhubv1alpha1.KeyHubSecret) (*keyhub.Client, error)
+	GetClient(secret *keyhubv1beta1.KeyHubSecret) (*keyhub.Client, error)
+	// Resolve returns the client of the policy matching secret, together
+	// with all matching policies
+	Resolve(secret *keyhubv1beta1.KeyHubSecret) (*keyhub.Client, *Resolution, error)
+	// LoadKeyHubPolicy validates kp and reads its client credentials
+	LoadKeyHubPolicy(kp *keyhubv1beta1.KeyHubPolicy) (*Policy, error)
 	Flush()
 }
 
@@ -29,11 +36,13 @@ type policyEngine struct {
 	policyCache     PolicyCache
 	clientCache     *cache.Cache
 	mutex           *sync.Mutex
+	// newClient creates the client of credentials, replaced in tests
+	newClient func(credentials ClientCredentials) (*keyhub.Client, error)
 }
 
 func NewPolicyEngine(client client.Client, log logr.Logger, settingsMgr settings.SettingsManager) PolicyEngine {
 	policyLoader := NewPolicyLoader(log, settingsMgr)
-	return &policyEngine{
+	pe := &policyEngine{
 		client:          client,
 		log:             log,
 		settingsManager: settingsMgr,
@@ -41,55 +50,86 @@ func NewPolicyEngine(client client.Client, log logr.Logger, settingsMgr settings
 		clientCache:     cache.New(10*time.Minute, 15*time.Minute),
 		mutex:           &sync.Mutex{},
 	}
+	pe.newClient = pe.newKeyHubClient
+	return pe
 }
 
-func (pe *policyEngine) GetClient(secret *keyhubv1alpha1.KeyHubSecret) (*keyhub.Client, error) {
+func (pe *policyEngine) GetClient(secret *keyhubv1beta1.KeyHubSecret) (*keyhub.Client, error) {
+	client, _, err := pe.Resolve(secret)
+	return client, err
+}
+
+func (pe *policyEngine) Resolve(secret *keyhubv1beta1.KeyHubSecret) (*keyhub.Client, *Resolution, error) {
 	pe.log.Info("Policy based client lookup", "KeyHubSecret", fmt.Sprintf("%s/%s", secret.Namespace, secret.Name))
-	policies, err := pe.policyCache.GetPolicies()
+	policies, err := pe.getPolicies()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	policy, err := pe.match(policies, secret)
+	resolution, err := pe.match(policies, secret)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	policy := resolution.Policy
+
+	client, err := pe.getClient(policy.Credentials)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	clientID := policy.Credentials.ClientID
-	client, found := pe.clientCache.Get(clientID)
+	pe.log.Info("Client with matching policy found", "client", policy.Credentials.ClientID, "policy", policy.String(), "source", policy.Source.String(), "shadowed", len(resolution.Shadowed))
+
+	return client, resolution, nil
+}
+
+// getClient returns the cached client of credentials. The cache is keyed by
+// the client ID and a hash of the client secret, so other credentials for
+// the same client ID never get the authenticated client, and a rotated
+// secret results in a new client. The expiry of a client is extended on
+// every use, so the vault index of a client is kept while it is in use.
+func (pe *policyEngine) getClient(credentials ClientCredentials) (*keyhub.Client, error) {
+	key := clientCacheKey(credentials)
+	client, found := pe.clientCache.Get(key)
 	if !found {
 		pe.mutex.Lock()
 		defer pe.mutex.Unlock()
 
-		client, found = pe.clientCache.Get(clientID)
+		client, found = pe.clientCache.Get(key)
 		if !found {
-			settings, err := pe.settingsManager.GetSettings()
+			var err error
+			client, err = pe.newClient(credentials)
 			if err != nil {
 				return nil, err
 			}
-
-			client, err = keyhub.NewClient(http.DefaultClient, settings.URI, policy.Credentials.ClientID, policy.Credentials.ClientSecret)
-			if err != nil {
-				return nil, err
-			}
-
-			pe.clientCache.SetDefault(clientID, client)
 		}
 	}
 
-	pe.log.Info("Client with matching policy found", "client", clientID)
-
+	pe.clientCache.SetDefault(key, client)
 	return client.(*keyhub.Client), nil
 }
 
-func (pe *policyEngine) match(policies []Policy, secret *keyhubv1alpha1.KeyHubSecret) (*Policy, error) {
-	resolver := NewNamespacePolicyResolver(
+// newKeyHubClient creates a client for credentials
+func (pe *policyEngine) newKeyHubClient(credentials ClientCredentials) (*keyhub.Client, error) {
+	settings, err := pe.settingsManager.GetSettings()
+	if err != nil {
+		return nil, err
+	}
+	return keyhub.NewClient(http.DefaultClient, settings.URI, credentials.ClientID, credentials.ClientSecret)
+}
+
+func clientCacheKey(credentials ClientCredentials) string {
+	digest := sha256.Sum256([]byte(credentials.ClientSecret))
+	return credentials.ClientID + "/" + hex.EncodeToString(digest[:])
+}
+
+func (pe *policyEngine) match(policies []Policy, secret *keyhubv1beta1.KeyHubSecret) (*Resolution, error) {
+	resolver := NewPolicyResolver(
 		pe.client,
-		pe.log.WithName("NamespacePolicyResolver"),
+		pe.log.WithName("PolicyResolver"),
 		policies,
 	)
 
-	return resolver.Resolve(secret)
+	return resolver.ResolveAll(secret)
 }
 
 func (pe *policyEngine) Flush() {
@@ -0,0 +1,64 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/patrickmn/go-cache"
+	keyhub "github.com/topicuskeyhub/go-keyhub"
+)
+
+func newTestPolicyEngine() (*policyEngine, *int) {
+	created := 0
+	pe := &policyEngine{
+		log:         logr.Discard(),
+		clientCache: cache.New(10*time.Minute, 15*time.Minute),
+		mutex:       &sync.Mutex{},
+	}
+	pe.newClient = func(credentials ClientCredentials) (*keyhub.Client, error) {
+		created++
+		return &keyhub.Client{ID: credentials.ClientID}, nil
+	}
+	return pe, &created
+}
+
+func TestPolicyEngineReusesClient(t *testing.T) {
+	pe, created := newTestPolicyEngine()
+	credentials := ClientCredentials{ClientID: "CLIENT-0001", ClientSecret: "VERY_SECRET_PHRASE"}
+
+	first, err := pe.getClient(credentials)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := pe.getClient(credentials)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if first != second || *created != 1 {
+		t.Errorf("Expected the cached client to be reused, created %d clients", *created)
+	}
+}
+
+func TestPolicyEngineDoesNotReuseClientForOtherSecret(t *testing.T) {
+	pe, created := newTestPolicyEngine()
+	cached, err := pe.getClient(ClientCredentials{ClientID: "CLIENT-0001", ClientSecret: "VERY_SECRET_PHRASE"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	other, err := pe.getClient(ClientCredentials{ClientID: "CLIENT-0001", ClientSecret: "WRONG_PHRASE"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if other == cached {
+		t.Error("Expected a wrong client secret not to get the cached client")
+	}
+	if *created != 2 {
+		t.Errorf("Expected a new client for the other secret, created %d clients", *created)
+	}
+}
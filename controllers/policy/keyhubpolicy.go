@@ -0,0 +1,189 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	keyhubPolicyClientID     = "clientId"
+	keyhubPolicyClientSecret = "clientSecret"
+)
+
+// getPolicies merges the policies of the vault records with the
+// KeyHubPolicies. Invalid allow policies are skipped, invalid deny policies
+// are kept so they don't fail open.
+func (pe *policyEngine) getPolicies() ([]Policy, error) {
+	vaultPolicies, err := pe.policyCache.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	list := &keyhubv1beta1.KeyHubPolicyList{}
+	if err := pe.client.List(context.TODO(), list); err != nil {
+		// The KeyHubPolicy CRD is optional
+		if meta.IsNoMatchError(err) {
+			return vaultPolicies.Policies, nil
+		}
+		return nil, err
+	}
+
+	policies := make([]Policy, 0, len(vaultPolicies.Policies)+len(list.Items))
+	policies = append(policies, vaultPolicies.Policies...)
+	for i := range list.Items {
+		kp := &list.Items[i]
+		policy, err := pe.loadKeyHubPolicy(kp, vaultPolicies.Credentials)
+		if err != nil && kp.Spec.Effect == PolicyEffectDeny {
+			pe.log.Info("Invalid deny KeyHubPolicy fails the KeyHubSecrets it may match", "name", kp.Name, "error", err.Error())
+			policies = append(policies, invalidKeyHubPolicy(kp, err))
+			continue
+		}
+		if err != nil {
+			pe.log.Info("Skipping invalid KeyHubPolicy", "name", kp.Name, "error", err.Error())
+			continue
+		}
+		policies = append(policies, *policy)
+	}
+	return policies, nil
+}
+
+func (pe *policyEngine) LoadKeyHubPolicy(kp *keyhubv1beta1.KeyHubPolicy) (*Policy, error) {
+	vaultPolicies, err := pe.policyCache.GetPolicies()
+	if err != nil {
+		return nil, err
+	}
+	return pe.loadKeyHubPolicy(kp, vaultPolicies.Credentials)
+}
+
+func (pe *policyEngine) loadKeyHubPolicy(kp *keyhubv1beta1.KeyHubPolicy, vaultCredentials map[string]ClientCredentials) (*Policy, error) {
+	if err := validateKeyHubPolicy(&kp.Spec); err != nil {
+		return nil, err
+	}
+
+	records := make([]RecordRule, 0, len(kp.Spec.Records))
+	for _, rule := range kp.Spec.Records {
+		record, err := NewRecordRule(rule.Group, rule.UUID, rule.NamePattern)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	// Deny policies don't use a KeyHub client
+	credentials := &ClientCredentials{}
+	if kp.Spec.Effect != PolicyEffectDeny {
+		var err error
+		credentials, err = pe.keyhubPolicyCredentials(&kp.Spec.Credentials, vaultCredentials)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Policy{
+		policy: policy{
+			Type:               kp.Spec.Type,
+			Priority:           int(kp.Spec.Priority),
+			Name:               kp.Spec.Name,
+			NameRegex:          kp.Spec.NameRegex,
+			LabelSelector:      kp.Spec.LabelSelector,
+			AnnotationSelector: kp.Spec.AnnotationSelector,
+			Namespace:          kp.Spec.Namespace,
+			NamespaceRegex:     kp.Spec.NamespaceRegex,
+			Effect:             kp.Spec.Effect,
+			Records:            records,
+		},
+		Credentials: *credentials,
+		Source:      PolicySource{KeyHubPolicy: kp.Name},
+	}, nil
+}
+
+// invalidKeyHubPolicy returns the deny policy of kp, that failed validation
+// with err
+func invalidKeyHubPolicy(kp *keyhubv1beta1.KeyHubPolicy, err error) Policy {
+	return Policy{
+		policy: policy{
+			Type:               kp.Spec.Type,
+			Name:               kp.Spec.Name,
+			NameRegex:          kp.Spec.NameRegex,
+			LabelSelector:      kp.Spec.LabelSelector,
+			AnnotationSelector: kp.Spec.AnnotationSelector,
+			Namespace:          kp.Spec.Namespace,
+			NamespaceRegex:     kp.Spec.NamespaceRegex,
+			Effect:             PolicyEffectDeny,
+		},
+		Source:  PolicySource{KeyHubPolicy: kp.Name},
+		Invalid: err,
+	}
+}
+
+// validateKeyHubPolicy checks the expressions of spec up front, so an invalid
+// KeyHubPolicy does not fail the policy resolution of every KeyHubSecret
+func validateKeyHubPolicy(spec *keyhubv1beta1.KeyHubPolicySpec) error {
+	switch spec.Type {
+	case PolicyTypeNamespace:
+		if spec.Name == "" && spec.NameRegex == "" && spec.LabelSelector == "" {
+			return fmt.Errorf("Policy of type '%s' requires a name, nameRegex or labelSelector", spec.Type)
+		}
+	case PolicyTypeKeyHubSecret, PolicyTypeServiceAccount:
+		if spec.Name == "" && spec.NameRegex == "" && spec.LabelSelector == "" && spec.AnnotationSelector == "" {
+			return fmt.Errorf("Policy of type '%s' requires a name, nameRegex, labelSelector or annotationSelector", spec.Type)
+		}
+	default:
+		return fmt.Errorf("Unsupported policy type '%s'", spec.Type)
+	}
+
+	for _, expr := range []string{spec.NameRegex, spec.NamespaceRegex} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("Invalid regular expression '%s': %w", expr, err)
+		}
+	}
+	for _, selector := range []string{spec.LabelSelector, spec.AnnotationSelector} {
+		if _, err := labels.Parse(selector); err != nil {
+			return fmt.Errorf("Invalid selector '%s': %w", selector, err)
+		}
+	}
+	return nil
+}
+
+// keyhubPolicyCredentials reads the client credentials from the referenced
+// Secret or vault record
+func (pe *policyEngine) keyhubPolicyCredentials(ref *keyhubv1beta1.KeyHubPolicyCredentials, vaultCredentials map[string]ClientCredentials) (*ClientCredentials, error) {
+	if (ref.SecretRef == nil) == (ref.VaultRecord == "") {
+		return nil, fmt.Errorf("Exactly one of secretRef and vaultRecord must be set")
+	}
+
+	if ref.VaultRecord != "" {
+		credentials, found := vaultCredentials[ref.VaultRecord]
+		if !found {
+			return nil, fmt.Errorf("Vault record %s not found or missing a username and password", ref.VaultRecord)
+		}
+		return &credentials, nil
+	}
+
+	if ref.SecretRef.Namespace == "" || ref.SecretRef.Name == "" {
+		return nil, fmt.Errorf("Namespace and name of the Secret are required")
+	}
+	secret := &corev1.Secret{}
+	if err := pe.client.Get(context.TODO(), client.ObjectKey{Namespace: ref.SecretRef.Namespace, Name: ref.SecretRef.Name}, secret); err != nil {
+		return nil, fmt.Errorf("Failed to read Secret %s/%s: %w", ref.SecretRef.Namespace, ref.SecretRef.Name, err)
+	}
+	for _, key := range []string{keyhubPolicyClientID, keyhubPolicyClientSecret} {
+		if len(secret.Data[key]) == 0 {
+			return nil, fmt.Errorf("Secret %s/%s is missing the key '%s'", ref.SecretRef.Namespace, ref.SecretRef.Name, key)
+		}
+	}
+	return &ClientCredentials{
+		ClientID:     string(secret.Data[keyhubPolicyClientID]),
+		ClientSecret: string(secret.Data[keyhubPolicyClientSecret]),
+	}, nil
+}
@@ -21,8 +21,17 @@ type comment struct {
 	Policies []policy `yaml:"policies"`
 }
 
+// VaultPolicies are loaded from the records of the vaults read by the
+// operator
+type VaultPolicies struct {
+	Policies []Policy
+	// Credentials of all records with a username and password, keyed by
+	// record UUID, referenced by KeyHubPolicies
+	Credentials map[string]ClientCredentials
+}
+
 type PolicyLoader interface {
-	Load() (*[]Policy, error)
+	Load() (*VaultPolicies, error)
 }
 
 type policyLoader struct {
@@ -38,7 +47,7 @@ func NewPolicyLoader(log logr.Logger, settingsMgr settings.SettingsManager) Poli
 	}
 }
 
-func (pl *policyLoader) Load() (*[]Policy, error) {
+func (pl *policyLoader) Load() (*VaultPolicies, error) {
 	if pl.client == nil {
 		err := pl.init()
 		if err != nil {
@@ -52,18 +61,20 @@ func (pl *policyLoader) Load() (*[]Policy, error) {
 		return nil, err
 	}
 
-	var policies []Policy
-	policies = make([]Policy, 0)
+	loaded := &VaultPolicies{
+		Policies:    make([]Policy, 0),
+		Credentials: make(map[string]ClientCredentials),
+	}
 	for _, group := range groups {
-		err := pl.loadPolicies(&policies, group)
+		err := pl.loadPolicies(loaded, group)
 		if err != nil {
 			return nil, err
 		}
 	}
-	return &policies, nil
+	return loaded, nil
 }
 
-func (pl *policyLoader) loadPolicies(policies *[]Policy, group keyhubmodel.Group) error {
+func (pl *policyLoader) loadPolicies(loaded *VaultPolicies, group keyhubmodel.Group) error {
 	pl.log.Info("loading group policies", "uuid", group.UUID, "name", group.Name)
 	metrics.KeyHubApiRequests.WithLabelValues("vault", "list").Inc()
 	records, err := pl.client.Vaults.GetRecords(&group)
@@ -86,8 +97,15 @@ func (pl *policyLoader) loadPolicies(policies *[]Policy, group keyhubmodel.Group
 			return err
 		}
 
-		if rec.Username == "" || rec.Password() == nil || rec.Comment() == nil || !strings.HasPrefix(*rec.Comment(), "policies:") {
-			pl.log.Info("record missing username, password or policy comment", "uuid", rec.UUID)
+		if rec.Username == "" || rec.Password() == nil {
+			pl.log.Info("record missing username or password", "uuid", rec.UUID)
+			continue
+		}
+		credentials := ClientCredentials{ClientID: rec.Username, ClientSecret: *rec.Password()}
+		loaded.Credentials[rec.UUID] = credentials
+
+		if rec.Comment() == nil || !strings.HasPrefix(*rec.Comment(), "policies:") {
+			pl.log.Info("record missing policy comment", "uuid", rec.UUID)
 			continue
 		}
 
@@ -98,12 +116,27 @@ func (pl *policyLoader) loadPolicies(policies *[]Policy, group keyhubmodel.Group
 			continue
 		}
 
-		for _, policy := range comment.Policies {
-			*policies = append(*policies, Policy{policy: policy, Credentials: ClientCredentials{ClientID: rec.Username, ClientSecret: *rec.Password()}})
+		for i, policy := range comment.Policies {
+			loadedPolicy := Policy{
+				policy:      policy,
+				Credentials: credentials,
+				Source:      PolicySource{Group: group.Name, RecordUUID: rec.UUID, RecordName: rec.Name, Index: i},
+			}
+			if err := compileRecordRules(loadedPolicy.Records); err != nil {
+				// Invalid deny policies are kept so they don't fail open
+				if policy.Effect != PolicyEffectDeny {
+					pl.log.Info("Skipping invalid policy", "uuid", rec.UUID, "index", i, "error", err.Error())
+					continue
+				}
+				pl.log.Info("Invalid deny policy fails the KeyHubSecrets it may match", "uuid", rec.UUID, "index", i, "error", err.Error())
+				loadedPolicy.Records = nil
+				loadedPolicy.Invalid = err
+			}
+			loaded.Policies = append(loaded.Policies, loadedPolicy)
 		}
 	}
 
-	pl.log.Info("group policies loaded", "count", len(*policies))
+	pl.log.Info("group policies loaded", "count", len(loaded.Policies))
 
 	return nil
 }
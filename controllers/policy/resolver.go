@@ -0,0 +1,298 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package policy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/go-logr/logr"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// The ranks of the matching policies, the highest rank wins. Namespace
+// policies matching by label selector are ranked below the other namespace
+// policies.
+const (
+	rankNamespaceLabelSelector = iota + 1
+	rankNamespaceRegex
+	rankNamespaceName
+	rankKeyHubSecret
+	rankServiceAccount
+)
+
+// policyMatch is a policy matching a KeyHubSecret. Matches of the same rank
+// are ordered by score, the more specific match has the higher score.
+type policyMatch struct {
+	policy Policy
+	rank   int
+	score  int
+}
+
+// compare orders the matches by priority, rank and score, highest first.
+// It returns 0 for matches of the same precedence.
+func (m *policyMatch) compare(other *policyMatch) int {
+	for _, diff := range []int{
+		other.policy.Priority - m.policy.Priority,
+		other.rank - m.rank,
+		other.score - m.score,
+	} {
+		if diff != 0 {
+			return diff
+		}
+	}
+	return 0
+}
+
+// NewPolicyResolver returns a resolver for the policies of all types, the
+// ServiceAccount of a KeyHubSecret is read with client
+func NewPolicyResolver(client client.Client, log logr.Logger, policies []Policy) PolicyResolver {
+	return &policyResolver{
+		client:   client,
+		log:      log,
+		policies: policies,
+	}
+}
+
+func (r *policyResolver) Resolve(secret *keyhubv1beta1.KeyHubSecret) (*Policy, error) {
+	resolution, err := r.ResolveAll(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &resolution.Policy, nil
+}
+
+// ResolveAll orders the policies matching secret by precedence:
+//  1. priority, highest first
+//  2. type: serviceaccount, keyhubsecret, then namespace policies matching
+//     the name, the regex and the label selector
+//  3. score: the number of matched fields and selector requirements, or
+//     the fewest namespaces matched by a namespace label selector
+//  4. the UUID of the vault record and the position in its comment, the
+//     KeyHubPolicies precede the vault records and are ordered by name
+//
+// Deny policies are not ordered, every matching deny policy applies.
+func (r *policyResolver) ResolveAll(secret *keyhubv1beta1.KeyHubSecret) (*Resolution, error) {
+	namespace := secret.GetNamespace()
+
+	var serviceAccount *corev1.ServiceAccount
+	if secret.Spec.ServiceAccountName != "" {
+		serviceAccount = &corev1.ServiceAccount{}
+		err := r.client.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: secret.Spec.ServiceAccountName}, serviceAccount)
+		if errors.IsNotFound(err) {
+			r.log.Info("ServiceAccount not found, skipping serviceaccount policies", "namespace", namespace, "name", secret.Spec.ServiceAccountName)
+			serviceAccount = nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []*policyMatch
+	var denied []Policy
+	for _, policy := range r.policies {
+		if policy.Invalid != nil {
+			if r.mayMatch(policy, secret, serviceAccount) {
+				return nil, fmt.Errorf("%w: policy '%s' of %s may match and is invalid: %s", ErrInvalidDenyPolicy, policy.String(), policy.Source.String(), policy.Invalid)
+			}
+			continue
+		}
+
+		var match *policyMatch
+		var err error
+		switch policy.Type {
+		case PolicyTypeNamespace:
+			match, err = r.matchNamespace(policy, namespace)
+		case PolicyTypeKeyHubSecret:
+			match, err = r.matchObject(policy, rankKeyHubSecret, namespace, secret.Name, secret.Labels, secret.Annotations)
+		case PolicyTypeServiceAccount:
+			if serviceAccount != nil {
+				match, err = r.matchObject(policy, rankServiceAccount, namespace, serviceAccount.Name, serviceAccount.Labels, serviceAccount.Annotations)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if match == nil {
+			continue
+		}
+		// A misspelled deny policy must not grant access
+		if policy.Effect != "" && policy.Effect != PolicyEffectAllow && policy.Effect != PolicyEffectDeny {
+			return nil, fmt.Errorf("Policy '%s' has unsupported effect '%s'", policy.String(), policy.Effect)
+		}
+		if policy.IsDeny() {
+			r.log.Info("Found deny match", "namespace", namespace, "keyhubsecret", secret.Name, "policy", policy.String())
+			denied = append(denied, policy)
+			continue
+		}
+		r.log.Info("Found match", "namespace", namespace, "keyhubsecret", secret.Name, "policy", policy.String(), "ClientID", policy.Credentials.ClientID, "rank", match.rank, "score", match.score)
+		matches = append(matches, match)
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("No credentials found for namespace %s", namespace)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if diff := matches[i].compare(matches[j]); diff != 0 {
+			return diff < 0
+		}
+		si, sj := matches[i].policy.Source, matches[j].policy.Source
+		if si.RecordUUID != sj.RecordUUID {
+			return si.RecordUUID < sj.RecordUUID
+		}
+		if si.KeyHubPolicy != sj.KeyHubPolicy {
+			return si.KeyHubPolicy < sj.KeyHubPolicy
+		}
+		return si.Index < sj.Index
+	})
+
+	resolution := &Resolution{Policy: matches[0].policy, Denied: denied}
+	for _, match := range matches[1:] {
+		resolution.Shadowed = append(resolution.Shadowed, match.policy)
+		if match.compare(matches[0]) == 0 && match.policy.Credentials.ClientID != matches[0].policy.Credentials.ClientID {
+			resolution.Conflicting = append(resolution.Conflicting, match.policy)
+		}
+	}
+	return resolution, nil
+}
+
+// matchObject matches a keyhubsecret or serviceaccount policy against an
+// object in namespace. Every field set in the policy must match, the score is
+// the number of matched fields and selector requirements.
+func (r *policyResolver) matchObject(policy Policy, rank int, namespace string, name string, objectLabels map[string]string, objectAnnotations map[string]string) (*policyMatch, error) {
+	if policy.Name == "" && policy.NameRegex == "" && policy.LabelSelector == "" && policy.AnnotationSelector == "" {
+		r.log.Info("Ignoring policy without name, nameRegex, labelSelector or annotationSelector", "policy", policy.String())
+		return nil, nil
+	}
+
+	score := 0
+	for _, field := range []struct {
+		value string
+		match func(string) (bool, error)
+	}{
+		{policy.Namespace, func(value string) (bool, error) { return namespace == value, nil }},
+		{policy.NamespaceRegex, func(value string) (bool, error) { return regexp.MatchString(value, namespace) }},
+		{policy.Name, func(value string) (bool, error) { return name == value, nil }},
+		{policy.NameRegex, func(value string) (bool, error) { return regexp.MatchString(value, name) }},
+	} {
+		if field.value == "" {
+			continue
+		}
+		found, err := field.match(field.value)
+		if err != nil || !found {
+			return nil, err
+		}
+		score++
+	}
+
+	for _, selector := range []struct {
+		value string
+		set   map[string]string
+	}{
+		{policy.LabelSelector, objectLabels},
+		{policy.AnnotationSelector, objectAnnotations},
+	} {
+		if selector.value == "" {
+			continue
+		}
+		ls, err := labels.Parse(selector.value)
+		if err != nil {
+			return nil, err
+		}
+		if !ls.Matches(labels.Set(selector.set)) {
+			return nil, nil
+		}
+		requirements, _ := ls.Requirements()
+		score += len(requirements)
+	}
+
+	return &policyMatch{policy: policy, rank: rank, score: score}, nil
+}
+
+// mayMatch reports whether the invalid policy may match secret. Fields that
+// can't be evaluated are taken to match, so an invalid deny policy fails
+// closed.
+func (r *policyResolver) mayMatch(policy Policy, secret *keyhubv1beta1.KeyHubSecret, serviceAccount *corev1.ServiceAccount) bool {
+	equals := func(actual string) func(string) (bool, error) {
+		return func(value string) (bool, error) { return actual == value, nil }
+	}
+	matchesRegex := func(actual string) func(string) (bool, error) {
+		return func(value string) (bool, error) { return regexp.MatchString(value, actual) }
+	}
+	matchesSelector := func(set map[string]string) func(string) (bool, error) {
+		return func(value string) (bool, error) {
+			ls, err := labels.Parse(value)
+			if err != nil {
+				return false, err
+			}
+			return ls.Matches(labels.Set(set)), nil
+		}
+	}
+
+	type field struct {
+		value string
+		match func(string) (bool, error)
+	}
+	namespace := secret.GetNamespace()
+
+	switch policy.Type {
+	case PolicyTypeNamespace:
+		// The fields of a namespace policy are alternatives
+		nsLabels := map[string]string{}
+		if policy.LabelSelector != "" {
+			ns := &corev1.Namespace{}
+			if err := r.client.Get(context.TODO(), client.ObjectKey{Name: namespace}, ns); err != nil {
+				return true
+			}
+			nsLabels = ns.Labels
+		}
+		set := false
+		for _, f := range []field{
+			{policy.Name, equals(namespace)},
+			{policy.NameRegex, matchesRegex(namespace)},
+			{policy.LabelSelector, matchesSelector(nsLabels)},
+		} {
+			if f.value == "" {
+				continue
+			}
+			set = true
+			if found, err := f.match(f.value); err != nil || found {
+				return true
+			}
+		}
+		return !set
+	case PolicyTypeKeyHubSecret, PolicyTypeServiceAccount:
+		name, objectLabels, objectAnnotations := secret.Name, secret.Labels, secret.Annotations
+		if policy.Type == PolicyTypeServiceAccount {
+			if serviceAccount == nil {
+				return false
+			}
+			name, objectLabels, objectAnnotations = serviceAccount.Name, serviceAccount.Labels, serviceAccount.Annotations
+		}
+		for _, f := range []field{
+			{policy.Namespace, equals(namespace)},
+			{policy.NamespaceRegex, matchesRegex(namespace)},
+			{policy.Name, equals(name)},
+			{policy.NameRegex, matchesRegex(name)},
+			{policy.LabelSelector, matchesSelector(objectLabels)},
+			{policy.AnnotationSelector, matchesSelector(objectAnnotations)},
+		} {
+			if f.value == "" {
+				continue
+			}
+			if found, err := f.match(f.value); err == nil && !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return true
+	}
+}
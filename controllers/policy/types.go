@@ -4,14 +4,54 @@
 package policy
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/go-logr/logr"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+const (
+	// PolicyTypeNamespace matches the namespace of the KeyHubSecret
+	PolicyTypeNamespace = "namespace"
+	// PolicyTypeKeyHubSecret matches the KeyHubSecret itself
+	PolicyTypeKeyHubSecret = "keyhubsecret"
+	// PolicyTypeServiceAccount matches the ServiceAccount referenced by the
+	// KeyHubSecret
+	PolicyTypeServiceAccount = "serviceaccount"
+)
+
 type Policy struct {
 	policy
 	Credentials ClientCredentials
+	Source      PolicySource
+	// Invalid is the validation error of an invalid deny policy, it fails
+	// the resolution of the KeyHubSecrets the policy may match
+	Invalid error
+}
+
+// PolicySource is the vault record or KeyHubPolicy defining a policy
+type PolicySource struct {
+	// Group is the name of the group owning the vault
+	Group      string
+	RecordUUID string
+	RecordName string
+	// Index is the position of the policy in the comment of the record
+	Index int
+	// KeyHubPolicy is the name of the KeyHubPolicy
+	KeyHubPolicy string
+}
+
+// String describes the source in log and error messages
+func (s PolicySource) String() string {
+	if s.KeyHubPolicy != "" {
+		return "KeyHubPolicy " + s.KeyHubPolicy
+	}
+	if s.RecordUUID == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s (%s) #%d", s.Group, s.RecordName, s.RecordUUID, s.Index)
 }
 
 type ClientCredentials struct {
@@ -20,14 +60,74 @@ type ClientCredentials struct {
 }
 
 type policy struct {
-	Type          string `yaml:"type"`
-	Name          string `yaml:"name,omitempty"`
-	NameRegex     string `yaml:"nameRegex,omitempty"`
-	LabelSelector string `yaml:"labelSelector,omitempty"`
+	Type string `yaml:"type"`
+	// Priority precedes the other precedence rules, higher wins
+	Priority int `yaml:"priority,omitempty"`
+	// Name, NameRegex, LabelSelector and AnnotationSelector match the object
+	// of the policy type
+	Name               string `yaml:"name,omitempty"`
+	NameRegex          string `yaml:"nameRegex,omitempty"`
+	LabelSelector      string `yaml:"labelSelector,omitempty"`
+	AnnotationSelector string `yaml:"annotationSelector,omitempty"`
+	// Namespace and NamespaceRegex restrict the keyhubsecret and
+	// serviceaccount policies to namespaces
+	Namespace      string `yaml:"namespace,omitempty"`
+	NamespaceRegex string `yaml:"namespaceRegex,omitempty"`
+	// Effect is allow or deny, defaults to allow
+	Effect string `yaml:"effect,omitempty"`
+	// Records restricts the vault records of the matching KeyHubSecrets,
+	// an allow policy without records allows all records of its client and
+	// a deny policy without records denies all records
+	Records []RecordRule `yaml:"records,omitempty"`
+}
+
+// IsDeny reports whether the policy denies access to records
+func (p policy) IsDeny() bool {
+	return p.Effect == PolicyEffectDeny
+}
+
+// String describes the policy in log and error messages
+func (p policy) String() string {
+	fields := []string{"type=" + p.Type}
+	if p.IsDeny() {
+		fields = append(fields, "effect=deny")
+	}
+	if p.Priority != 0 {
+		fields = append(fields, fmt.Sprintf("priority=%d", p.Priority))
+	}
+	for _, field := range []struct{ name, value string }{
+		{"namespace", p.Namespace},
+		{"namespaceRegex", p.NamespaceRegex},
+		{"name", p.Name},
+		{"nameRegex", p.NameRegex},
+		{"labelSelector", p.LabelSelector},
+		{"annotationSelector", p.AnnotationSelector},
+	} {
+		if field.value != "" {
+			fields = append(fields, fmt.Sprintf("%s='%s'", field.name, field.value))
+		}
+	}
+	return strings.Join(fields, " ")
 }
 
 type PolicyResolver interface {
-	Resolve(secret *keyhubv1alpha1.KeyHubSecret) (*Policy, error)
+	// Resolve returns the matching policy with the highest precedence
+	Resolve(secret *keyhubv1beta1.KeyHubSecret) (*Policy, error)
+	// ResolveAll returns all matching policies
+	ResolveAll(secret *keyhubv1beta1.KeyHubSecret) (*Resolution, error)
+}
+
+// Resolution lists the policies matching a KeyHubSecret
+type Resolution struct {
+	// Policy is the matching policy with the highest precedence
+	Policy Policy
+	// Shadowed are the other matching policies, ordered by precedence
+	Shadowed []Policy
+	// Conflicting are the shadowed policies for another client that only
+	// lose to Policy on the order of their vault records
+	Conflicting []Policy
+	// Denied are the matching deny policies
+	Denied []Policy
 }
 
 type policyResolver struct {
@@ -0,0 +1,291 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/policy"
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var _ = Describe("Policy Resolver", func() {
+
+	BeforeEach(func() {
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+	})
+
+	newPolicy := func(policyType string, clientID string) policy.Policy {
+		p := policy.Policy{}
+		p.Type = policyType
+		p.Credentials = policy.ClientCredentials{ClientID: clientID}
+		return p
+	}
+
+	newKeyHubSecret := func(labels map[string]string, annotations map[string]string, serviceAccountName string) *keyhubv1beta1.KeyHubSecret {
+		return &keyhubv1beta1.KeyHubSecret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "sample-ks",
+				Namespace:   "default",
+				Labels:      labels,
+				Annotations: annotations,
+			},
+			Spec: keyhubv1beta1.KeyHubSecretSpec{
+				ServiceAccountName: serviceAccountName,
+				Data: []keyhubv1beta1.SecretKeyReference{
+					{Name: "username", Record: "1001-0002", Property: "username"},
+				},
+			},
+		}
+	}
+
+	resolve := func(ks *keyhubv1beta1.KeyHubSecret, policies ...policy.Policy) (*policy.Policy, error) {
+		resolver := policy.NewPolicyResolver(k8sClient, logf.Log.WithName("PolicyResolver"), policies)
+		return resolver.Resolve(ks)
+	}
+
+	Context("Namespace precedence", func() {
+		It("Should prefer the exact namespace name over a regular expression", func() {
+			p1 := newPolicy(policy.PolicyTypeNamespace, "1357")
+			p1.NameRegex = "def.*"
+			p2 := newPolicy(policy.PolicyTypeNamespace, "0246")
+			p2.Name = "default"
+
+			matched, err := resolve(newKeyHubSecret(nil, nil, ""), p1, p2)
+			Expect(err).To(BeNil())
+			Expect(matched.Credentials.ClientID).To(Equal("0246"))
+		})
+	})
+
+	Context("KeyHubSecret Match", func() {
+		It("Should prefer a KeyHubSecret label match over the namespace", func() {
+			p1 := newPolicy(policy.PolicyTypeNamespace, "1357")
+			p1.Name = "default"
+			p2 := newPolicy(policy.PolicyTypeKeyHubSecret, "0246")
+			p2.Namespace = "default"
+			p2.LabelSelector = "team=a"
+			p3 := newPolicy(policy.PolicyTypeKeyHubSecret, "9753")
+			p3.LabelSelector = "team=b"
+
+			matched, err := resolve(newKeyHubSecret(map[string]string{"team": "a"}, nil, ""), p1, p2, p3)
+			Expect(err).To(BeNil())
+			Expect(matched.Credentials.ClientID).To(Equal("0246"))
+
+			matched, err = resolve(newKeyHubSecret(map[string]string{"team": "c"}, nil, ""), p1, p2, p3)
+			Expect(err).To(BeNil())
+			Expect(matched.Credentials.ClientID).To(Equal("1357"))
+		})
+
+		It("Should match the annotations of the KeyHubSecret", func() {
+			p1 := newPolicy(policy.PolicyTypeKeyHubSecret, "1357")
+			p1.AnnotationSelector = "keyhub.topicus.nl/application=billing"
+
+			matched, err := resolve(newKeyHubSecret(nil, map[string]string{"keyhub.topicus.nl/application": "billing"}, ""), p1)
+			Expect(err).To(BeNil())
+			Expect(matched.Credentials.ClientID).To(Equal("1357"))
+		})
+
+		It("Should not match outside of the namespace of the policy", func() {
+			p1 := newPolicy(policy.PolicyTypeKeyHubSecret, "1357")
+			p1.Namespace = "other"
+			p1.LabelSelector = "team=a"
+
+			_, err := resolve(newKeyHubSecret(map[string]string{"team": "a"}, nil, ""), p1)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("No credentials found for namespace default"))
+		})
+
+		It("Should prefer the most specific match", func() {
+			p1 := newPolicy(policy.PolicyTypeKeyHubSecret, "1357")
+			p1.LabelSelector = "team=a"
+			p2 := newPolicy(policy.PolicyTypeKeyHubSecret, "0246")
+			p2.LabelSelector = "team=a,component=db"
+
+			matched, err := resolve(newKeyHubSecret(map[string]string{"team": "a", "component": "db"}, nil, ""), p1, p2)
+			Expect(err).To(BeNil())
+			Expect(matched.Credentials.ClientID).To(Equal("0246"))
+		})
+
+		It("Should report policies with the same precedence as conflicting", func() {
+			p1 := newPolicy(policy.PolicyTypeKeyHubSecret, "1357")
+			p1.LabelSelector = "team=a"
+			p2 := newPolicy(policy.PolicyTypeKeyHubSecret, "0246")
+			p2.AnnotationSelector = "team=a"
+
+			resolver := policy.NewPolicyResolver(k8sClient, logf.Log.WithName("PolicyResolver"), []policy.Policy{p1, p2})
+			resolution, err := resolver.ResolveAll(newKeyHubSecret(map[string]string{"team": "a"}, map[string]string{"team": "a"}, ""))
+			Expect(err).To(BeNil())
+			Expect(resolution.Policy.Credentials.ClientID).To(Equal("1357"))
+			Expect(resolution.Conflicting).To(HaveLen(1))
+			Expect(resolution.Conflicting[0].Credentials.ClientID).To(Equal("0246"))
+		})
+	})
+
+	Context("Priority", func() {
+		It("Should prefer the policy with the highest priority", func() {
+			p1 := newPolicy(policy.PolicyTypeServiceAccount, "1357")
+			p1.Name = "billing-deployer"
+			p2 := newPolicy(policy.PolicyTypeNamespace, "0246")
+			p2.NameRegex = "def.*"
+			p2.Priority = 10
+			p3 := newPolicy(policy.PolicyTypeNamespace, "9753")
+			p3.Name = "default"
+
+			resolver := policy.NewPolicyResolver(k8sClient, logf.Log.WithName("PolicyResolver"), []policy.Policy{p1, p3, p2})
+			resolution, err := resolver.ResolveAll(newKeyHubSecret(nil, nil, ""))
+			Expect(err).To(BeNil())
+			Expect(resolution.Policy.Credentials.ClientID).To(Equal("0246"))
+			Expect(resolution.Shadowed).To(HaveLen(1))
+			Expect(resolution.Shadowed[0].Credentials.ClientID).To(Equal("9753"))
+			Expect(resolution.Conflicting).To(BeEmpty())
+		})
+	})
+
+	Context("Record access", func() {
+		newRecord := func(group string, uuid string, name string) vault.VaultRecordWithGroup {
+			g := keyhubmodel.Group{}
+			g.UUID = "uuid-" + group
+			g.Name = group
+			return vault.VaultRecordWithGroup{Group: g, Record: keyhubmodel.VaultRecord{UUID: uuid, Name: name}}
+		}
+
+		It("Should only allow the records of the matched policy", func() {
+			p1 := newPolicy(policy.PolicyTypeNamespace, "1357")
+			p1.Name = "default"
+			p1.Records = []policy.RecordRule{{Group: "Team A"}, {UUID: "1002-0001"}}
+
+			resolver := policy.NewPolicyResolver(k8sClient, logf.Log.WithName("PolicyResolver"), []policy.Policy{p1})
+			resolution, err := resolver.ResolveAll(newKeyHubSecret(nil, nil, ""))
+			Expect(err).To(BeNil())
+
+			access := resolution.RecordAccess()
+			Expect(access.Check(newRecord("Team A", "1001-0001", "db"))).To(Succeed())
+			Expect(access.Check(newRecord("Team B", "1002-0001", "db"))).To(Succeed())
+			Expect(access.Check(newRecord("Team B", "1002-0002", "db"))).NotTo(Succeed())
+
+			filtered := access.Filter(map[string]vault.VaultRecordWithGroup{
+				"1001-0001": newRecord("Team A", "1001-0001", "db"),
+				"1002-0002": newRecord("Team B", "1002-0002", "db"),
+			})
+			Expect(filtered).To(HaveLen(1))
+			Expect(filtered).To(HaveKey("1001-0001"))
+		})
+
+		It("Should apply every matching deny policy", func() {
+			p1 := newPolicy(policy.PolicyTypeNamespace, "1357")
+			p1.Name = "default"
+			p1.Priority = 10
+			p2 := newPolicy(policy.PolicyTypeKeyHubSecret, "0246")
+			p2.LabelSelector = "team=a"
+			p2.Effect = policy.PolicyEffectDeny
+			rule, err := policy.NewRecordRule("Team A", "", "prod-.*")
+			Expect(err).To(BeNil())
+			p2.Records = []policy.RecordRule{rule}
+
+			resolver := policy.NewPolicyResolver(k8sClient, logf.Log.WithName("PolicyResolver"), []policy.Policy{p1, p2})
+			resolution, err := resolver.ResolveAll(newKeyHubSecret(map[string]string{"team": "a"}, nil, ""))
+			Expect(err).To(BeNil())
+			Expect(resolution.Policy.Credentials.ClientID).To(Equal("1357"))
+			Expect(resolution.Shadowed).To(BeEmpty())
+			Expect(resolution.Denied).To(HaveLen(1))
+
+			access := resolution.RecordAccess()
+			Expect(access.Check(newRecord("Team A", "1001-0001", "test-db"))).To(Succeed())
+			Expect(access.Check(newRecord("Team A", "1001-0002", "prod-db"))).NotTo(Succeed())
+
+			By("By denying all records without record rules")
+			p2.Records = nil
+			resolver = policy.NewPolicyResolver(k8sClient, logf.Log.WithName("PolicyResolver"), []policy.Policy{p1, p2})
+			resolution, err = resolver.ResolveAll(newKeyHubSecret(map[string]string{"team": "a"}, nil, ""))
+			Expect(err).To(BeNil())
+			Expect(resolution.RecordAccess().Check(newRecord("Team A", "1001-0001", "test-db"))).NotTo(Succeed())
+		})
+
+		It("Should not select the client of a deny policy", func() {
+			p1 := newPolicy(policy.PolicyTypeNamespace, "1357")
+			p1.Name = "default"
+			p1.Effect = policy.PolicyEffectDeny
+
+			_, err := resolve(newKeyHubSecret(nil, nil, ""), p1)
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("No credentials found for namespace default"))
+		})
+
+		It("Should reject a matching policy with an unsupported effect", func() {
+			p1 := newPolicy(policy.PolicyTypeNamespace, "1357")
+			p1.Name = "default"
+			p1.Effect = "Deny"
+
+			_, err := resolve(newKeyHubSecret(nil, nil, ""), p1)
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("Should fail the KeyHubSecrets an invalid deny policy may match", func() {
+			p1 := newPolicy(policy.PolicyTypeNamespace, "1357")
+			p1.Name = "default"
+			p2 := newPolicy(policy.PolicyTypeKeyHubSecret, "")
+			p2.Namespace = "default"
+			p2.NameRegex = "sample-("
+			p2.Effect = policy.PolicyEffectDeny
+			p2.Invalid = fmt.Errorf("Invalid regular expression")
+
+			_, err := resolve(newKeyHubSecret(nil, nil, ""), p1, p2)
+			Expect(err).ToNot(BeNil())
+			Expect(goerrors.Is(err, policy.ErrInvalidDenyPolicy)).To(BeTrue())
+
+			By("By ignoring it outside of its namespace")
+			p2.Namespace = "other"
+			matched, err := resolve(newKeyHubSecret(nil, nil, ""), p1, p2)
+			Expect(err).To(BeNil())
+			Expect(matched.Credentials.ClientID).To(Equal("1357"))
+		})
+	})
+
+	Context("ServiceAccount Match", func() {
+		It("Should prefer the referenced ServiceAccount over the KeyHubSecret", func() {
+			sa := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "billing-deployer",
+					Namespace: "default",
+					Labels:    map[string]string{"team": "billing"},
+				},
+			}
+			err := k8sClient.Create(context.Background(), sa)
+			Expect(err == nil || errors.IsAlreadyExists(err)).To(BeTrue())
+
+			p1 := newPolicy(policy.PolicyTypeKeyHubSecret, "1357")
+			p1.LabelSelector = "team=a"
+			p2 := newPolicy(policy.PolicyTypeServiceAccount, "0246")
+			p2.Namespace = "default"
+			p2.LabelSelector = "team=billing"
+
+			// The cached client may not have seen the ServiceAccount yet
+			Eventually(func() string {
+				matched, err := resolve(newKeyHubSecret(map[string]string{"team": "a"}, nil, "billing-deployer"), p1, p2)
+				if err != nil {
+					return err.Error()
+				}
+				return matched.Credentials.ClientID
+			}, 10, 1).Should(Equal("0246"))
+
+			By("By ignoring serviceaccount policies for a missing ServiceAccount")
+			matched, err := resolve(newKeyHubSecret(map[string]string{"team": "a"}, nil, "missing"), p1, p2)
+			Expect(err).To(BeNil())
+			Expect(matched.Credentials.ClientID).To(Equal("1357"))
+		})
+	})
+})
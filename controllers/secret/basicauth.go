@@ -7,11 +7,11 @@ import (
 	"fmt"
 
 	"github.com/topicuskeyhub/keyhub-vault-operator/api"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 )
 
-func (sb *secretBuilder) applyBasicAuthSecretData(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) error {
+func (sb *secretBuilder) applyBasicAuthSecretData(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error {
 	if len(ks.Spec.Data) != 1 {
 		return fmt.Errorf("Expected one key for basic authentication, found %d", len(ks.Spec.Data))
 	}
@@ -20,12 +20,12 @@ func (sb *secretBuilder) applyBasicAuthSecretData(ks *keyhubv1alpha1.KeyHubSecre
 
 	idxEntry, ok := sb.records[ref.Record]
 	if !ok {
-		return fmt.Errorf("Record %s not found for key %s", ref.Record, ref.Name)
+		return fmt.Errorf("Record %s not found for key %s", recordDescription(ref), ref.Name)
 	}
 
 	// Check whether or not the Secret needs updating
 	recordChanged := api.IsVaulRecordChanged(ks.Status.VaultRecordStatuses, &idxEntry.Record)
-	secretDataChanged := api.IsSecretKeyChanged(ks.Status.SecretKeyStatuses, secret.Data, corev1.BasicAuthUsernameKey)
+	secretDataChanged := sb.force || api.IsSecretKeyChanged(sb.hasher, ks.Status.SecretKeyStatuses, secret.Data, corev1.BasicAuthUsernameKey)
 	if !recordChanged && !secretDataChanged {
 		return nil
 	}
@@ -49,19 +49,11 @@ func (sb *secretBuilder) applyBasicAuthSecretData(ks *keyhubv1alpha1.KeyHubSecre
 		corev1.BasicAuthPasswordKey: []byte(*record.Password()),
 	}
 
-	ks.Status.VaultRecordStatuses = []keyhubv1alpha1.VaultRecordStatus{}
-	ks.Status.SecretKeyStatuses = []keyhubv1alpha1.SecretKeyStatus{}
+	ks.Status.VaultRecordStatuses = []keyhubv1beta1.VaultRecordStatus{}
+	ks.Status.SecretKeyStatuses = []keyhubv1beta1.SecretKeyStatus{}
 	api.SetVaultRecordStatus(&ks.Status.VaultRecordStatuses, record)
-	err = api.SetSecretKeyStatus(&ks.Status.SecretKeyStatuses, corev1.BasicAuthUsernameKey, secret.Data[corev1.BasicAuthUsernameKey])
-	if err != nil {
-		// event + err @ end
-		return err
-	}
-	err = api.SetSecretKeyStatus(&ks.Status.SecretKeyStatuses, corev1.BasicAuthPasswordKey, secret.Data[corev1.BasicAuthPasswordKey])
-	if err != nil {
-		// event + err @ end
-		return err
-	}
+	api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, corev1.BasicAuthUsernameKey, secret.Data[corev1.BasicAuthUsernameKey])
+	api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, corev1.BasicAuthPasswordKey, secret.Data[corev1.BasicAuthPasswordKey])
 
 	return nil
 }
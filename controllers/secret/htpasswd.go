@@ -8,14 +8,14 @@ import (
 	"fmt"
 
 	"github.com/topicuskeyhub/keyhub-vault-operator/api"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
 	"golang.org/x/crypto/bcrypt"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
-func (sb *secretBuilder) applyApachePasswordFile(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) error {
+func (sb *secretBuilder) applyApachePasswordFile(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error {
 	if secret.Data == nil {
 		secret.Data = make(map[string][]byte)
 	}
@@ -32,7 +32,7 @@ func (sb *secretBuilder) applyApachePasswordFile(ks *keyhubv1alpha1.KeyHubSecret
 		if !found {
 			sb.log.Info("Missing KeyHub vault record", "keyhubsecret", name.String(), "key", ref.Name, "record", ref.Record)
 			// event?
-			return fmt.Errorf("Missing KeyHub vault record '%s'", ref.Record)
+			return fmt.Errorf("Missing KeyHub vault record '%s'", recordDescription(ref))
 		}
 
 		// Check whether or not the Secret needs updating
@@ -44,15 +44,15 @@ func (sb *secretBuilder) applyApachePasswordFile(ks *keyhubv1alpha1.KeyHubSecret
 	}
 
 	// FIXME: 'users' is the traefik key, get it from the key name
-	secretDataChanged :=
-		api.IsSecretKeyChanged(ks.Status.SecretKeyStatuses, secret.Data, "users")
+	secretDataChanged := sb.force ||
+		api.IsSecretKeyChanged(sb.hasher, ks.Status.SecretKeyStatuses, secret.Data, "users")
 
 	if !needsUpdating && !secretDataChanged {
 		return nil
 	}
 
-	ks.Status.VaultRecordStatuses = []keyhubv1alpha1.VaultRecordStatus{}
-	ks.Status.SecretKeyStatuses = []keyhubv1alpha1.SecretKeyStatus{}
+	ks.Status.VaultRecordStatuses = []keyhubv1beta1.VaultRecordStatus{}
+	ks.Status.SecretKeyStatuses = []keyhubv1beta1.SecretKeyStatus{}
 
 	var credentials bytes.Buffer
 	for _, idxEntry := range idxEntries {
@@ -87,11 +87,7 @@ func (sb *secretBuilder) applyApachePasswordFile(ks *keyhubv1alpha1.KeyHubSecret
 		"users": credentials.Bytes(),
 	}
 
-	err := api.SetSecretKeyStatus(&ks.Status.SecretKeyStatuses, "users", secret.Data["users"])
-	if err != nil {
-		// event + err @ end
-		return err
-	}
+	api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, "users", secret.Data["users"])
 
 	return nil
 }
@@ -0,0 +1,80 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package secret
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/topicuskeyhub/keyhub-vault-operator/api"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
+)
+
+var invalidKeyChars = regexp.MustCompile(`[^-._a-zA-Z0-9]+`)
+
+// importRecords adds a key to spec.data for every record matched by
+// spec.dataFrom. Like resolved recordRefs, the imported keys only live in
+// memory. Keys of spec.data take precedence over imported keys.
+func (sb *secretBuilder) importRecords(ks *keyhubv1beta1.KeyHubSecret) {
+	if len(ks.Spec.DataFrom) == 0 {
+		return
+	}
+
+	keys := make(map[string]struct{})
+	for _, ref := range ks.Spec.Data {
+		keys[ref.Name] = struct{}{}
+	}
+	for key := range ks.Spec.Template.Data {
+		keys[key] = struct{}{}
+	}
+
+	var imported []keyhubv1beta1.SecretKeyReference
+	for _, from := range ks.Spec.DataFrom {
+		importRef := keyhubv1beta1.SecretKeyReference{Name: from.Group}
+
+		var rewrite *regexp.Regexp
+		if from.Rewrite != nil {
+			var err error
+			if rewrite, err = regexp.Compile(from.Rewrite.Regex); err != nil {
+				api.AddSecretKeyError(&ks.Status.SecretKeyErrors, importRef, keyhubv1beta1.ImportFailed, fmt.Sprintf("Invalid rewrite regular expression '%s': %s", from.Rewrite.Regex, err))
+				continue
+			}
+		}
+
+		matches, err := vault.MatchRecords(sb.records, from.Group, from.NamePattern, from.Color)
+		if err != nil {
+			api.AddSecretKeyError(&ks.Status.SecretKeyErrors, importRef, keyhubv1beta1.ImportFailed, err.Error())
+			continue
+		}
+		if len(matches) == 0 {
+			sb.log.Info("No KeyHub vault records to import", "group", from.Group, "namePattern", from.NamePattern, "color", from.Color)
+		}
+
+		for _, idxEntry := range matches {
+			ref := keyhubv1beta1.SecretKeyReference{
+				Name:     importKey(idxEntry.Record.Name, rewrite, from.Rewrite),
+				Record:   idxEntry.Record.UUID,
+				Property: from.Property,
+			}
+			if _, found := keys[ref.Name]; found {
+				api.AddSecretKeyError(&ks.Status.SecretKeyErrors, ref, keyhubv1beta1.ImportFailed, fmt.Sprintf("Key '%s' of record '%s' is already defined", ref.Name, idxEntry.Record.Name))
+				continue
+			}
+			keys[ref.Name] = struct{}{}
+			imported = append(imported, ref)
+		}
+	}
+
+	ks.Spec.Data = append(ks.Spec.Data, imported...)
+}
+
+// importKey derives a Secret key from the name of a record
+func importKey(recordName string, re *regexp.Regexp, rewrite *keyhubv1beta1.KeyRewrite) string {
+	key := recordName
+	if re != nil {
+		key = re.ReplaceAllString(key, rewrite.Replacement)
+	}
+	return invalidKeyChars.ReplaceAllString(key, "_")
+}
@@ -4,17 +4,18 @@
 package secret
 
 import (
+	"fmt"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/topicuskeyhub/keyhub-vault-operator/api"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 )
 
-func (sb *secretBuilder) applyOpaqueSecretData(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) error {
+func (sb *secretBuilder) applyOpaqueSecretData(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error {
 	if secret.Data == nil {
 		secret.Data = make(map[string][]byte)
 	}
@@ -37,6 +38,9 @@ func (sb *secretBuilder) applyOpaqueSecretData(ks *keyhubv1alpha1.KeyHubSecret,
 		delete(keysToRemove, ref.Name)
 		delete(recordStatusesToRemove, ref.Record)
 	}
+	for key := range ks.Spec.Template.Data {
+		delete(keysToRemove, key)
+	}
 	for key := range keysToRemove {
 		delete(secret.Data, key)
 	}
@@ -48,26 +52,25 @@ func (sb *secretBuilder) applyOpaqueSecretData(ks *keyhubv1alpha1.KeyHubSecret,
 	for _, ref := range ks.Spec.Data {
 		idxEntry, found := sb.records[ref.Record]
 		if !found {
+			// Reported by resolveRecords
 			sb.log.Info("Missing KeyHub vault record", "keyhubsecret", name.String(), "key", ref.Name, "record", ref.Record)
-			// event?
 			continue
 		}
 
 		// Check whether or not the Secret needs updating
 		recordChanged := api.IsVaulRecordChanged(ks.Status.VaultRecordStatuses, &idxEntry.Record)
-		secretDataChanged :=
-			api.IsSecretKeyChanged(ks.Status.SecretKeyStatuses, secret.Data, ref.Name)
+		secretDataChanged := sb.force ||
+			api.IsSecretKeyChanged(sb.hasher, ks.Status.SecretKeyStatuses, secret.Data, ref.Name)
 		if !recordChanged && !secretDataChanged {
 			continue
 		}
 
 		sb.log.Info("Syncing KeyHub vault record", "keyhubsecret", name.String(), "record", idxEntry.Record.UUID)
 
-		record, err := sb.retriever.Get(idxEntry)
+		record, err := sb.getRecord(idxEntry)
 		if err != nil {
-			// event + err @ end
+			api.AddSecretKeyError(&ks.Status.SecretKeyErrors, ref, keyhubv1beta1.RecordRetrievalFailed, err.Error())
 			continue
-			// return err
 		}
 
 		if ref.Property == "username" {
@@ -78,10 +81,12 @@ func (sb *secretBuilder) applyOpaqueSecretData(ks *keyhubv1alpha1.KeyHubSecret,
 				pwd = []byte(*record.Password())
 			}
 			if ref.Format == "bcrypt" {
-				secret.Data[ref.Name], err = bcrypt.GenerateFromPassword(pwd, bcrypt.DefaultCost)
+				hash, err := bcrypt.GenerateFromPassword(pwd, bcrypt.DefaultCost)
 				if err != nil {
+					api.AddSecretKeyError(&ks.Status.SecretKeyErrors, ref, keyhubv1beta1.FormatFailed, err.Error())
 					continue
 				}
+				secret.Data[ref.Name] = hash
 			} else {
 				secret.Data[ref.Name] = pwd
 			}
@@ -96,17 +101,13 @@ func (sb *secretBuilder) applyOpaqueSecretData(ks *keyhubv1alpha1.KeyHubSecret,
 		} else if ref.Property == "lastModifiedAt" {
 			secret.Data[ref.Name] = []byte(record.LastModifiedAt().UTC().Format(time.RFC3339))
 		} else {
-			// TODO: report to crd status, just skipping the key, no error
 			sb.log.Info("Unsupported property", "property", ref.Property)
+			api.AddSecretKeyError(&ks.Status.SecretKeyErrors, ref, keyhubv1beta1.UnsupportedProperty, fmt.Sprintf("Unsupported property '%s'", ref.Property))
 			continue
 		}
 
 		api.SetVaultRecordStatus(&ks.Status.VaultRecordStatuses, record)
-		err = api.SetSecretKeyStatus(&ks.Status.SecretKeyStatuses, ref.Name, secret.Data[ref.Name])
-		if err != nil {
-			// event + err @ end
-			continue
-		}
+		api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, ref.Name, secret.Data[ref.Name])
 	}
 
 	return nil
@@ -4,15 +4,26 @@
 package secret
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
 	"github.com/go-logr/logr"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+	"github.com/topicuskeyhub/keyhub-vault-operator/api"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type SecretBuilder interface {
-	Build(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) error
+	Build(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error
+	Validate(ks *keyhubv1beta1.KeyHubSecret)
 }
 
 type secretBuilder struct {
@@ -20,18 +31,58 @@ type secretBuilder struct {
 	log       logr.Logger
 	records   map[string]vault.VaultRecordWithGroup
 	retriever vault.VaultSecretRetriever
+	hasher    *api.SecretKeyHasher
+	force     bool
+	fetched   map[string]*keyhubmodel.VaultRecord
 }
 
-func NewSecretBuilder(client client.Client, log logr.Logger, records map[string]vault.VaultRecordWithGroup, retriever vault.VaultSecretRetriever) SecretBuilder {
+func NewSecretBuilder(client client.Client, log logr.Logger, records map[string]vault.VaultRecordWithGroup, retriever vault.VaultSecretRetriever, hasher *api.SecretKeyHasher, force bool) SecretBuilder {
 	return &secretBuilder{
 		client:    client,
 		log:       log,
 		records:   records,
 		retriever: retriever,
+		hasher:    hasher,
+		force:     force,
+		fetched:   make(map[string]*keyhubmodel.VaultRecord),
+	}
+}
+
+// getRecord retrieves a KeyHub vault record once per build, it is shared by
+// the keys of spec.data and spec.template.data
+func (sb *secretBuilder) getRecord(idxEntry vault.VaultRecordWithGroup) (*keyhubmodel.VaultRecord, error) {
+	if record, found := sb.fetched[idxEntry.Record.UUID]; found {
+		return record, nil
 	}
+
+	record, err := sb.retriever.Get(idxEntry)
+	if err != nil {
+		return nil, err
+	}
+	sb.fetched[idxEntry.Record.UUID] = record
+	return record, nil
 }
 
-func (sb *secretBuilder) Build(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) error {
+// isAnyRecordChanged reports whether any record of spec.data changed since it
+// was last synced
+func (sb *secretBuilder) isAnyRecordChanged(ks *keyhubv1beta1.KeyHubSecret) bool {
+	for _, ref := range ks.Spec.Data {
+		if idxEntry, found := sb.records[ref.Record]; found && api.IsVaulRecordChanged(ks.Status.VaultRecordStatuses, &idxEntry.Record) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sb *secretBuilder) Build(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error {
+	ks.Status.SecretKeyErrors = nil
+	sb.resolveRecords(ks)
+	api.MigrateSecretKeyStatuses(sb.hasher, ks.Status.SecretKeyStatuses, secret.Data)
+
+	if ks.Spec.Target.CreationPolicy == keyhubv1beta1.CreationPolicyMerge {
+		return sb.mergeSecretData(ks, secret)
+	}
+
 	// Apply labels and annotations
 	sb.applyLabels(ks, secret)
 	sb.applyAnnotations(ks, secret)
@@ -41,33 +92,178 @@ func (sb *secretBuilder) Build(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.S
 	if secret.Type == "" {
 		secret.Type = corev1.SecretTypeOpaque
 	}
+	if len(ks.Spec.Template.Data) > 0 && secret.Type != corev1.SecretTypeOpaque {
+		return fmt.Errorf("Template data is not supported for Secret type '%s'", secret.Type)
+	}
+	if len(ks.Spec.DataFrom) > 0 && secret.Type != corev1.SecretTypeOpaque {
+		return fmt.Errorf("Data from is not supported for Secret type '%s'", secret.Type)
+	}
+	var err error
 	switch secret.Type {
 	case corev1.SecretTypeBasicAuth:
-		return sb.applyBasicAuthSecretData(ks, secret)
+		err = sb.applyBasicAuthSecretData(ks, secret)
 	case corev1.SecretTypeSSHAuth:
-		return sb.applySSHAuthSecretData(ks, secret)
+		err = sb.applySSHAuthSecretData(ks, secret)
 	case corev1.SecretTypeTLS:
-		return sb.applyTLSSecretData(ks, secret)
-	case keyhubv1alpha1.SecretTypeApachePasswordFile:
-		return sb.applyApachePasswordFile(ks, secret)
+		err = sb.applyTLSSecretData(ks, secret)
+	case keyhubv1beta1.SecretTypeApachePasswordFile:
+		err = sb.applyApachePasswordFile(ks, secret)
 	default:
-		return sb.applyOpaqueSecretData(ks, secret)
+		recordsChanged := sb.isAnyRecordChanged(ks)
+		err = sb.applyOpaqueSecretData(ks, secret)
+		sb.applyTemplateData(ks, secret, recordsChanged)
+	}
+	if err != nil {
+		return err
+	}
+
+	if ks.Spec.Template.Immutable {
+		sb.applyImmutable(ks, secret)
+	}
+
+	return nil
+}
+
+// TargetName returns the name of the Secret of ks, immutable Secrets are
+// named after it with a content hash as suffix
+func TargetName(ks *keyhubv1beta1.KeyHubSecret) string {
+	if ks.Spec.Target.Name != "" {
+		return ks.Spec.Target.Name
+	}
+	return ks.Name
+}
+
+// applyImmutable marks the Secret immutable and names it after its content,
+// so every change of the content results in a new Secret
+func (sb *secretBuilder) applyImmutable(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) {
+	immutable := true
+	secret.Immutable = &immutable
+	secret.Name = fmt.Sprintf("%s-%s", TargetName(ks), contentHash(secret))
+}
+
+// contentHash returns a short hash of the type and data of the Secret
+func contentHash(secret *corev1.Secret) string {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	h.Write([]byte(secret.Type))
+	for _, key := range keys {
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write(secret.Data[key])
 	}
+
+	return hex.EncodeToString(h.Sum(nil))[:10]
+}
+
+// Validate reports the keys referencing records missing from the vault index,
+// without building the Secret
+func (sb *secretBuilder) Validate(ks *keyhubv1beta1.KeyHubSecret) {
+	ks.Status.SecretKeyErrors = nil
+	sb.resolveRecords(ks)
 }
 
-func (sb *secretBuilder) applyLabels(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) {
+// mergeSecretData syncs the keys of spec.data into a Secret that is not owned
+// by the KeyHubSecret, its type, standard labels and other keys are left as is
+func (sb *secretBuilder) mergeSecretData(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error {
+	if ks.Spec.Template.Type != "" {
+		return fmt.Errorf("Secret type '%s' is not supported with creation policy Merge", ks.Spec.Template.Type)
+	}
+	if ks.Spec.Template.Immutable {
+		return fmt.Errorf("Immutable Secrets are not supported with creation policy Merge")
+	}
+
+	sb.applyTemplateLabels(ks, secret)
+	sb.applyAnnotations(ks, secret)
+
+	recordsChanged := sb.isAnyRecordChanged(ks)
+	if err := sb.applyOpaqueSecretData(ks, secret); err != nil {
+		return err
+	}
+	sb.applyTemplateData(ks, secret, recordsChanged)
+	return nil
+}
+
+// resolveRecords resolves the recordRefs of spec.data to the UUID of the
+// referenced record and reports the keys referencing records missing from the
+// vault index. The records of spec.dataFrom are imported first. The resolved
+// UUIDs only live in memory, the spec of the KeyHubSecret is never written
+// back.
+func (sb *secretBuilder) resolveRecords(ks *keyhubv1beta1.KeyHubSecret) {
+	sb.importRecords(ks)
+
+	var missing []string
+	for i := range ks.Spec.Data {
+		ref := &ks.Spec.Data[i]
+		if ref.RecordRef != nil {
+			if ref.Record != "" {
+				missing = append(missing, recordDescription(*ref))
+				api.AddSecretKeyError(&ks.Status.SecretKeyErrors, *ref, keyhubv1beta1.RecordAmbiguous, "Only one of record and recordRef can be set")
+				ref.Record = ""
+				continue
+			}
+
+			idxEntry, err := vault.ResolveRecord(sb.records, ref.RecordRef.Group, ref.RecordRef.Name, ref.RecordRef.Regex)
+			if err != nil {
+				missing = append(missing, recordDescription(*ref))
+				reason := keyhubv1beta1.RecordNotFound
+				if errors.Is(err, vault.ErrRecordAmbiguous) {
+					reason = keyhubv1beta1.RecordAmbiguous
+				}
+				api.AddSecretKeyError(&ks.Status.SecretKeyErrors, *ref, reason, err.Error())
+				continue
+			}
+			ref.Record = idxEntry.Record.UUID
+		}
+
+		if _, found := sb.records[ref.Record]; !found {
+			missing = append(missing, recordDescription(*ref))
+			api.AddSecretKeyError(&ks.Status.SecretKeyErrors, *ref, keyhubv1beta1.RecordNotFound, fmt.Sprintf("Record %s not found", recordDescription(*ref)))
+		}
+	}
+
+	if len(missing) > 0 {
+		api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeRecordsResolved, metav1.ConditionFalse, keyhubv1beta1.RecordNotFound,
+			fmt.Sprintf("KeyHub vault record(s) not found: %s", strings.Join(missing, ", ")))
+		return
+	}
+	api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeRecordsResolved, metav1.ConditionTrue, keyhubv1beta1.RecordsResolved,
+		"All KeyHub vault records found")
+}
+
+// recordDescription describes the record referenced by ref for messages
+func recordDescription(ref keyhubv1beta1.SecretKeyReference) string {
+	if ref.RecordRef != nil && ref.Record == "" {
+		return fmt.Sprintf("%s/%s", ref.RecordRef.Group, ref.RecordRef.Name)
+	}
+	return ref.Record
+}
+
+func (sb *secretBuilder) applyLabels(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) {
 	if secret.GetLabels() == nil {
 		secret.SetLabels(make(map[string]string))
 	}
 
 	sb.applyStandardLabels(ks, secret)
+	sb.applyTemplateLabels(ks, secret)
+}
+
+func (sb *secretBuilder) applyTemplateLabels(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) {
+	if secret.GetLabels() == nil {
+		secret.SetLabels(make(map[string]string))
+	}
 
 	for label, value := range ks.Spec.Template.Metadata.Labels {
 		secret.GetLabels()[label] = value
 	}
 }
 
-func (sb *secretBuilder) applyStandardLabels(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) {
+func (sb *secretBuilder) applyStandardLabels(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) {
 	for label, value := range ks.GetLabels() {
 		switch label {
 		case "app.kubernetes.io/name":
@@ -88,7 +284,7 @@ func (sb *secretBuilder) applyStandardLabels(ks *keyhubv1alpha1.KeyHubSecret, se
 	}
 }
 
-func (sb *secretBuilder) applyAnnotations(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) {
+func (sb *secretBuilder) applyAnnotations(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) {
 	if secret.GetAnnotations() == nil {
 		secret.SetAnnotations(make(map[string]string))
 	}
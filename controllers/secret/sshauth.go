@@ -7,11 +7,11 @@ import (
 	"fmt"
 
 	"github.com/topicuskeyhub/keyhub-vault-operator/api"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 )
 
-func (sb *secretBuilder) applySSHAuthSecretData(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) error {
+func (sb *secretBuilder) applySSHAuthSecretData(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error {
 	if len(ks.Spec.Data) != 1 {
 		return fmt.Errorf("Expected one key for SSH authentication, found %d", len(ks.Spec.Data))
 	}
@@ -23,12 +23,12 @@ func (sb *secretBuilder) applySSHAuthSecretData(ks *keyhubv1alpha1.KeyHubSecret,
 
 	idxEntry, ok := sb.records[ref.Record]
 	if !ok {
-		return fmt.Errorf("Record %s not found for key %s", ref.Record, ref.Name)
+		return fmt.Errorf("Record %s not found for key %s", recordDescription(ref), ref.Name)
 	}
 
 	// Check whether or not the Secret needs updating
 	recordChanged := api.IsVaulRecordChanged(ks.Status.VaultRecordStatuses, &idxEntry.Record)
-	secretDataChanged := api.IsSecretKeyChanged(ks.Status.SecretKeyStatuses, secret.Data, corev1.SSHAuthPrivateKey)
+	secretDataChanged := sb.force || api.IsSecretKeyChanged(sb.hasher, ks.Status.SecretKeyStatuses, secret.Data, corev1.SSHAuthPrivateKey)
 	if !recordChanged && !secretDataChanged {
 		return nil
 	}
@@ -47,14 +47,10 @@ func (sb *secretBuilder) applySSHAuthSecretData(ks *keyhubv1alpha1.KeyHubSecret,
 		corev1.SSHAuthPrivateKey: *record.File(),
 	}
 
-	ks.Status.VaultRecordStatuses = []keyhubv1alpha1.VaultRecordStatus{}
-	ks.Status.SecretKeyStatuses = []keyhubv1alpha1.SecretKeyStatus{}
+	ks.Status.VaultRecordStatuses = []keyhubv1beta1.VaultRecordStatus{}
+	ks.Status.SecretKeyStatuses = []keyhubv1beta1.SecretKeyStatus{}
 	api.SetVaultRecordStatus(&ks.Status.VaultRecordStatuses, record)
-	err = api.SetSecretKeyStatus(&ks.Status.SecretKeyStatuses, corev1.SSHAuthPrivateKey, secret.Data[corev1.SSHAuthPrivateKey])
-	if err != nil {
-		// event + err @ end
-		return err
-	}
+	api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, corev1.SSHAuthPrivateKey, secret.Data[corev1.SSHAuthPrivateKey])
 
 	return nil
 }
@@ -0,0 +1,181 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+	"github.com/topicuskeyhub/keyhub-vault-operator/api"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// templateFuncs are the helpers available in spec.template.data, next to the
+// builtin functions of text/template like urlquery and printf
+var templateFuncs = template.FuncMap{
+	"base64":  func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64enc":  func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"b64dec":  b64dec,
+	"toJson":  toJSON,
+	"toYaml":  toYAML,
+	"quote":   func(s string) string { return fmt.Sprintf("%q", s) },
+	"squote":  func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" },
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"trim":    strings.TrimSpace,
+	"replace": func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"indent":  indent,
+	"nindent": func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+	"default": func(def, s string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	},
+}
+
+func b64dec(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+func toYAML(v interface{}) (string, error) {
+	encoded, err := yaml.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(encoded), "\n"), nil
+}
+
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// templateRecord exposes the properties of a KeyHub vault record to the
+// templates, named like the properties of spec.data
+func templateRecord(record *keyhubmodel.VaultRecord) map[string]string {
+	values := map[string]string{
+		"uuid":           record.UUID,
+		"name":           record.Name,
+		"username":       record.Username,
+		"password":       "",
+		"link":           record.URL,
+		"file":           "",
+		"lastModifiedAt": record.LastModifiedAt().UTC().Format(time.RFC3339),
+	}
+	if record.Password() != nil {
+		values["password"] = *record.Password()
+	}
+	if record.File() != nil {
+		values["file"] = string(*record.File())
+	}
+	return values
+}
+
+// renderTemplate renders text over values, missing records or properties are
+// reported as an error
+func renderTemplate(key string, text string, values map[string]map[string]string) ([]byte, error) {
+	tmpl, err := template.New(key).Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// applyTemplateData renders the keys of spec.template.data over the KeyHub
+// vault records of spec.data. The records are only fetched when a record,
+// template or rendered key changed.
+func (sb *secretBuilder) applyTemplateData(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret, recordsChanged bool) {
+	if len(ks.Spec.Template.Data) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(ks.Spec.Template.Data))
+	for key := range ks.Spec.Template.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dataKeys := make(map[string]struct{})
+	for _, ref := range ks.Spec.Data {
+		dataKeys[ref.Name] = struct{}{}
+	}
+
+	var values map[string]map[string]string
+	for _, key := range keys {
+		text := ks.Spec.Template.Data[key]
+		ref := keyhubv1beta1.SecretKeyReference{Name: key}
+
+		if _, found := dataKeys[key]; found {
+			api.AddSecretKeyError(&ks.Status.SecretKeyErrors, ref, keyhubv1beta1.TemplateFailed, fmt.Sprintf("Key '%s' is defined in both data and template data", key))
+			continue
+		}
+
+		status := api.FindSecretKeyStatus(ks.Status.SecretKeyStatuses, key)
+		templateChanged := status == nil || !sb.hasher.Verify(status.TemplateHash, []byte(text))
+		if !sb.force && !recordsChanged && !templateChanged && !api.IsSecretKeyChanged(sb.hasher, ks.Status.SecretKeyStatuses, secret.Data, key) {
+			continue
+		}
+
+		if values == nil {
+			values = sb.templateValues(ks)
+		}
+
+		rendered, err := renderTemplate(key, text, values)
+		if err != nil {
+			api.AddSecretKeyError(&ks.Status.SecretKeyErrors, ref, keyhubv1beta1.TemplateFailed, err.Error())
+			continue
+		}
+
+		secret.Data[key] = rendered
+		api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, key, rendered)
+		api.FindSecretKeyStatus(ks.Status.SecretKeyStatuses, key).TemplateHash = sb.hasher.Sum([]byte(text))
+	}
+}
+
+// templateValues fetches the records of spec.data, keyed by the name of the
+// key referencing them. Records that could not be fetched are left out.
+func (sb *secretBuilder) templateValues(ks *keyhubv1beta1.KeyHubSecret) map[string]map[string]string {
+	values := make(map[string]map[string]string)
+	for _, ref := range ks.Spec.Data {
+		idxEntry, found := sb.records[ref.Record]
+		if !found {
+			continue
+		}
+
+		record, err := sb.getRecord(idxEntry)
+		if err != nil {
+			sb.log.Info("Failed to retrieve KeyHub vault record for template", "record", ref.Record, "error", err.Error())
+			continue
+		}
+		values[ref.Name] = templateRecord(record)
+	}
+	return values
+}
@@ -4,11 +4,14 @@
 package secret
 
 import (
+	"crypto"
 	"crypto/dsa"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/sha1"
 	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"strconv"
 	"strings"
@@ -19,10 +22,10 @@ import (
 
 	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
 	"github.com/topicuskeyhub/keyhub-vault-operator/api"
-	"github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 
 	certUtil "k8s.io/client-go/util/cert"
@@ -33,7 +36,18 @@ const (
 	TLSCAKey = "ca.crt"
 )
 
-func (sb *secretBuilder) applyTLSSecretData(ks *keyhubv1alpha1.KeyHubSecret, secret *corev1.Secret) error {
+// CertificateValidationError is returned when the private key, certificate
+// and CA certificates of a TLS secret don't belong together.
+type CertificateValidationError struct {
+	Reason  keyhubv1beta1.KeyHubSecretConditionReason
+	Message string
+}
+
+func (e *CertificateValidationError) Error() string {
+	return e.Message
+}
+
+func (sb *secretBuilder) applyTLSSecretData(ks *keyhubv1beta1.KeyHubSecret, secret *corev1.Secret) error {
 	if len(ks.Spec.Data) < 1 || len(ks.Spec.Data) > 3 {
 		return fmt.Errorf("Unexpected number of keys for TLS secret, found %d keys", len(ks.Spec.Data))
 	}
@@ -48,6 +62,7 @@ func (sb *secretBuilder) applyTLSSecretData(ks *keyhubv1alpha1.KeyHubSecret, sec
 		Namespace: ks.Namespace,
 	}
 
+	vaultRecordStatuses := ks.Status.VaultRecordStatuses
 	if len(ks.Spec.Data) == 1 {
 		privateKey, certificate, caCerts, err = sb.loadCertificateBundle(name, &ks.Status, ks.Spec.Data[0], secret.Data)
 	} else {
@@ -61,12 +76,27 @@ func (sb *secretBuilder) applyTLSSecretData(ks *keyhubv1alpha1.KeyHubSecret, sec
 		return nil
 	}
 
+	if err := validateCertificate(privateKey, certificate, caCerts, time.Now()); err != nil {
+		// Keep the previous record statuses, so the records are retried
+		ks.Status.VaultRecordStatuses = vaultRecordStatuses
+		api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeCertificateValid, metav1.ConditionFalse, err.Reason, err.Message)
+		return err
+	}
+	api.SetCondition(&ks.Status.Conditions, ks.Generation, keyhubv1beta1.TypeCertificateValid, metav1.ConditionTrue, keyhubv1beta1.CertificateValid,
+		fmt.Sprintf("Certificate '%s' is valid until %s", certificate.Subject.CommonName, certificate.NotAfter.UTC().Format(time.RFC3339)))
+
+	ks.Status.Certificate = &keyhubv1beta1.CertificateStatus{
+		RecordID:   certificateRecordID(ks.Spec.Data),
+		CommonName: certificate.Subject.CommonName,
+		NotAfter:   metav1.NewTime(certificate.NotAfter),
+	}
+
 	certBytes, err := certUtil.EncodeCertificates(append([]*x509.Certificate{certificate}, caCerts...)...)
 	if err != nil {
 		return err
 	}
 
-	keyBytes, err := keyUtil.MarshalPrivateKeyToPEM(privateKey.(*rsa.PrivateKey))
+	keyBytes, err := marshalPrivateKeyToPEM(privateKey)
 	if err != nil {
 		return err
 	}
@@ -78,35 +108,27 @@ func (sb *secretBuilder) applyTLSSecretData(ks *keyhubv1alpha1.KeyHubSecret, sec
 
 	sb.applyRancherCertificateAnnotations(certificate, secret)
 
-	err = api.SetSecretKeyStatus(&ks.Status.SecretKeyStatuses, corev1.TLSCertKey, certBytes)
-	if err != nil {
-		// event + err @ end
-		return err
-	}
-	err = api.SetSecretKeyStatus(&ks.Status.SecretKeyStatuses, corev1.TLSPrivateKeyKey, keyBytes)
-	if err != nil {
-		// event + err @ end
-		return err
-	}
+	api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, corev1.TLSCertKey, certBytes)
+	api.SetSecretKeyStatus(sb.hasher, &ks.Status.SecretKeyStatuses, corev1.TLSPrivateKeyKey, keyBytes)
 
 	return nil
 }
 
-func (sb *secretBuilder) loadCertificateBundle(keyhubSecretName types.NamespacedName, status *v1alpha1.KeyHubSecretStatus, ref keyhubv1alpha1.SecretKeyReference, data map[string][]byte) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+func (sb *secretBuilder) loadCertificateBundle(keyhubSecretName types.NamespacedName, status *keyhubv1beta1.KeyHubSecretStatus, ref keyhubv1beta1.SecretKeyReference, data map[string][]byte) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
 	if ref.Name != "pem" && ref.Name != "pkcs12" {
 		return nil, nil, nil, fmt.Errorf("Invalid name '%s', only 'pem' or 'pkcs12' is allowed for single key TLS secret", ref.Name)
 	}
 
 	idxEntry, ok := sb.records[ref.Record]
 	if !ok {
-		return nil, nil, nil, fmt.Errorf("Record %s not found for key %s", ref.Record, ref.Name)
+		return nil, nil, nil, fmt.Errorf("Record %s not found for key %s", recordDescription(ref), ref.Name)
 	}
 
 	// Check whether or not the Secret needs updating
 	recordChanged := api.IsVaulRecordChanged(status.VaultRecordStatuses, &idxEntry.Record)
-	secretDataChanged :=
-		api.IsSecretKeyChanged(status.SecretKeyStatuses, data, corev1.TLSPrivateKeyKey) ||
-			api.IsSecretKeyChanged(status.SecretKeyStatuses, data, corev1.TLSCertKey)
+	secretDataChanged := sb.force ||
+		api.IsSecretKeyChanged(sb.hasher, status.SecretKeyStatuses, data, corev1.TLSPrivateKeyKey) ||
+		api.IsSecretKeyChanged(sb.hasher, status.SecretKeyStatuses, data, corev1.TLSCertKey)
 	if !recordChanged && !secretDataChanged {
 		return nil, nil, nil, nil
 	}
@@ -117,7 +139,7 @@ func (sb *secretBuilder) loadCertificateBundle(keyhubSecretName types.Namespaced
 		return nil, nil, nil, err
 	}
 
-	status.VaultRecordStatuses = []keyhubv1alpha1.VaultRecordStatus{}
+	status.VaultRecordStatuses = []keyhubv1beta1.VaultRecordStatus{}
 	api.SetVaultRecordStatus(&status.VaultRecordStatuses, record)
 
 	if record.File() == nil {
@@ -162,8 +184,8 @@ func (sb *secretBuilder) parsePEM(pemData []byte) (privateKey interface{}, certi
 	return
 }
 
-func (sb *secretBuilder) loadCertificateBlocks(keyhubSecretName types.NamespacedName, status *v1alpha1.KeyHubSecretStatus, refs []keyhubv1alpha1.SecretKeyReference, data map[string][]byte) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
-	var privateKeyRef, certificateRef, caCertsRef keyhubv1alpha1.SecretKeyReference
+func (sb *secretBuilder) loadCertificateBlocks(keyhubSecretName types.NamespacedName, status *keyhubv1beta1.KeyHubSecretStatus, refs []keyhubv1beta1.SecretKeyReference, data map[string][]byte) (privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, err error) {
+	var privateKeyRef, certificateRef, caCertsRef keyhubv1beta1.SecretKeyReference
 	for _, ref := range refs {
 		switch ref.Name {
 		case corev1.TLSPrivateKeyKey:
@@ -184,18 +206,18 @@ func (sb *secretBuilder) loadCertificateBlocks(keyhubSecretName types.Namespaced
 
 	privateKeyIdxEntry, ok := sb.records[privateKeyRef.Record]
 	if !ok {
-		return nil, nil, nil, fmt.Errorf("Record %s not found for privatekey", privateKeyRef.Record)
+		return nil, nil, nil, fmt.Errorf("Record %s not found for privatekey", recordDescription(privateKeyRef))
 	}
 
 	certificateIdxEntry, ok := sb.records[certificateRef.Record]
 	if !ok {
-		return nil, nil, nil, fmt.Errorf("Record %s not found for certificate", certificateRef.Record)
+		return nil, nil, nil, fmt.Errorf("Record %s not found for certificate", recordDescription(certificateRef))
 	}
 
 	var caCertsIdxEntry vault.VaultRecordWithGroup
 	if caCertsRef.Name != "" {
 		if caCertsIdxEntry, ok = sb.records[caCertsRef.Record]; !ok {
-			return nil, nil, nil, fmt.Errorf("Record %s not found for ca certificates", caCertsRef.Record)
+			return nil, nil, nil, fmt.Errorf("Record %s not found for ca certificates", recordDescription(caCertsRef))
 		}
 	}
 
@@ -211,14 +233,14 @@ func (sb *secretBuilder) loadCertificateBlocks(keyhubSecretName types.Namespaced
 		caCertsStatus := api.FindVaultRecordStatus(status.VaultRecordStatuses, caCertsRef.Record)
 		caCertsChanged = caCertsStatus == nil || caCertsIdxEntry.Record.LastModifiedAt().After(caCertsStatus.LastModifiedAt.Time)
 	}
-	secretDataChanged :=
-		api.IsSecretKeyChanged(status.SecretKeyStatuses, data, corev1.TLSPrivateKeyKey) ||
-			api.IsSecretKeyChanged(status.SecretKeyStatuses, data, corev1.TLSCertKey)
+	secretDataChanged := sb.force ||
+		api.IsSecretKeyChanged(sb.hasher, status.SecretKeyStatuses, data, corev1.TLSPrivateKeyKey) ||
+		api.IsSecretKeyChanged(sb.hasher, status.SecretKeyStatuses, data, corev1.TLSCertKey)
 	if !privateKeyChanged && !certificateChanged && !caCertsChanged && !secretDataChanged {
 		return nil, nil, nil, nil
 	}
 
-	status.VaultRecordStatuses = []keyhubv1alpha1.VaultRecordStatus{}
+	status.VaultRecordStatuses = []keyhubv1beta1.VaultRecordStatus{}
 
 	sb.log.Info("Syncing KeyHub vault record(s)", "keyhubsecret", keyhubSecretName.String())
 
@@ -281,6 +303,81 @@ func (sb *secretBuilder) loadCertificateBlocks(keyhubSecretName types.Namespaced
 	return
 }
 
+// certificateRecordID returns the UUID of the record containing the certificate
+func certificateRecordID(refs []keyhubv1beta1.SecretKeyReference) string {
+	if len(refs) == 1 {
+		return refs[0].Record
+	}
+	for _, ref := range refs {
+		if ref.Name == corev1.TLSCertKey {
+			return ref.Record
+		}
+	}
+	return ""
+}
+
+// validateCertificate checks that the certificate belongs to the private key,
+// that each CA certificate signed its predecessor and that the certificate is
+// valid at the given time.
+func validateCertificate(privateKey interface{}, certificate *x509.Certificate, caCerts []*x509.Certificate, now time.Time) *CertificateValidationError {
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return &CertificateValidationError{
+			Reason:  keyhubv1beta1.CertificateKeyMismatch,
+			Message: fmt.Sprintf("Unsupported private key type: %T", privateKey),
+		}
+	}
+	publicKey, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !publicKey.Equal(certificate.PublicKey) {
+		return &CertificateValidationError{
+			Reason:  keyhubv1beta1.CertificateKeyMismatch,
+			Message: fmt.Sprintf("Private key does not match the public key of certificate '%s'", certificate.Subject.CommonName),
+		}
+	}
+
+	chain := append([]*x509.Certificate{certificate}, caCerts...)
+	for i := 1; i < len(chain); i++ {
+		if err := chain[i-1].CheckSignatureFrom(chain[i]); err != nil {
+			return &CertificateValidationError{
+				Reason:  keyhubv1beta1.CertificateChainInvalid,
+				Message: fmt.Sprintf("Certificate '%s' is not signed by CA certificate '%s': %s", chain[i-1].Subject.CommonName, chain[i].Subject.CommonName, err),
+			}
+		}
+	}
+
+	if now.Before(certificate.NotBefore) {
+		return &CertificateValidationError{
+			Reason:  keyhubv1beta1.CertificateNotYetValid,
+			Message: fmt.Sprintf("Certificate '%s' is not valid before %s", certificate.Subject.CommonName, certificate.NotBefore.UTC().Format(time.RFC3339)),
+		}
+	}
+	if now.After(certificate.NotAfter) {
+		return &CertificateValidationError{
+			Reason:  keyhubv1beta1.CertificateExpired,
+			Message: fmt.Sprintf("Certificate '%s' expired at %s", certificate.Subject.CommonName, certificate.NotAfter.UTC().Format(time.RFC3339)),
+		}
+	}
+
+	return nil
+}
+
+// marshalPrivateKeyToPEM encodes RSA keys as PKCS#1 and ECDSA keys as SEC1,
+// other key types (e.g. Ed25519) have no dedicated format and use PKCS#8.
+func marshalPrivateKeyToPEM(privateKey interface{}) ([]byte, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		return keyUtil.MarshalPrivateKeyToPEM(key)
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: keyUtil.PrivateKeyBlockType, Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("Unsupported private key type: %T", privateKey)
+	}
+}
+
 // Rancher 2.0 support
 func (sb *secretBuilder) applyRancherCertificateAnnotations(cert *x509.Certificate, secret *corev1.Secret) {
 	secret.GetAnnotations()["field.cattle.io/algorithm"] = keyAlgorithmLookup[cert.PublicKeyAlgorithm]
@@ -299,7 +396,9 @@ func (sb *secretBuilder) applyRancherCertificateAnnotations(cert *x509.Certifica
 	case *dsa.PublicKey:
 		publicKeySize = pub.Q.BitLen()
 	case *ecdsa.PublicKey:
-		publicKeySize = pub.X.BitLen()
+		publicKeySize = pub.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		publicKeySize = len(pub) * 8
 	}
 	secret.GetAnnotations()["field.cattle.io/keySize"] = strconv.Itoa(publicKeySize)
 
@@ -314,7 +413,8 @@ func (sb *secretBuilder) applyRancherCertificateAnnotations(cert *x509.Certifica
 }
 
 var keyAlgorithmLookup = map[x509.PublicKeyAlgorithm]string{
-	x509.RSA:   "RSA",
-	x509.DSA:   "DSA",
-	x509.ECDSA: "ECDSA",
+	x509.RSA:     "RSA",
+	x509.DSA:     "DSA",
+	x509.ECDSA:   "ECDSA",
+	x509.Ed25519: "Ed25519",
 }
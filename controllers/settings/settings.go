@@ -5,10 +5,12 @@ package settings
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"sync"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -23,6 +25,9 @@ const (
 	settingsURI          = "uri"
 	settingsClientID     = "clientId"
 	settingsClientSecret = "clientSecret"
+	settingsHashKey      = "hashKey"
+
+	hashKeySize = 32
 )
 
 type ControllerSettings struct {
@@ -33,11 +38,14 @@ type ControllerSettings struct {
 
 type SettingsManager interface {
 	GetSettings() (*ControllerSettings, error)
+	GetHashKey() ([]byte, error)
 }
 
 type settingsManager struct {
-	client client.Client
-	log    logr.Logger
+	client  client.Client
+	log     logr.Logger
+	mutex   sync.Mutex
+	hashKey []byte
 }
 
 func CreateSettingsManager(client client.Client, log logr.Logger) SettingsManager {
@@ -65,6 +73,47 @@ func (mgr *settingsManager) GetSettings() (*ControllerSettings, error) {
 	return &settings, nil
 }
 
+// GetHashKey returns the key used to hash the values of the synced Secrets
+// for drift detection. A random key is generated and stored in the operator
+// Secret when it does not contain one yet.
+func (mgr *settingsManager) GetHashKey() ([]byte, error) {
+	mgr.mutex.Lock()
+	defer mgr.mutex.Unlock()
+
+	if mgr.hashKey != nil {
+		return mgr.hashKey, nil
+	}
+
+	operatorNamespace := getOperatorNamespace()
+	key := types.NamespacedName{Namespace: operatorNamespace, Name: controllerSecret}
+	secret := &corev1.Secret{}
+	err := mgr.client.Get(context.TODO(), key, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	hashKey := secret.Data[settingsHashKey]
+	if len(hashKey) == 0 {
+		mgr.log.Info("Generating hash key", "secret", key.String())
+		hashKey = make([]byte, hashKeySize)
+		if _, err := rand.Read(hashKey); err != nil {
+			return nil, fmt.Errorf("Failed to generate hash key: %w", err)
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data[settingsHashKey] = hashKey
+		if err := mgr.client.Update(context.TODO(), secret); err != nil {
+			return nil, fmt.Errorf("Failed to store hash key: %w", err)
+		}
+	}
+
+	mgr.hashKey = hashKey
+
+	return mgr.hashKey, nil
+}
+
 func updateSettingsFromSecret(settings *ControllerSettings, secret *corev1.Secret) error {
 	if uri := secret.Data[settingsURI]; len(uri) > 0 {
 		settings.URI = string(uri)
@@ -0,0 +1,86 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
+)
+
+const keyhubSecretCRDName = "keyhubsecrets.keyhub.topicus.nl"
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=get;update;patch
+
+// StorageVersionMigrator rewrites the KeyHubSecrets stored in a previous API
+// version in the storage version, after which the previous versions are
+// removed from the stored versions of the CRD, so they can be dropped by a
+// later release. A failed migration is retried on the next start.
+type StorageVersionMigrator struct {
+	Client    client.Client
+	APIReader client.Reader
+	Log       logr.Logger
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (m *StorageVersionMigrator) NeedLeaderElection() bool {
+	return true
+}
+
+// Start implements manager.Runnable
+func (m *StorageVersionMigrator) Start(ctx context.Context) error {
+	if err := m.migrate(ctx); err != nil {
+		// The old versions are still served, don't stop the manager
+		m.Log.Error(err, "Failed to migrate the storage version of the KeyHubSecrets")
+	}
+	return nil
+}
+
+func (m *StorageVersionMigrator) migrate(ctx context.Context) error {
+	storageVersion := keyhubv1beta1.GroupVersion.Version
+
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := m.APIReader.Get(ctx, client.ObjectKey{Name: keyhubSecretCRDName}, crd); err != nil {
+		return err
+	}
+	if len(crd.Status.StoredVersions) == 1 && crd.Status.StoredVersions[0] == storageVersion {
+		return nil
+	}
+
+	m.Log.Info("Migrating KeyHubSecrets to the storage version", "storedVersions", crd.Status.StoredVersions, "storageVersion", storageVersion)
+
+	list := &keyhubv1beta1.KeyHubSecretList{}
+	if err := m.APIReader.List(ctx, list); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		key := client.ObjectKeyFromObject(&list.Items[i])
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			ks := &keyhubv1beta1.KeyHubSecret{}
+			if err := m.APIReader.Get(ctx, key, ks); err != nil {
+				return err
+			}
+			// An unchanged update stores the KeyHubSecret in the storage version
+			return m.Client.Update(ctx, ks)
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	crd.Status.StoredVersions = []string{storageVersion}
+	if err := m.Client.Status().Update(ctx, crd); err != nil {
+		return err
+	}
+
+	m.Log.Info("Migrated KeyHubSecrets to the storage version", "count", len(list.Items), "storageVersion", storageVersion)
+	return nil
+}
@@ -28,6 +28,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	. "github.com/onsi/ginkgo/v2"
@@ -46,7 +47,8 @@ import (
 
 	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	"github.com/topicuskeyhub/keyhub-vault-operator/api"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/policy"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/settings"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
@@ -56,13 +58,18 @@ import (
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
 // http://onsi.github.io/ginkgo/ to learn more about Ginkgo.
 
+const testHashKey = "VERY_SECRET_HASH_KEY"
+
 var cfg *rest.Config
 var ctx context.Context
 var ctxCancelFn context.CancelFunc
 var k8sClient client.Client
 var testEnv *envtest.Environment
 var policyEngine policy.PolicyEngine
+var secretKeyHasher = api.NewSecretKeyHasher([]byte(testHashKey))
 var vaultIndexCache vault.VaultIndexCache
+var vaultRecordCache vault.VaultRecordCache
+var keyhubMockServer *httptest.Server
 
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -85,7 +92,7 @@ var _ = BeforeSuite(func() {
 	Expect(err).NotTo(HaveOccurred())
 	Expect(cfg).NotTo(BeNil())
 
-	err = keyhubv1alpha1.AddToScheme(scheme.Scheme)
+	err = keyhubv1beta1.AddToScheme(scheme.Scheme)
 	Expect(err).NotTo(HaveOccurred())
 
 	// +kubebuilder:scaffold:scheme
@@ -108,16 +115,44 @@ var _ = BeforeSuite(func() {
 
 	vaultIndexCache = vault.NewVaultIndexCache(
 		ctrl.Log.WithName("VaultIndexCache"),
+		10*time.Minute,
+		time.Hour,
 	)
 
+	vaultRecordCache, err = vault.NewVaultRecordCache(time.Minute, true)
+	Expect(err).ToNot(HaveOccurred())
+
 	err = (&KeyHubSecretReconciler{
-		Client:          k8sManager.GetClient(),
-		Log:             ctrl.Log.WithName("controllers").WithName("KeyHubSecret"),
-		Scheme:          k8sManager.GetScheme(),
-		Recorder:        k8sManager.GetEventRecorderFor("KeyHubSecret"),
-		SettingsManager: settingsMgr,
-		PolicyEngine:    policyEngine,
-		VaultIndexCache: vaultIndexCache,
+		Client:           k8sManager.GetClient(),
+		Log:              ctrl.Log.WithName("controllers").WithName("KeyHubSecret"),
+		Scheme:           k8sManager.GetScheme(),
+		Recorder:         k8sManager.GetEventRecorderFor("KeyHubSecret"),
+		SettingsManager:  settingsMgr,
+		PolicyEngine:     policyEngine,
+		VaultIndexCache:  vaultIndexCache,
+		VaultRecordCache: vaultRecordCache,
+
+		CertificateExpiryWindow: 30 * 24 * time.Hour,
+		RefreshInterval:         5 * time.Minute,
+		RefreshJitter:           0.1,
+		RetryBaseDelay:          time.Second,
+		RetryMaxDelay:           time.Minute,
+	}).SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&ClusterKeyHubSecretReconciler{
+		Client:   k8sManager.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ClusterKeyHubSecret"),
+		Scheme:   k8sManager.GetScheme(),
+		Recorder: k8sManager.GetEventRecorderFor("ClusterKeyHubSecret"),
+	}).SetupWithManager(k8sManager)
+	Expect(err).ToNot(HaveOccurred())
+
+	err = (&KeyHubPolicyReconciler{
+		Client:       k8sManager.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("KeyHubPolicy"),
+		Recorder:     k8sManager.GetEventRecorderFor("KeyHubPolicy"),
+		PolicyEngine: policyEngine,
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -130,7 +165,7 @@ var _ = BeforeSuite(func() {
 	k8sClient = k8sManager.GetClient()
 	Expect(k8sClient).ToNot(BeNil())
 
-	ts := newKeyHubMockServer()
+	keyhubMockServer = newKeyHubMockServer()
 
 	toCreate := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -138,9 +173,10 @@ var _ = BeforeSuite(func() {
 			Namespace: "default",
 		},
 		Data: map[string][]byte{
-			"uri":          []byte(ts.URL),
+			"uri":          []byte(keyhubMockServer.URL),
 			"clientId":     []byte("CONTROLLER"),
 			"clientSecret": []byte("VERY_SECRET_PHRASE"),
+			"hashKey":      []byte(testHashKey),
 		},
 	}
 
@@ -155,13 +191,16 @@ var _ = BeforeSuite(func() {
 	Eventually(func() bool {
 		k8sClient.Get(context.Background(), key, fetched)
 		return fetched.Type == corev1.SecretTypeOpaque &&
-			len(fetched.Data) == 3
+			len(fetched.Data) == 4
 	}, 10, 1).Should(BeTrue())
 })
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
-	ctxCancelFn()
+	// The manager is not started when the BeforeSuite failed
+	if ctxCancelFn != nil {
+		ctxCancelFn()
+	}
 	err := testEnv.Stop()
 	Expect(err).NotTo(HaveOccurred())
 })
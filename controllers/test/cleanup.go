@@ -5,11 +5,12 @@ package controllers_test
 
 import (
 	"context"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 )
 
 type BeforeEachInputs struct {
@@ -17,11 +18,26 @@ type BeforeEachInputs struct {
 }
 
 func CleanUp(inputs *BeforeEachInputs) {
-	ks := &keyhubv1alpha1.KeyHubSecretList{}
+	cks := &keyhubv1beta1.ClusterKeyHubSecretList{}
+	inputs.Client.List(context.Background(), cks)
+	for _, obj := range cks.Items {
+		inputs.Client.Delete(context.Background(), &obj)
+	}
+	kp := &keyhubv1beta1.KeyHubPolicyList{}
+	inputs.Client.List(context.Background(), kp)
+	for _, obj := range kp.Items {
+		inputs.Client.Delete(context.Background(), &obj)
+	}
+	ks := &keyhubv1beta1.KeyHubSecretList{}
 	inputs.Client.List(context.Background(), ks)
 	for _, obj := range ks.Items {
 		inputs.Client.Delete(context.Background(), &obj)
 	}
+	// Wait for the finalizers to complete, before reusing the names
+	for i := 0; i < 100 && len(ks.Items) > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+		inputs.Client.List(context.Background(), ks)
+	}
 	s := &corev1.SecretList{}
 	inputs.Client.List(context.Background(), s)
 	for _, obj := range s.Items {
@@ -0,0 +1,79 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"sort"
+	"time"
+
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+)
+
+// VaultIndexDiff lists the records that changed between two builds of the
+// index of a client, sorted by UUID
+type VaultIndexDiff struct {
+	ClientID string
+	Added    []VaultRecordWithGroup
+	Removed  []VaultRecordWithGroup
+	// Modified records have a newer lastModifiedAt, or moved or were renamed
+	Modified []VaultRecordWithGroup
+}
+
+// IsEmpty reports whether no records changed
+func (d *VaultIndexDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// RecordUUIDs returns the UUIDs of all changed records
+func (d *VaultIndexDiff) RecordUUIDs() []string {
+	uuids := make([]string, 0, len(d.Added)+len(d.Removed)+len(d.Modified))
+	for _, records := range [][]VaultRecordWithGroup{d.Added, d.Removed, d.Modified} {
+		for _, idxEntry := range records {
+			uuids = append(uuids, idxEntry.Record.UUID)
+		}
+	}
+	return uuids
+}
+
+// diffIndex compares the current index of a client with the previous one
+func diffIndex(clientID string, previous map[string]VaultRecordWithGroup, current map[string]VaultRecordWithGroup) *VaultIndexDiff {
+	diff := &VaultIndexDiff{ClientID: clientID}
+	for uuid, idxEntry := range current {
+		prev, found := previous[uuid]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, idxEntry)
+		case isRecordModified(prev, idxEntry):
+			diff.Modified = append(diff.Modified, idxEntry)
+		}
+	}
+	for uuid, idxEntry := range previous {
+		if _, found := current[uuid]; !found {
+			diff.Removed = append(diff.Removed, idxEntry)
+		}
+	}
+
+	for _, records := range [][]VaultRecordWithGroup{diff.Added, diff.Removed, diff.Modified} {
+		sort.Slice(records, func(i, j int) bool { return records[i].Record.UUID < records[j].Record.UUID })
+	}
+	return diff
+}
+
+// isRecordModified compares the audit lastModifiedAt of a record, and the
+// fields the records are looked up by
+func isRecordModified(previous VaultRecordWithGroup, current VaultRecordWithGroup) bool {
+	return !lastModifiedAt(&previous.Record).Equal(lastModifiedAt(&current.Record)) ||
+		previous.Record.Name != current.Record.Name ||
+		previous.Record.Color != current.Record.Color ||
+		previous.Group.UUID != current.Group.UUID ||
+		previous.Group.Name != current.Group.Name
+}
+
+// lastModifiedAt returns the zero time for records listed without audit
+func lastModifiedAt(record *keyhubmodel.VaultRecord) time.Time {
+	if record.AdditionalObjects == nil || record.AdditionalObjects.Audit == nil {
+		return time.Time{}
+	}
+	return record.LastModifiedAt()
+}
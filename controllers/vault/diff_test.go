@@ -0,0 +1,67 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+)
+
+// withLastModifiedAt returns a copy of idxEntry modified at t
+func withLastModifiedAt(idxEntry VaultRecordWithGroup, t time.Time) VaultRecordWithGroup {
+	idxEntry.Record.AdditionalObjects = &keyhubmodel.VaultRecordAdditionalObjects{
+		Audit: &keyhubmodel.AuditAdditionalObject{LastModifiedAt: t},
+	}
+	return idxEntry
+}
+
+func TestDiffIndex(t *testing.T) {
+	modifiedAt := time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC)
+	previous := testIndex()
+	for uuid, idxEntry := range previous {
+		previous[uuid] = withLastModifiedAt(idxEntry, modifiedAt)
+	}
+
+	current := make(map[string]VaultRecordWithGroup)
+	for uuid, idxEntry := range previous {
+		current[uuid] = idxEntry
+	}
+	// Modified in KeyHub
+	current["00000000-0000-0000-1001-000000000001"] = withLastModifiedAt(current["00000000-0000-0000-1001-000000000001"], modifiedAt.Add(time.Minute))
+	// Renamed
+	renamed := current["00000000-0000-0000-1001-000000000002"]
+	renamed.Record.Name = "Database replica 2"
+	current["00000000-0000-0000-1001-000000000002"] = renamed
+	// Removed and added
+	delete(current, "00000000-0000-0000-1002-000000000001")
+	added := withLastModifiedAt(VaultRecordWithGroup{Group: renamed.Group, Record: keyhubmodel.VaultRecord{UUID: "00000000-0000-0000-1001-000000000003", Name: "Cache"}}, modifiedAt)
+	current[added.Record.UUID] = added
+
+	diff := diffIndex("client", previous, current)
+	if diff.ClientID != "client" {
+		t.Errorf("Expected client ID 'client', got '%s'", diff.ClientID)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].Record.UUID != "00000000-0000-0000-1001-000000000003" {
+		t.Errorf("Unexpected added records: %v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].Record.UUID != "00000000-0000-0000-1002-000000000001" {
+		t.Errorf("Unexpected removed records: %v", diff.Removed)
+	}
+	expected := []string{
+		"00000000-0000-0000-1001-000000000003",
+		"00000000-0000-0000-1002-000000000001",
+		"00000000-0000-0000-1001-000000000001",
+		"00000000-0000-0000-1001-000000000002",
+	}
+	if uuids := diff.RecordUUIDs(); !reflect.DeepEqual(uuids, expected) {
+		t.Errorf("Expected changed records %v, got %v", expected, uuids)
+	}
+
+	if diff := diffIndex("client", current, current); !diff.IsEmpty() {
+		t.Errorf("Expected no changes, got %v", diff.RecordUUIDs())
+	}
+}
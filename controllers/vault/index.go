@@ -4,16 +4,21 @@
 package vault
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/patrickmn/go-cache"
 	keyhub "github.com/topicuskeyhub/go-keyhub"
 	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
 )
 
+// indexIdleTimeout stops the refresh of the index of a client that has not
+// been used, e.g. after its policy was removed. It exceeds the refresh
+// interval of the KeyHubSecrets, which rely on the refresh to detect changes.
+const indexIdleTimeout = 24 * time.Hour
+
 type VaultRecordWithGroup struct {
 	Group  keyhubmodel.Group
 	Record keyhubmodel.VaultRecord
@@ -21,28 +26,211 @@ type VaultRecordWithGroup struct {
 
 type VaultIndexCache interface {
 	Get(client *keyhub.Client) (map[string]VaultRecordWithGroup, error)
+	Invalidate(client *keyhub.Client) error
 	Flush()
+	// Subscribe registers a listener for the records that changed when the
+	// index of a client is refreshed or rebuilt after Invalidate. Listeners
+	// are called in order of the builds, a blocking listener delays them.
+	Subscribe(listener func(diff *VaultIndexDiff))
 }
 
+// vaultIndexCache keeps the index of every client up to date in the
+// background. Get serves the last good index while it is refreshed, a
+// failed refresh is retried until the index exceeds the maximum staleness.
+// Only one index build runs per client at a time.
 type vaultIndexCache struct {
-	log   logr.Logger
-	cache *cache.Cache
-	mutex *sync.Mutex
+	log             logr.Logger
+	refreshInterval time.Duration
+	maxStaleness    time.Duration
+	entries         map[string]*indexEntry
+	builds          map[*keyhub.Client]*indexBuild
+	listeners       []func(diff *VaultIndexDiff)
+	mutex           *sync.Mutex
+	// fetch builds the index of a client, replaced in tests
+	fetch func(client *keyhub.Client) (map[string]VaultRecordWithGroup, error)
+}
+
+// indexEntry is the index of a client, guarded by the mutex of the cache
+type indexEntry struct {
+	client      *keyhub.Client
+	records     map[string]VaultRecordWithGroup
+	refreshedAt time.Time
+	accessedAt  time.Time
+	// refreshErr is the error of the last failed refresh
+	refreshErr error
+	stop       chan struct{}
+}
+
+// indexBuild is a running index build, the callers requesting the index of
+// the same client wait for done and share its result
+type indexBuild struct {
+	done    chan struct{}
+	records map[string]VaultRecordWithGroup
+	err     error
 }
 
-func NewVaultIndexCache(log logr.Logger) VaultIndexCache {
-	return &vaultIndexCache{
-		log:   log,
-		cache: cache.New(10*time.Minute, 15*time.Minute),
-		mutex: &sync.Mutex{},
+func NewVaultIndexCache(log logr.Logger, refreshInterval time.Duration, maxStaleness time.Duration) VaultIndexCache {
+	c := &vaultIndexCache{
+		log:             log,
+		refreshInterval: refreshInterval,
+		maxStaleness:    maxStaleness,
+		entries:         make(map[string]*indexEntry),
+		builds:          make(map[*keyhub.Client]*indexBuild),
+		mutex:           &sync.Mutex{},
 	}
+	c.fetch = c.fetchIndex
+	metrics.SetVaultIndexAgeSource(c.ages)
+	return c
 }
 
 func (c *vaultIndexCache) Get(client *keyhub.Client) (map[string]VaultRecordWithGroup, error) {
-	if records, found := c.cache.Get(client.ID); found {
-		return records.(map[string]VaultRecordWithGroup), nil
+	c.mutex.Lock()
+	// The index is only served to the client that built it, a client with
+	// the same ID and other credentials builds its own index
+	entry, found := c.entries[client.ID]
+	if found && entry.client == client {
+		entry.accessedAt = time.Now()
+	}
+	if found && entry.client == client {
+		records, age, refreshErr := entry.records, time.Since(entry.refreshedAt), entry.refreshErr
+		c.mutex.Unlock()
+
+		if age > c.maxStaleness {
+			if refreshErr == nil {
+				return nil, fmt.Errorf("Vault index is %s old, exceeding the maximum staleness of %s", age.Round(time.Second), c.maxStaleness)
+			}
+			return nil, fmt.Errorf("Vault index is %s old, exceeding the maximum staleness of %s: %w", age.Round(time.Second), c.maxStaleness, refreshErr)
+		}
+		return records, nil
 	}
+	c.mutex.Unlock()
+
+	return c.build(client, nil)
+}
+
+// build fetches the index of client and stores it. A caller finding a build
+// of the same client in progress waits for its result instead. The build of
+// a refresh is only stored while refreshed is the entry of the client.
+func (c *vaultIndexCache) build(client *keyhub.Client, refreshed *indexEntry) (map[string]VaultRecordWithGroup, error) {
+	c.mutex.Lock()
+	if b, found := c.builds[client]; found {
+		c.mutex.Unlock()
+		<-b.done
+		return b.records, b.err
+	}
+	b := &indexBuild{done: make(chan struct{})}
+	c.builds[client] = b
+	c.mutex.Unlock()
+
+	defer close(b.done)
+	b.records, b.err = c.fetch(client)
+
+	var diff *VaultIndexDiff
+	c.mutex.Lock()
+	// An invalidated build is not stored, it may predate the change
+	// the invalidation was requested for
+	if c.builds[client] == b {
+		delete(c.builds, client)
+		if b.err == nil && (refreshed == nil || c.entries[client.ID] == refreshed) {
+			diff = c.store(client, b.records)
+		}
+	}
+	listeners := c.listeners
+	c.mutex.Unlock()
+
+	if diff != nil && !diff.IsEmpty() {
+		c.log.Info("Vault index changed", "client", client.ID, "added", len(diff.Added), "removed", len(diff.Removed), "modified", len(diff.Modified), "records", diff.RecordUUIDs())
+		for _, listener := range listeners {
+			listener(diff)
+		}
+	}
+
+	return b.records, b.err
+}
+
+// store adds the index of client, its refresh is started in the background.
+// It returns the changes compared to the previous index, nil for a new
+// index. The caller must hold the mutex.
+func (c *vaultIndexCache) store(client *keyhub.Client, records map[string]VaultRecordWithGroup) *VaultIndexDiff {
+	now := time.Now()
+	entry, found := c.entries[client.ID]
+	if found && entry.client == client {
+		diff := diffIndex(client.ID, entry.records, records)
+		entry.records = records
+		entry.refreshedAt = now
+		entry.refreshErr = nil
+		return diff
+	}
+
+	// A new client for the same ID, e.g. after the rotation of its secret,
+	// replaces the entry of the previous client
+	var diff *VaultIndexDiff
+	if found {
+		diff = diffIndex(client.ID, entry.records, records)
+		close(entry.stop)
+	}
+
+	entry = &indexEntry{
+		client:      client,
+		records:     records,
+		refreshedAt: now,
+		accessedAt:  now,
+		stop:        make(chan struct{}),
+	}
+	c.entries[client.ID] = entry
+	go c.refresh(entry)
+	return diff
+}
+
+// refresh rebuilds the index of entry every refresh interval, a failed
+// refresh is retried after a fifth of the interval. It stops when the entry
+// is removed or has not been used for the idle timeout.
+func (c *vaultIndexCache) refresh(entry *indexEntry) {
+	timer := time.NewTimer(c.refreshInterval)
+	defer timer.Stop()
 
+	for {
+		select {
+		case <-entry.stop:
+			return
+		case <-timer.C:
+		}
+
+		c.mutex.Lock()
+		idle := time.Since(entry.accessedAt) > indexIdleTimeout
+		c.mutex.Unlock()
+		if idle {
+			c.log.Info("Removing idle vault index", "client", entry.client.ID)
+			c.remove(entry)
+			return
+		}
+
+		// A successful build replaces the records of the entry
+		if _, err := c.build(entry.client, entry); err != nil {
+			c.mutex.Lock()
+			entry.refreshErr = err
+			c.log.Error(err, "Failed to refresh vault index, serving the last index", "client", entry.client.ID, "age", time.Since(entry.refreshedAt).Round(time.Second))
+			c.mutex.Unlock()
+			timer.Reset(c.refreshInterval / 5)
+		} else {
+			timer.Reset(c.refreshInterval)
+		}
+	}
+}
+
+// remove stops the refresh of entry and removes it, unless it was replaced
+func (c *vaultIndexCache) remove(entry *indexEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries[entry.client.ID] == entry {
+		delete(c.entries, entry.client.ID)
+		close(entry.stop)
+	}
+}
+
+// fetchIndex lists the records in the vaults of all groups of client
+func (c *vaultIndexCache) fetchIndex(client *keyhub.Client) (map[string]VaultRecordWithGroup, error) {
 	metrics.KeyHubApiRequests.WithLabelValues("group", "list").Inc()
 	groups, err := client.Groups.List()
 	if err != nil {
@@ -51,25 +239,71 @@ func (c *vaultIndexCache) Get(client *keyhub.Client) (map[string]VaultRecordWith
 
 	result := make(map[string]VaultRecordWithGroup)
 	for _, group := range groups {
-		// log.Info("Found KeyHub group", "uuid", group.UUID, "name", group.Name)
 		metrics.KeyHubApiRequests.WithLabelValues("vault", "list").Inc()
 		records, err := client.Vaults.GetRecords(&group)
 		if err != nil {
 			return nil, err
 		}
+		// The changes are logged per refresh, not every record
 		for _, record := range records {
-			c.log.Info("Found Keyhub vault record", "group", group.UUID, "uuid", record.UUID, "name", record.Name)
-			// records = append(records, VaultRecordWithGroup{group: group, record: record})
-
 			result[record.UUID] = VaultRecordWithGroup{Group: group, Record: record}
 		}
 	}
 
-	c.cache.SetDefault(client.ID, result)
-
 	return result, nil
 }
 
+// ages returns the age of the index per client
+func (c *vaultIndexCache) ages() map[string]time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ages := make(map[string]time.Duration, len(c.entries))
+	for clientID, entry := range c.entries {
+		ages[clientID] = time.Since(entry.refreshedAt)
+	}
+	return ages
+}
+
+func (c *vaultIndexCache) Subscribe(listener func(diff *VaultIndexDiff)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listeners = append(c.listeners, listener)
+}
+
+// Invalidate rebuilds the index of client right away, without waiting for a
+// build in progress. The rebuild is compared with the current index, so its
+// changes reach the listeners. When the rebuild fails, the error is only
+// returned to the caller: the current index is served until it exceeds the
+// maximum staleness, like after a failed refresh. Without an index of
+// client, the next Get builds it.
+func (c *vaultIndexCache) Invalidate(client *keyhub.Client) error {
+	c.mutex.Lock()
+	entry, found := c.entries[client.ID]
+	delete(c.builds, client)
+	c.mutex.Unlock()
+
+	if !found || entry.client != client {
+		return nil
+	}
+
+	if _, err := c.build(client, entry); err != nil {
+		c.mutex.Lock()
+		entry.refreshErr = err
+		c.mutex.Unlock()
+		c.log.Error(err, "Failed to rebuild invalidated vault index, serving the last index", "client", client.ID)
+		return err
+	}
+	return nil
+}
+
 func (c *vaultIndexCache) Flush() {
-	c.cache.Flush()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for clientID, entry := range c.entries {
+		delete(c.entries, clientID)
+		close(entry.stop)
+	}
+	c.builds = make(map[*keyhub.Client]*indexBuild)
 }
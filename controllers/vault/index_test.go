@@ -0,0 +1,342 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	keyhub "github.com/topicuskeyhub/go-keyhub"
+)
+
+// fakeIndex serves the index of testIndex, or fails when err is set. When
+// release is set, fetches wait for it to be closed.
+type fakeIndex struct {
+	mutex   sync.Mutex
+	fetches int
+	err     error
+	release chan struct{}
+	// index replaces testIndex when set
+	index map[string]VaultRecordWithGroup
+}
+
+func (f *fakeIndex) fetch(client *keyhub.Client) (map[string]VaultRecordWithGroup, error) {
+	f.mutex.Lock()
+	f.fetches++
+	release := f.release
+	f.mutex.Unlock()
+	if release != nil {
+		<-release
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.err != nil {
+		return nil, f.err
+	}
+	if f.index != nil {
+		return f.index, nil
+	}
+	return testIndex(), nil
+}
+
+func (f *fakeIndex) setIndex(index map[string]VaultRecordWithGroup) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.index = index
+}
+
+func (f *fakeIndex) setErr(err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.err = err
+}
+
+func (f *fakeIndex) fetchCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.fetches
+}
+
+func newTestIndexCache(refreshInterval time.Duration, maxStaleness time.Duration) (*vaultIndexCache, *fakeIndex) {
+	fake := &fakeIndex{}
+	c := NewVaultIndexCache(logr.Discard(), refreshInterval, maxStaleness).(*vaultIndexCache)
+	c.fetch = fake.fetch
+	return c, fake
+}
+
+// eventually polls condition for at most a second
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if condition() {
+			return
+		}
+	}
+	t.Fatal(message)
+}
+
+func TestVaultIndexCacheRefreshesInBackground(t *testing.T) {
+	c, fake := newTestIndexCache(20*time.Millisecond, time.Hour)
+	defer c.Flush()
+	client := &keyhub.Client{ID: "client"}
+
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	eventually(t, func() bool { return fake.fetchCount() >= 3 }, "Expected the index to be refreshed in the background")
+
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if age, found := c.ages()["client"]; !found || age > time.Second {
+		t.Errorf("Expected a recent index, got age %s", age)
+	}
+}
+
+func TestVaultIndexCacheServesStaleIndex(t *testing.T) {
+	c, fake := newTestIndexCache(20*time.Millisecond, 200*time.Millisecond)
+	defer c.Flush()
+	client := &keyhub.Client{ID: "client"}
+
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	unavailable := errors.New("KeyHub unavailable")
+	fake.setErr(unavailable)
+	fetches := fake.fetchCount()
+	eventually(t, func() bool { return fake.fetchCount() > fetches }, "Expected a failed refresh")
+
+	records, err := c.Get(client)
+	if err != nil {
+		t.Fatalf("Expected the last index while it is not too stale, got %v", err)
+	}
+	if len(records) != len(testIndex()) {
+		t.Errorf("Expected %d records, got %d", len(testIndex()), len(records))
+	}
+
+	eventually(t, func() bool {
+		_, err := c.Get(client)
+		return errors.Is(err, unavailable)
+	}, "Expected an error once the index exceeds the maximum staleness")
+
+	fake.setErr(nil)
+	eventually(t, func() bool {
+		_, err := c.Get(client)
+		return err == nil
+	}, "Expected the index to recover after a successful refresh")
+}
+
+func TestVaultIndexCacheInvalidate(t *testing.T) {
+	c, fake := newTestIndexCache(time.Hour, time.Hour)
+	defer c.Flush()
+	client := &keyhub.Client{ID: "client"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(client); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if fake.fetchCount() != 1 {
+		t.Errorf("Expected 1 fetch, got %d", fake.fetchCount())
+	}
+
+	c.Invalidate(client)
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected a fetch after invalidation, got %d fetches", fake.fetchCount())
+	}
+}
+
+func TestVaultIndexCacheCoalescesBuilds(t *testing.T) {
+	c, fake := newTestIndexCache(time.Hour, time.Hour)
+	defer c.Flush()
+	fake.release = make(chan struct{})
+	client := &keyhub.Client{ID: "client"}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			records, err := c.Get(client)
+			if err == nil && len(records) != len(testIndex()) {
+				err = errors.New("incomplete index")
+			}
+			errs <- err
+		}()
+	}
+
+	eventually(t, func() bool { return fake.fetchCount() == 1 }, "Expected the index build to start")
+	// Give the other callers time to find the build in progress
+	time.Sleep(20 * time.Millisecond)
+	close(fake.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	}
+	if fake.fetchCount() != 1 {
+		t.Errorf("Expected 1 index build, got %d", fake.fetchCount())
+	}
+}
+
+func TestVaultIndexCacheInvalidateDuringBuild(t *testing.T) {
+	c, fake := newTestIndexCache(time.Hour, time.Hour)
+	defer c.Flush()
+	fake.release = make(chan struct{})
+	client := &keyhub.Client{ID: "client"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Get(client)
+	}()
+	eventually(t, func() bool { return fake.fetchCount() == 1 }, "Expected the index build to start")
+
+	c.Invalidate(client)
+	close(fake.release)
+	<-done
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected a new index build after the invalidation, got %d builds", fake.fetchCount())
+	}
+}
+
+func TestVaultIndexCacheNotifiesChanges(t *testing.T) {
+	c, fake := newTestIndexCache(20*time.Millisecond, time.Hour)
+	defer c.Flush()
+	client := &keyhub.Client{ID: "client"}
+
+	diffs := make(chan *VaultIndexDiff, 10)
+	c.Subscribe(func(diff *VaultIndexDiff) { diffs <- diff })
+
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	index := testIndex()
+	delete(index, "00000000-0000-0000-1002-000000000001")
+	fake.setIndex(index)
+
+	select {
+	case diff := <-diffs:
+		if diff.ClientID != "client" || len(diff.Removed) != 1 || len(diff.Added) != 0 || len(diff.Modified) != 0 {
+			t.Errorf("Unexpected diff: %+v", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the removed record to be reported")
+	}
+
+	// Unchanged refreshes are not reported
+	select {
+	case diff := <-diffs:
+		t.Errorf("Unexpected diff: %+v", diff)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestVaultIndexCacheInvalidateNotifiesChanges(t *testing.T) {
+	c, fake := newTestIndexCache(time.Hour, time.Hour)
+	defer c.Flush()
+	client := &keyhub.Client{ID: "client"}
+
+	diffs := make(chan *VaultIndexDiff, 10)
+	c.Subscribe(func(diff *VaultIndexDiff) { diffs <- diff })
+
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	index := testIndex()
+	delete(index, "00000000-0000-0000-1002-000000000001")
+	fake.setIndex(index)
+
+	c.Invalidate(client)
+	records, err := c.Get(client)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(records) != len(index) {
+		t.Errorf("Expected the rebuilt index of %d records, got %d", len(index), len(records))
+	}
+
+	select {
+	case diff := <-diffs:
+		if len(diff.Removed) != 1 || diff.Removed[0].Record.UUID != "00000000-0000-0000-1002-000000000001" {
+			t.Errorf("Unexpected diff: %+v", diff)
+		}
+	default:
+		t.Fatal("Expected the changes of the rebuild to be reported")
+	}
+}
+
+func TestVaultIndexCacheIsBoundToClient(t *testing.T) {
+	c, fake := newTestIndexCache(time.Hour, time.Hour)
+	defer c.Flush()
+	client := &keyhub.Client{ID: "client"}
+
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A client with the same ID and other credentials that fail
+	unauthorized := errors.New("invalid client secret")
+	fake.setErr(unauthorized)
+	other := &keyhub.Client{ID: "client"}
+	if _, err := c.Get(other); !errors.Is(err, unauthorized) {
+		t.Fatalf("Expected the other client to build its own index, got %v", err)
+	}
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected 2 index builds, got %d", fake.fetchCount())
+	}
+
+	fake.setErr(nil)
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Expected the index of the first client, got %v", err)
+	}
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected the index of the first client to be kept, got %d builds", fake.fetchCount())
+	}
+}
+
+func TestVaultIndexCacheServesStaleIndexAfterFailedInvalidate(t *testing.T) {
+	c, fake := newTestIndexCache(time.Hour, time.Hour)
+	defer c.Flush()
+	client := &keyhub.Client{ID: "client"}
+
+	if _, err := c.Get(client); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	unavailable := errors.New("KeyHub unavailable")
+	fake.setErr(unavailable)
+	if err := c.Invalidate(client); !errors.Is(err, unavailable) {
+		t.Fatalf("Expected the failed rebuild to be returned, got %v", err)
+	}
+
+	records, err := c.Get(client)
+	if err != nil {
+		t.Fatalf("Expected the stale index after the failed rebuild, got %v", err)
+	}
+	if len(records) != len(testIndex()) {
+		t.Errorf("Expected %d records, got %d", len(testIndex()), len(records))
+	}
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected Get not to rebuild the index, got %d builds", fake.fetchCount())
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+)
+
+// VaultRecordCache keeps the records fetched with their secrets for a short
+// time, so the KeyHubSecrets referencing the same record share a fetch. The
+// records are keyed by UUID and lastModifiedAt, a modified record is fetched
+// again.
+type VaultRecordCache interface {
+	// Get returns the record of idxEntry, calling fetch when it is not cached
+	Get(idxEntry VaultRecordWithGroup, fetch func() (*keyhubmodel.VaultRecord, error)) (*keyhubmodel.VaultRecord, error)
+	Flush()
+}
+
+// vaultRecordCache stores the records as JSON, encrypted with a key that only
+// lives in memory when encryption is enabled. Only one fetch runs per record
+// at a time.
+type vaultRecordCache struct {
+	ttl     time.Duration
+	aead    cipher.AEAD
+	entries map[recordKey]*recordEntry
+	fetches map[recordKey]*recordFetch
+	mutex   *sync.Mutex
+}
+
+type recordKey struct {
+	uuid           string
+	lastModifiedAt int64
+}
+
+type recordEntry struct {
+	data      []byte
+	expiresAt time.Time
+}
+
+// recordFetch is a running fetch, the callers requesting the same record wait
+// for done and share its result
+type recordFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// NewVaultRecordCache returns a cache keeping the records for ttl, a ttl of 0
+// disables the cache
+func NewVaultRecordCache(ttl time.Duration, encrypt bool) (VaultRecordCache, error) {
+	c := &vaultRecordCache{
+		ttl:     ttl,
+		entries: make(map[recordKey]*recordEntry),
+		fetches: make(map[recordKey]*recordFetch),
+		mutex:   &sync.Mutex{},
+	}
+	if encrypt {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("Failed to generate the record cache key: %w", err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if c.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (c *vaultRecordCache) Get(idxEntry VaultRecordWithGroup, fetch func() (*keyhubmodel.VaultRecord, error)) (*keyhubmodel.VaultRecord, error) {
+	// Without the audit of the index a modified record can't be detected
+	modifiedAt := lastModifiedAt(&idxEntry.Record)
+	if c.ttl <= 0 || modifiedAt.IsZero() {
+		return fetch()
+	}
+	key := recordKey{uuid: idxEntry.Record.UUID, lastModifiedAt: modifiedAt.UnixNano()}
+
+	c.mutex.Lock()
+	if entry, found := c.entries[key]; found && time.Now().Before(entry.expiresAt) {
+		c.mutex.Unlock()
+		metrics.VaultRecordCacheRequests.WithLabelValues("hit").Inc()
+		return c.decode(entry.data)
+	}
+	if f, found := c.fetches[key]; found {
+		c.mutex.Unlock()
+		metrics.VaultRecordCacheRequests.WithLabelValues("hit").Inc()
+		<-f.done
+		if f.err != nil {
+			return nil, f.err
+		}
+		return c.decode(f.data)
+	}
+	f := &recordFetch{done: make(chan struct{})}
+	c.fetches[key] = f
+	c.mutex.Unlock()
+	metrics.VaultRecordCacheRequests.WithLabelValues("miss").Inc()
+
+	defer close(f.done)
+	record, err := fetch()
+	if err == nil {
+		f.data, err = c.encode(record)
+	}
+	f.err = err
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// A flushed fetch is not stored
+	if c.fetches[key] == f {
+		delete(c.fetches, key)
+		if err == nil {
+			c.store(key, f.data)
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// store adds the record data and removes the expired records. The caller
+// must hold the mutex.
+func (c *vaultRecordCache) store(key recordKey, data []byte) {
+	now := time.Now()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &recordEntry{data: data, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *vaultRecordCache) encode(record *keyhubmodel.VaultRecord) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if c.aead == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// decode returns a copy of the record, callers may modify it
+func (c *vaultRecordCache) decode(data []byte) (*keyhubmodel.VaultRecord, error) {
+	if c.aead != nil {
+		nonceSize := c.aead.NonceSize()
+		if len(data) < nonceSize {
+			return nil, fmt.Errorf("Cached record is too short")
+		}
+		var err error
+		if data, err = c.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil); err != nil {
+			return nil, err
+		}
+	}
+
+	record := &keyhubmodel.VaultRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (c *vaultRecordCache) Flush() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = make(map[recordKey]*recordEntry)
+	c.fetches = make(map[recordKey]*recordFetch)
+}
@@ -0,0 +1,218 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+)
+
+// fakeRecords returns the record of an index entry with a password, or fails
+// when err is set. When release is set, fetches wait for it to be closed.
+type fakeRecords struct {
+	mutex   sync.Mutex
+	fetches int
+	err     error
+	release chan struct{}
+}
+
+func (f *fakeRecords) fetcher(idxEntry VaultRecordWithGroup) func() (*keyhubmodel.VaultRecord, error) {
+	return func() (*keyhubmodel.VaultRecord, error) {
+		f.mutex.Lock()
+		f.fetches++
+		release := f.release
+		f.mutex.Unlock()
+		if release != nil {
+			<-release
+		}
+
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if f.err != nil {
+			return nil, f.err
+		}
+		password := "secret of " + idxEntry.Record.UUID
+		record := idxEntry.Record
+		record.AdditionalObjects = &keyhubmodel.VaultRecordAdditionalObjects{
+			Audit:  idxEntry.Record.AdditionalObjects.Audit,
+			Secret: &keyhubmodel.VaultRecordSecretAdditionalObject{Password: &password},
+		}
+		return &record, nil
+	}
+}
+
+func (f *fakeRecords) fetchCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.fetches
+}
+
+func testRecordEntry(modifiedAt time.Time) VaultRecordWithGroup {
+	return withLastModifiedAt(testIndex()["00000000-0000-0000-1001-000000000001"], modifiedAt)
+}
+
+func TestVaultRecordCache(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		c, err := NewVaultRecordCache(time.Minute, encrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fake := &fakeRecords{}
+		modifiedAt := time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC)
+		idxEntry := testRecordEntry(modifiedAt)
+
+		hits := testutil.ToFloat64(metrics.VaultRecordCacheRequests.WithLabelValues("hit"))
+		for i := 0; i < 3; i++ {
+			record, err := c.Get(idxEntry, fake.fetcher(idxEntry))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *record.AdditionalObjects.Secret.Password != "secret of "+idxEntry.Record.UUID {
+				t.Errorf("encrypt=%t: unexpected password %s", encrypt, *record.AdditionalObjects.Secret.Password)
+			}
+			if !record.LastModifiedAt().Equal(modifiedAt) {
+				t.Errorf("encrypt=%t: unexpected lastModifiedAt %s", encrypt, record.LastModifiedAt())
+			}
+		}
+		if fake.fetchCount() != 1 {
+			t.Errorf("encrypt=%t: expected 1 fetch, got %d", encrypt, fake.fetchCount())
+		}
+		if delta := testutil.ToFloat64(metrics.VaultRecordCacheRequests.WithLabelValues("hit")) - hits; delta != 2 {
+			t.Errorf("encrypt=%t: expected 2 hits, got %v", encrypt, delta)
+		}
+
+		// A modified record is fetched again
+		modified := testRecordEntry(modifiedAt.Add(time.Minute))
+		if _, err := c.Get(modified, fake.fetcher(modified)); err != nil {
+			t.Fatal(err)
+		}
+		if fake.fetchCount() != 2 {
+			t.Errorf("encrypt=%t: expected the modified record to be fetched, got %d fetches", encrypt, fake.fetchCount())
+		}
+	}
+}
+
+func TestVaultRecordCacheReturnsCopies(t *testing.T) {
+	c, _ := NewVaultRecordCache(time.Minute, false)
+	fake := &fakeRecords{}
+	idxEntry := testRecordEntry(time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC))
+
+	record, _ := c.Get(idxEntry, fake.fetcher(idxEntry))
+	record.Name = "Changed"
+	cached, err := c.Get(idxEntry, fake.fetcher(idxEntry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cached.Name != idxEntry.Record.Name {
+		t.Errorf("Expected a copy of the cached record, got %s", cached.Name)
+	}
+}
+
+func TestVaultRecordCacheExpires(t *testing.T) {
+	c, _ := NewVaultRecordCache(20*time.Millisecond, true)
+	fake := &fakeRecords{}
+	idxEntry := testRecordEntry(time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC))
+
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	time.Sleep(30 * time.Millisecond)
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected the expired record to be fetched again, got %d fetches", fake.fetchCount())
+	}
+}
+
+func TestVaultRecordCacheSkipsRecordsWithoutAudit(t *testing.T) {
+	c, _ := NewVaultRecordCache(time.Minute, false)
+	fake := &fakeRecords{}
+	idxEntry := testRecordEntry(time.Time{})
+
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected records without lastModifiedAt not to be cached, got %d fetches", fake.fetchCount())
+	}
+}
+
+func TestVaultRecordCacheDisabled(t *testing.T) {
+	c, _ := NewVaultRecordCache(0, false)
+	fake := &fakeRecords{}
+	idxEntry := testRecordEntry(time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC))
+
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected a disabled cache to fetch every record, got %d fetches", fake.fetchCount())
+	}
+}
+
+func TestVaultRecordCacheCoalescesFetches(t *testing.T) {
+	c, _ := NewVaultRecordCache(time.Minute, true)
+	fake := &fakeRecords{release: make(chan struct{})}
+	idxEntry := testRecordEntry(time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC))
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Get(idxEntry, fake.fetcher(idxEntry))
+			errs <- err
+		}()
+	}
+	eventually(t, func() bool { return fake.fetchCount() == 1 }, "Expected a fetch to start")
+	// Let the other callers find the fetch in progress
+	time.Sleep(20 * time.Millisecond)
+	close(fake.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fake.fetchCount() != 1 {
+		t.Errorf("Expected 1 fetch, got %d", fake.fetchCount())
+	}
+}
+
+func TestVaultRecordCacheDoesNotCacheErrors(t *testing.T) {
+	c, _ := NewVaultRecordCache(time.Minute, false)
+	fake := &fakeRecords{err: errors.New("KeyHub unavailable")}
+	idxEntry := testRecordEntry(time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC))
+
+	if _, err := c.Get(idxEntry, fake.fetcher(idxEntry)); err == nil {
+		t.Fatal("Expected the fetch error")
+	}
+	fake.mutex.Lock()
+	fake.err = nil
+	fake.mutex.Unlock()
+	if _, err := c.Get(idxEntry, fake.fetcher(idxEntry)); err != nil {
+		t.Fatal(err)
+	}
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected the failed fetch to be retried, got %d fetches", fake.fetchCount())
+	}
+}
+
+func TestVaultRecordCacheFlush(t *testing.T) {
+	c, _ := NewVaultRecordCache(time.Minute, false)
+	fake := &fakeRecords{}
+	idxEntry := testRecordEntry(time.Date(2020, 1, 1, 16, 0, 30, 0, time.UTC))
+
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	c.Flush()
+	c.Get(idxEntry, fake.fetcher(idxEntry))
+	if fake.fetchCount() != 2 {
+		t.Errorf("Expected the flushed record to be fetched again, got %d fetches", fake.fetchCount())
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+)
+
+var (
+	ErrRecordNotFound  = errors.New("record not found")
+	ErrRecordAmbiguous = errors.New("record is ambiguous")
+)
+
+// ResolveRecord finds the record named name in the vault of group, the group
+// is matched by UUID or name. When regex is set, name is a regular expression
+// that must match the whole record name. Exactly one record must match.
+func ResolveRecord(records map[string]VaultRecordWithGroup, group string, name string, regex bool) (VaultRecordWithGroup, error) {
+	matchName := func(recordName string) bool { return recordName == name }
+	if regex {
+		re, err := regexp.Compile("^(?:" + name + ")$")
+		if err != nil {
+			return VaultRecordWithGroup{}, fmt.Errorf("Invalid regular expression '%s': %w", name, err)
+		}
+		matchName = re.MatchString
+	}
+
+	var matches []VaultRecordWithGroup
+	for _, idxEntry := range records {
+		if idxEntry.Group.UUID != group && idxEntry.Group.Name != group {
+			continue
+		}
+		if matchName(idxEntry.Record.Name) {
+			matches = append(matches, idxEntry)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return VaultRecordWithGroup{}, fmt.Errorf("No record '%s' found in group '%s': %w", name, group, ErrRecordNotFound)
+	case 1:
+		return matches[0], nil
+	}
+
+	found := make([]string, 0, len(matches))
+	for _, idxEntry := range matches {
+		found = append(found, fmt.Sprintf("%s (%s in group %s)", idxEntry.Record.UUID, idxEntry.Record.Name, idxEntry.Group.UUID))
+	}
+	sort.Strings(found)
+	return VaultRecordWithGroup{}, fmt.Errorf("Record '%s' in group '%s' matches %d records: %s: %w", name, group, len(matches), strings.Join(found, ", "), ErrRecordAmbiguous)
+}
+
+// MatchRecords returns the records in the vault of group whose whole name
+// matches namePattern and that have color, both are optional. The group is
+// matched by UUID or name, the records are sorted by name.
+func MatchRecords(records map[string]VaultRecordWithGroup, group string, namePattern string, color string) ([]VaultRecordWithGroup, error) {
+	var re *regexp.Regexp
+	if namePattern != "" {
+		var err error
+		re, err = regexp.Compile("^(?:" + namePattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid regular expression '%s': %w", namePattern, err)
+		}
+	}
+
+	var matches []VaultRecordWithGroup
+	for _, idxEntry := range records {
+		if idxEntry.Group.UUID != group && idxEntry.Group.Name != group {
+			continue
+		}
+		if re != nil && !re.MatchString(idxEntry.Record.Name) {
+			continue
+		}
+		if color != "" && !strings.EqualFold(recordColor(idxEntry.Record), color) {
+			continue
+		}
+		matches = append(matches, idxEntry)
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Record.Name != matches[j].Record.Name {
+			return matches[i].Record.Name < matches[j].Record.Name
+		}
+		return matches[i].Record.UUID < matches[j].Record.UUID
+	})
+	return matches, nil
+}
+
+// recordColor returns the color of record, records without a color are NONE
+func recordColor(record keyhubmodel.VaultRecord) string {
+	if record.Color == "" {
+		return keyhubmodel.VaultRecordColorNone
+	}
+	return record.Color
+}
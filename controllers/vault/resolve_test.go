@@ -0,0 +1,116 @@
+// Copyright 2020 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package vault
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	keyhubmodel "github.com/topicuskeyhub/go-keyhub/model"
+)
+
+func testIndex() map[string]VaultRecordWithGroup {
+	group := func(uuid string, name string) keyhubmodel.Group {
+		g := keyhubmodel.Group{}
+		g.UUID = uuid
+		g.Name = name
+		return g
+	}
+	entry := func(g keyhubmodel.Group, uuid string, name string, color string) VaultRecordWithGroup {
+		return VaultRecordWithGroup{Group: g, Record: keyhubmodel.VaultRecord{UUID: uuid, Name: name, Color: color}}
+	}
+
+	app := group("00000000-0000-0000-1001-000000000000", "App")
+	ops := group("00000000-0000-0000-1002-000000000000", "Ops")
+	index := make(map[string]VaultRecordWithGroup)
+	for _, idxEntry := range []VaultRecordWithGroup{
+		entry(app, "00000000-0000-0000-1001-000000000001", "Database", ""),
+		entry(app, "00000000-0000-0000-1001-000000000002", "Database replica", keyhubmodel.VaultRecordColorGreen),
+		entry(ops, "00000000-0000-0000-1002-000000000001", "Database", keyhubmodel.VaultRecordColorGreen),
+	} {
+		index[idxEntry.Record.UUID] = idxEntry
+	}
+	return index
+}
+
+func TestResolveRecord(t *testing.T) {
+	index := testIndex()
+
+	tests := []struct {
+		group string
+		name  string
+		regex bool
+		uuid  string
+		err   error
+	}{
+		{group: "App", name: "Database", uuid: "00000000-0000-0000-1001-000000000001"},
+		{group: "00000000-0000-0000-1002-000000000000", name: "Database", uuid: "00000000-0000-0000-1002-000000000001"},
+		{group: "App", name: "Database rep.*", regex: true, uuid: "00000000-0000-0000-1001-000000000002"},
+		{group: "App", name: "Database.*", regex: true, err: ErrRecordAmbiguous},
+		{group: "App", name: "replica", regex: true, err: ErrRecordNotFound},
+		{group: "App", name: "Unknown", err: ErrRecordNotFound},
+		{group: "Unknown", name: "Database", err: ErrRecordNotFound},
+	}
+
+	for _, tt := range tests {
+		idxEntry, err := ResolveRecord(index, tt.group, tt.name, tt.regex)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("Expected error %v for %s/%s, got %v", tt.err, tt.group, tt.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s/%s: %v", tt.group, tt.name, err)
+			continue
+		}
+		if idxEntry.Record.UUID != tt.uuid {
+			t.Errorf("Expected record %s for %s/%s, got %s", tt.uuid, tt.group, tt.name, idxEntry.Record.UUID)
+		}
+	}
+}
+
+func TestResolveRecordInvalidRegex(t *testing.T) {
+	if _, err := ResolveRecord(testIndex(), "App", "Database(", true); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+}
+
+func TestMatchRecords(t *testing.T) {
+	index := testIndex()
+
+	tests := []struct {
+		group       string
+		namePattern string
+		color       string
+		uuids       []string
+	}{
+		{group: "App", uuids: []string{"00000000-0000-0000-1001-000000000001", "00000000-0000-0000-1001-000000000002"}},
+		{group: "App", namePattern: "Database", uuids: []string{"00000000-0000-0000-1001-000000000001"}},
+		{group: "App", color: "green", uuids: []string{"00000000-0000-0000-1001-000000000002"}},
+		{group: "App", color: keyhubmodel.VaultRecordColorNone, uuids: []string{"00000000-0000-0000-1001-000000000001"}},
+		{group: "00000000-0000-0000-1002-000000000000", namePattern: "Data.*", uuids: []string{"00000000-0000-0000-1002-000000000001"}},
+		{group: "Unknown"},
+	}
+
+	for _, tt := range tests {
+		matches, err := MatchRecords(index, tt.group, tt.namePattern, tt.color)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", tt.group, err)
+			continue
+		}
+		uuids := []string{}
+		for _, idxEntry := range matches {
+			uuids = append(uuids, idxEntry.Record.UUID)
+		}
+		if strings.Join(uuids, ",") != strings.Join(tt.uuids, ",") {
+			t.Errorf("Expected records %v for %s/%s/%s, got %v", tt.uuids, tt.group, tt.namePattern, tt.color, uuids)
+		}
+	}
+
+	if _, err := MatchRecords(index, "App", "Database(", ""); err == nil {
+		t.Error("Expected an error for an invalid regular expression")
+	}
+}
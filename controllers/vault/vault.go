@@ -18,24 +18,37 @@ type VaultSecretRetriever interface {
 type vaultSecretRetriever struct {
 	log    logr.Logger
 	client *keyhub.Client
+	cache  VaultRecordCache
 }
 
-func NewVaultSecretRetriever(log logr.Logger, client *keyhub.Client) VaultSecretRetriever {
+// NewVaultSecretRetriever returns a retriever fetching the records with
+// client, the records are shared with other retrievers through cache. Without
+// cache every record is fetched from KeyHub.
+func NewVaultSecretRetriever(log logr.Logger, client *keyhub.Client, cache VaultRecordCache) VaultSecretRetriever {
 	return &vaultSecretRetriever{
 		log:    log,
 		client: client,
+		cache:  cache,
 	}
 }
 
 func (r *vaultSecretRetriever) Get(idxEntry VaultRecordWithGroup) (*keyhubmodel.VaultRecord, error) {
-	metrics.KeyHubApiRequests.WithLabelValues("vault", "get").Inc()
 	uuid, err := uuid.Parse(idxEntry.Record.UUID)
 	if err != nil {
 		return nil, err
 	}
-	return r.client.Vaults.GetByUUID(
-		&idxEntry.Group,
-		uuid,
-		&keyhubmodel.VaultRecordAdditionalQueryParams{Secret: true, Audit: true},
-	)
+	fetch := func() (*keyhubmodel.VaultRecord, error) {
+		metrics.KeyHubApiRequests.WithLabelValues("vault", "get").Inc()
+		return r.client.Vaults.GetByUUID(
+			&idxEntry.Group,
+			uuid,
+			&keyhubmodel.VaultRecordAdditionalQueryParams{Secret: true, Audit: true},
+		)
+	}
+	if r.cache == nil {
+		return fetch()
+	}
+	// The index of the client contains the record, so the client may read
+	// the record cached by another client
+	return r.cache.Get(idxEntry, fetch)
 }
@@ -0,0 +1,66 @@
+// Copyright 2021 Topicus Security BV
+// SPDX-License-Identifier: Apache-2.0
+
+package controllers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	keyhub "github.com/topicuskeyhub/go-keyhub"
+
+	controllerMetrics "github.com/topicuskeyhub/keyhub-vault-operator/controllers/metrics"
+	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/vault"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	controllers_test "github.com/topicuskeyhub/keyhub-vault-operator/controllers/test"
+)
+
+var _ = Describe("Vault Index Cache", func() {
+
+	BeforeEach(func() {
+		// Failed test runs that don't clean up leave resources behind.
+		cfg := controllers_test.BeforeEachInputs{Client: k8sClient}
+		controllers_test.CleanUp(&cfg)
+	})
+
+	Context("Concurrent reconciles", func() {
+		It("Should build the index of a client once", func() {
+			client, err := keyhub.NewClient(http.DefaultClient, keyhubMockServer.URL, "CLIENT-0001", "VERY_SECRET_PHRASE")
+			Expect(err).To(BeNil())
+
+			// A cache of its own, the reconcilers don't share its index
+			cache := vault.NewVaultIndexCache(ctrl.Log.WithName("VaultIndexCache"), time.Hour, time.Hour)
+			defer cache.Flush()
+
+			groupLists := controllerMetrics.KeyHubApiRequests.WithLabelValues("group", "list")
+			before := testutil.ToFloat64(groupLists)
+
+			const callers = 20
+			var wg sync.WaitGroup
+			sizes := make(chan int, callers)
+			for i := 0; i < callers; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					records, err := cache.Get(client)
+					Expect(err).To(BeNil())
+					sizes <- len(records)
+				}()
+			}
+			wg.Wait()
+			close(sizes)
+
+			for size := range sizes {
+				Expect(size).To(BeNumerically(">", 0))
+			}
+			// No KeyHubSecrets are reconciled meanwhile
+			Expect(testutil.ToFloat64(groupLists) - before).To(Equal(1.0))
+		})
+	})
+})
@@ -14,8 +14,10 @@ require (
 	github.com/prometheus/client_golang v1.19.1
 	github.com/topicuskeyhub/go-keyhub v1.3.5
 	golang.org/x/crypto v0.25.0
+	golang.org/x/time v0.0.0-20220609170525-579cf78fd858
 	gopkg.in/yaml.v3 v3.0.1
 	k8s.io/api v0.25.16
+	k8s.io/apiextensions-apiserver v0.25.15
 	k8s.io/apimachinery v0.25.16
 	k8s.io/client-go v0.25.16
 	sigs.k8s.io/controller-runtime v0.13.2
@@ -78,7 +80,6 @@ require (
 	golang.org/x/sys v0.22.0 // indirect
 	golang.org/x/term v0.22.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
-	golang.org/x/time v0.0.0-20220609170525-579cf78fd858 // indirect
 	golang.org/x/tools v0.23.0 // indirect
 	gomodules.xyz/jsonpatch/v2 v2.2.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
@@ -86,7 +87,6 @@ require (
 	gopkg.in/inf.v0 v0.9.1 // indirect
 	gopkg.in/square/go-jose.v2 v2.6.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
-	k8s.io/apiextensions-apiserver v0.25.15 // indirect
 	k8s.io/component-base v0.25.15 // indirect
 	k8s.io/klog/v2 v2.70.1 // indirect
 	k8s.io/kube-openapi v0.0.0-20220803162953-67bda5d908f1 // indirect
@@ -19,11 +19,13 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -32,6 +34,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	keyhubv1alpha1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1alpha1"
+	keyhubv1beta1 "github.com/topicuskeyhub/keyhub-vault-operator/api/v1beta1"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/policy"
 	"github.com/topicuskeyhub/keyhub-vault-operator/controllers/settings"
@@ -46,8 +49,10 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(keyhubv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(keyhubv1beta1.AddToScheme(scheme))
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -55,11 +60,41 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var certificateExpiryWindow time.Duration
+	var refreshInterval time.Duration
+	var refreshJitter float64
+	var retryBaseDelay time.Duration
+	var retryMaxDelay time.Duration
+	var vaultIndexRefreshInterval time.Duration
+	var vaultRecordCacheTTL time.Duration
+	var vaultRecordCacheEncryption bool
+	var vaultIndexMaxStaleness time.Duration
+	var migrateStorageVersion bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.DurationVar(&certificateExpiryWindow, "certificate-expiry-window", 30*24*time.Hour,
+		"The period before expiry in which a synced certificate is reported as expiring.")
+	flag.DurationVar(&refreshInterval, "refresh-interval", 5*time.Minute,
+		"The default interval in which secrets are synced with KeyHub.")
+	flag.Float64Var(&refreshJitter, "refresh-jitter", 0.1,
+		"The maximum fraction of the refresh interval added as random jitter.")
+	flag.DurationVar(&retryBaseDelay, "retry-base-delay", 30*time.Second,
+		"The delay before the first retry of a failed sync, doubled on every consecutive failure.")
+	flag.DurationVar(&retryMaxDelay, "retry-max-delay", 30*time.Minute,
+		"The maximum delay between retries of a failed sync.")
+	flag.DurationVar(&vaultIndexRefreshInterval, "vault-index-refresh-interval", 10*time.Minute,
+		"The interval in which the index of the KeyHub vault records is refreshed in the background.")
+	flag.DurationVar(&vaultIndexMaxStaleness, "vault-index-max-staleness", time.Hour,
+		"The maximum age of the index of the KeyHub vault records before syncs fail, when refreshes keep failing.")
+	flag.DurationVar(&vaultRecordCacheTTL, "vault-record-cache-ttl", 5*time.Minute,
+		"The time the KeyHub vault records fetched with their secrets are shared between syncs, 0 disables the cache.")
+	flag.BoolVar(&vaultRecordCacheEncryption, "vault-record-cache-encryption", true,
+		"Encrypt the cached KeyHub vault records in memory.")
+	flag.BoolVar(&migrateStorageVersion, "migrate-storage-version", true,
+		"Rewrite the KeyHubSecrets stored in a previous API version in the storage version on start.")
 	opts := zap.Options{
 		Development: false,
 	}
@@ -94,20 +129,69 @@ func main() {
 
 	vaultIndexCache := vault.NewVaultIndexCache(
 		ctrl.Log.WithName("VaultIndexCache"),
+		vaultIndexRefreshInterval,
+		vaultIndexMaxStaleness,
 	)
 
+	vaultRecordCache, err := vault.NewVaultRecordCache(vaultRecordCacheTTL, vaultRecordCacheEncryption)
+	if err != nil {
+		setupLog.Error(err, "unable to create vault record cache")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.KeyHubSecretReconciler{
-		Client:          mgr.GetClient(),
-		Log:             ctrl.Log.WithName("controllers").WithName("KeyHubSecret"),
-		Scheme:          mgr.GetScheme(),
-		Recorder:        mgr.GetEventRecorderFor("KeyHubSecret"),
-		SettingsManager: settingsMgr,
-		PolicyEngine:    policyEngine,
-		VaultIndexCache: vaultIndexCache,
+		Client:           mgr.GetClient(),
+		Log:              ctrl.Log.WithName("controllers").WithName("KeyHubSecret"),
+		Scheme:           mgr.GetScheme(),
+		Recorder:         mgr.GetEventRecorderFor("KeyHubSecret"),
+		SettingsManager:  settingsMgr,
+		PolicyEngine:     policyEngine,
+		VaultIndexCache:  vaultIndexCache,
+		VaultRecordCache: vaultRecordCache,
+
+		CertificateExpiryWindow: certificateExpiryWindow,
+		RefreshInterval:         refreshInterval,
+		RefreshJitter:           refreshJitter,
+		RetryBaseDelay:          retryBaseDelay,
+		RetryMaxDelay:           retryMaxDelay,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "KeyHubSecret")
 		os.Exit(1)
 	}
+	if err = (&controllers.ClusterKeyHubSecretReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ClusterKeyHubSecret"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("ClusterKeyHubSecret"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterKeyHubSecret")
+		os.Exit(1)
+	}
+	if err = (&controllers.KeyHubPolicyReconciler{
+		Client:       mgr.GetClient(),
+		Log:          ctrl.Log.WithName("controllers").WithName("KeyHubPolicy"),
+		Recorder:     mgr.GetEventRecorderFor("KeyHubPolicy"),
+		PolicyEngine: policyEngine,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "KeyHubPolicy")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&keyhubv1beta1.KeyHubSecret{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "KeyHubSecret")
+			os.Exit(1)
+		}
+	}
+	if migrateStorageVersion {
+		if err := mgr.Add(&controllers.StorageVersionMigrator{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Log:       ctrl.Log.WithName("StorageVersionMigrator"),
+		}); err != nil {
+			setupLog.Error(err, "unable to add storage version migrator")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("health", healthz.Ping); err != nil {